@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// API key scopes
+const (
+	ScopeRead   = "read"
+	ScopeCrawl  = "crawl"
+	ScopeExport = "export"
+)
+
+var Scopes = []string{ScopeRead, ScopeCrawl, ScopeExport}
+
+const API_KEY_PREFIX = "dm_"
+
+// GenerateAPIKey returns a new key, the short prefix we display to identify it, and the hash we store.
+func GenerateAPIKey() (string, string, string, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", "", fmt.Errorf("could not generate api key: %v", err)
+	}
+	key := API_KEY_PREFIX + hex.EncodeToString(raw)
+	return key, key[:len(API_KEY_PREFIX)+8], HashAPIKey(key), nil
+}
+
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidScopes drops any unknown scopes, and keeps the rest in a consistent order.
+func ValidScopes(requested []string) []string {
+	scopes := make([]string, 0, len(Scopes))
+	for _, scope := range Scopes {
+		for _, r := range requested {
+			if strings.TrimSpace(r) == scope {
+				scopes = append(scopes, scope)
+				break
+			}
+		}
+	}
+	return scopes
+}
+
+func HasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package auth
+
+import "testing"
+
+func TestValidCSRFToken(t *testing.T) {
+	t.Setenv("JWT_SECRET_TOKEN", "test-secret")
+	session, err := NewCSRFSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewCSRFSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		session string
+		token   string
+		want    bool
+	}{
+		{"matching token", session, CSRFToken(session), true},
+		{"missing token", session, "", false},
+		{"missing session", "", CSRFToken(session), false},
+		{"wrong token", session, "not-a-token", false},
+		{"token from another session", session, CSRFToken(other), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidCSRFToken(tt.session, tt.token); got != tt.want {
+				t.Errorf("ValidCSRFToken() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSRFTokenDependsOnSecret(t *testing.T) {
+	t.Setenv("JWT_SECRET_TOKEN", "test-secret")
+	token := CSRFToken("session")
+	t.Setenv("JWT_SECRET_TOKEN", "another-secret")
+	if ValidCSRFToken("session", token) {
+		t.Error("token signed with another secret was accepted")
+	}
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+const SESSION_TTL = 24 * time.Hour            // Lifetime of a session token
+const REFRESH_AFTER = SESSION_TTL / 2         // Age at which an active session is issued a fresh token
+const REVOKED_KEY_PREFIX = "revoked_session:" // Redis key prefix for revoked session ids
+
+// Session token claims. The subject is the user_id, and the jti identifies the session:
+// refreshed tokens keep the same jti, so revoking it ends the session for every token issued to it.
+type Claims struct {
+	jwt.StandardClaims
+}
+
+// IssueToken signs a new session token for the user. Pass an empty sessionID to start a new session.
+func IssueToken(userID string, sessionID string) (string, *Claims, error) {
+	if sessionID == "" {
+		sessionID = uuid.New().String()
+	}
+	now := time.Now()
+	claims := &Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   userID,
+			Id:        sessionID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(SESSION_TTL).Unix(),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret())
+	if err != nil {
+		return "", nil, fmt.Errorf("could not sign session token: %v", err)
+	}
+	return token, claims, nil
+}
+
+// ParseToken verifies the token's signature, expiry and issue time, and returns its claims.
+func ParseToken(token string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return secret(), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid session token: %v", err)
+	}
+	if claims.Subject == "" || claims.Id == "" || claims.IssuedAt == 0 || claims.ExpiresAt == 0 {
+		return nil, fmt.Errorf("invalid session token: missing claims")
+	}
+	return claims, nil
+}
+
+// NeedsRefresh reports whether the token is old enough that an active user should get a new one.
+func NeedsRefresh(claims *Claims) bool {
+	return time.Since(time.Unix(claims.IssuedAt, 0)) >= REFRESH_AFTER
+}
+
+// Revoke adds the session to the revocation list. It stays listed until any token issued to it would have expired.
+func Revoke(ctx context.Context, rdb *redis.Client, sessionID string) error {
+	if rdb == nil {
+		return nil
+	}
+	err := rdb.Set(ctx, REVOKED_KEY_PREFIX+sessionID, 1, SESSION_TTL).Err()
+	if err != nil {
+		return fmt.Errorf("could not revoke session: %v", err)
+	}
+	return nil
+}
+
+func IsRevoked(ctx context.Context, rdb *redis.Client, sessionID string) (bool, error) {
+	if rdb == nil {
+		return false, nil
+	}
+	count, err := rdb.Exists(ctx, REVOKED_KEY_PREFIX+sessionID).Result()
+	if err != nil {
+		return false, fmt.Errorf("could not check session revocation: %v", err)
+	}
+	return count > 0, nil
+}
+
+func secret() []byte {
+	return []byte(os.Getenv("JWT_SECRET_TOKEN"))
+}
@@ -0,0 +1,64 @@
+package auth
+
+// System roles, an account's role across every workspace
+const (
+	RoleAdmin    = "admin"     // Operates the system
+	RoleMember   = "member"    // The default for new accounts
+	RoleReadOnly = "read-only" // Can look at and take away results, but not crawl
+)
+
+var Roles = []string{RoleAdmin, RoleMember, RoleReadOnly}
+
+// Permissions checked on routes
+const (
+	PermCrawl    = "crawl"    // Start crawls
+	PermKill     = "kill"     // Stop crawls
+	PermExport   = "export"   // Export results
+	PermDownload = "download" // Download collected files
+	PermAdmin    = "admin"    // Administer users and the system
+)
+
+var rolePermissions = map[string][]string{
+	RoleAdmin:    {PermCrawl, PermKill, PermExport, PermDownload, PermAdmin},
+	RoleMember:   {PermCrawl, PermKill, PermExport, PermDownload},
+	RoleReadOnly: {PermExport, PermDownload},
+}
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func RoleHasPermission(role string, permission string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Workspace roles, each role can do everything the roles after it can
+const (
+	RoleOwner  = "owner"  // Manages the workspace's members
+	RoleEditor = "editor" // Starts and stops crawls
+	RoleViewer = "viewer" // Views and exports results
+)
+
+var WorkspaceRoles = []string{RoleOwner, RoleEditor, RoleViewer}
+
+var workspaceRoleRank = map[string]int{
+	RoleOwner:  3,
+	RoleEditor: 2,
+	RoleViewer: 1,
+}
+
+func ValidWorkspaceRole(role string) bool {
+	_, ok := workspaceRoleRank[role]
+	return ok
+}
+
+// WorkspaceRoleAllows reports whether role includes everything the required role can do.
+func WorkspaceRoleAllows(role string, required string) bool {
+	return workspaceRoleRank[role] > 0 && workspaceRoleRank[role] >= workspaceRoleRank[required]
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const TOTP_ISSUER = "Data Manager" // Shown next to the account in authenticator apps
+const TOTP_PERIOD = 30             // Seconds each code is valid for
+const TOTP_DIGITS = 6              // Length of each code
+const TOTP_SKEW = 1                // Periods either side of now we accept, to allow for clock drift
+const RECOVERY_CODE_COUNT = 10     // Recovery codes issued when 2FA is enabled
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a new base32 encoded secret for an authenticator app.
+func GenerateTOTPSecret() (string, error) {
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("could not generate totp secret: %v", err)
+	}
+	return totpEncoding.EncodeToString(raw), nil
+}
+
+// TOTPProvisioningURI is the otpauth:// URI authenticator apps scan from a QR code.
+func TOTPProvisioningURI(secret string, account string) string {
+	label := url.PathEscape(TOTP_ISSUER + ":" + account)
+	params := url.Values{
+		"secret":    {secret},
+		"issuer":    {TOTP_ISSUER},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(TOTP_DIGITS)},
+		"period":    {fmt.Sprint(TOTP_PERIOD)},
+	}
+	// Authenticator apps expect spaces as %20, not +
+	return "otpauth://totp/" + label + "?" + strings.ReplaceAll(params.Encode(), "+", "%20")
+}
+
+// ValidateTOTP checks a code against the secret (RFC 6238), allowing for a little clock drift.
+// It returns the time step the code was issued for, so the caller can refuse to accept it twice.
+func ValidateTOTP(secret string, code string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != TOTP_DIGITS {
+		return 0, false
+	}
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+	current := now.Unix() / TOTP_PERIOD
+	for step := current - TOTP_SKEW; step <= current+TOTP_SKEW; step++ {
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func totpCode(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < TOTP_DIGITS; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, value%mod)
+}
+
+// GenerateRecoveryCodes returns one-time codes for when the user loses their authenticator, and the hashes we store.
+func GenerateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, RECOVERY_CODE_COUNT)
+	hashes := make([]string, 0, RECOVERY_CODE_COUNT)
+	for i := 0; i < RECOVERY_CODE_COUNT; i++ {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, fmt.Errorf("could not generate recovery code: %v", err)
+		}
+		code := hex.EncodeToString(raw)
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode ignores case, spaces and dashes, so codes can be typed however they were written down.
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
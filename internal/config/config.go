@@ -1,11 +1,15 @@
 package config
 
 import (
-	"log"
+	"fmt"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+
+	"github.com/Ztkent/data-manager/internal/logging"
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/xpath"
 )
 
 type Config struct {
@@ -26,6 +30,17 @@ type Config struct {
 	LiveLogging           bool     `json:"live_logging"`
 	SqliteEnabled         bool     `json:"sqlite_enabled"`
 	SqlitePath            string   `json:"sqlite_path"`
+
+	ExtractionRules []ExtractionRule `json:"extraction_rules,omitempty"`
+}
+
+// A named rule applied to every collected page after the crawl
+type ExtractionRule struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"` // "css" or "xpath"
+	Selector  string `json:"selector"`
+	Attribute string `json:"attribute,omitempty"` // Extract this attribute instead of the text
+	Regex     string `json:"regex,omitempty"`     // Optional, keeps the first capture group or the whole match
 }
 
 func NewDefaultConfig() *Config {
@@ -53,7 +68,7 @@ func NewDefaultConfig() *Config {
 func WriteJsonToFile(json []byte, path string) string {
 	file, err := os.Create(path)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("could not write config file", err)
 	}
 	defer file.Close()
 	file.Write(json)
@@ -106,6 +121,12 @@ func ParseFormToConfig(form map[string][]string, outputPath string) (*Config, er
 			}
 		}
 	}
+	rules, err := parseExtractionRules(form)
+	if err != nil {
+		return nil, err
+	}
+	config.ExtractionRules = rules
+
 	if !config.FreeCrawl && config.StartingURL != "" &&
 		len(config.PermittedDomains) == 1 && config.PermittedDomains[0] == "" {
 		// If no permitted domains are specified, use the starting URL as the only permitted domain
@@ -120,3 +141,52 @@ func ParseFormToConfig(form map[string][]string, outputPath string) (*Config, er
 	config.SqlitePath = outputPath
 	return config, nil
 }
+
+// Rules are submitted as parallel lists, one entry per rule row
+func parseExtractionRules(form map[string][]string) ([]ExtractionRule, error) {
+	rules := make([]ExtractionRule, 0)
+	names := form["RuleName"]
+	for i := range names {
+		rule := ExtractionRule{
+			Name:      strings.TrimSpace(names[i]),
+			Type:      formIndex(form, "RuleType", i),
+			Selector:  strings.TrimSpace(formIndex(form, "RuleSelector", i)),
+			Attribute: strings.TrimSpace(formIndex(form, "RuleAttribute", i)),
+			Regex:     formIndex(form, "RuleRegex", i),
+		}
+		if rule.Name == "" && rule.Selector == "" {
+			// Empty row
+			continue
+		}
+		if rule.Name == "" || rule.Selector == "" {
+			return nil, fmt.Errorf("extraction rules require a name and selector")
+		}
+		// Check the selector now, a bad one would otherwise only fail after the crawl finishes
+		var err error
+		switch rule.Type {
+		case "css":
+			_, err = cascadia.Parse(rule.Selector)
+		case "xpath":
+			_, err = xpath.Compile(rule.Selector)
+		default:
+			return nil, fmt.Errorf("invalid extraction rule type: %s", rule.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector for extraction rule %s: %v", rule.Name, err)
+		}
+		if rule.Regex != "" {
+			if _, err := regexp.Compile(rule.Regex); err != nil {
+				return nil, fmt.Errorf("invalid extraction rule regex: %v", err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func formIndex(form map[string][]string, key string, i int) string {
+	if values := form[key]; i < len(values) {
+		return values[i]
+	}
+	return ""
+}
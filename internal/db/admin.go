@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const MAX_ADMIN_USERS = 500      // Number of users listed in the admin console
+const MAX_RECENT_CRAWL_JOBS = 50 // Number of jobs listed in the admin console
+
+// Crawl job statuses
+const (
+	JOB_RUNNING  = "running"
+	JOB_FINISHED = "finished"
+	JOB_FAILED   = "failed"
+	JOB_KILLED   = "killed"
+)
+
+type UserSummary struct {
+	ID         string
+	Email      string
+	Role       string
+	Verified   bool
+	Disabled   bool
+	CreatedAt  time.Time
+	LastSeenAt *time.Time // Nil if the user has no sessions
+}
+
+type CrawlJob struct {
+	ID          string
+	WorkspaceID string
+	UserID      string
+	Email       string // Email of the user who started the crawl, only set when listing jobs
+	StartingURL string
+	Status      string
+	Error       string
+	StartedAt   time.Time
+	FinishedAt  *time.Time
+}
+
+// GetUsers lists every account, newest first.
+func (db *database) GetUsers(ctx context.Context) ([]UserSummary, error) {
+	if db.db == nil {
+		return nil, fmt.Errorf("database is nil")
+	}
+	return db.getUserSummaries(ctx, "ORDER BY u.created_at DESC LIMIT $1", MAX_ADMIN_USERS)
+}
+
+func (db *database) getUserSummaries(ctx context.Context, clause string, args ...interface{}) ([]UserSummary, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT u.user_id, u.email, u.role, u.email_verified_at IS NOT NULL, u.disabled_at IS NOT NULL, u.created_at,
+			(SELECT MAX(COALESCE(a.last_seen_at, a.updated_at)) FROM auth a WHERE a.user_id = u.user_id)
+		FROM users u
+		`+clause, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query postgres: %v", err)
+	}
+	defer rows.Close()
+	var users []UserSummary
+	for rows.Next() {
+		var u UserSummary
+		var lastSeen sql.NullTime
+		if err := rows.Scan(&u.ID, &u.Email, &u.Role, &u.Verified, &u.Disabled, &u.CreatedAt, &lastSeen); err != nil {
+			return nil, fmt.Errorf("could not scan postgres: %v", err)
+		}
+		if lastSeen.Valid {
+			u.LastSeenAt = &lastSeen.Time
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate postgres: %v", err)
+	}
+	return users, nil
+}
+
+// DisableUser stops the user from logging in, and removes their sessions. It returns the removed session ids.
+func (db *database) DisableUser(ctx context.Context, userID string) ([]string, error) {
+	if db.db == nil {
+		return nil, fmt.Errorf("database is nil")
+	}
+	result, err := db.db.ExecContext(ctx, "UPDATE users SET disabled_at = COALESCE(disabled_at, NOW()), updated_at = NOW() WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not disable user: %v", err)
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return nil, fmt.Errorf("could not find user")
+	}
+	return db.DeleteOtherSessions(ctx, userID, "")
+}
+
+func (db *database) EnableUser(ctx context.Context, userID string) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	_, err := db.db.ExecContext(ctx, "UPDATE users SET disabled_at = NULL, updated_at = NOW() WHERE user_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("could not enable user: %v", err)
+	}
+	return nil
+}
+
+func (db *database) StartCrawlJob(ctx context.Context, job CrawlJob) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	_, err := db.db.ExecContext(ctx, `
+		INSERT INTO crawl_jobs (job_id, workspace_id, user_id, starting_url, status, started_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, job.ID, job.WorkspaceID, job.UserID, job.StartingURL, JOB_RUNNING, job.StartedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("could not insert crawl job: %v", err)
+	}
+	return nil
+}
+
+func (db *database) FinishCrawlJob(ctx context.Context, jobID, status, jobErr string, finishedAt time.Time) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	_, err := db.db.ExecContext(ctx, `
+		UPDATE crawl_jobs SET status = $2, error = $3, finished_at = $4
+		WHERE job_id = $1
+	`, jobID, status, jobErr, finishedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("could not update crawl job: %v", err)
+	}
+	return nil
+}
+
+// AbandonRunningCrawlJobs marks jobs that were running when the server stopped as failed.
+func (db *database) AbandonRunningCrawlJobs(ctx context.Context) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	_, err := db.db.ExecContext(ctx, `
+		UPDATE crawl_jobs SET status = $1, error = 'server restarted', finished_at = NOW()
+		WHERE status = $2
+	`, JOB_FAILED, JOB_RUNNING)
+	if err != nil {
+		return fmt.Errorf("could not update crawl jobs: %v", err)
+	}
+	return nil
+}
+
+// GetRecentCrawlJobs lists the latest jobs across every workspace, newest first.
+func (db *database) GetRecentCrawlJobs(ctx context.Context) ([]CrawlJob, error) {
+	if db.db == nil {
+		return nil, fmt.Errorf("database is nil")
+	}
+	return db.getCrawlJobs(ctx, "ORDER BY j.started_at DESC LIMIT $1", MAX_RECENT_CRAWL_JOBS)
+}
+
+func (db *database) getCrawlJobs(ctx context.Context, clause string, args ...interface{}) ([]CrawlJob, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT j.job_id, j.workspace_id, j.user_id, u.email, j.starting_url, j.status, j.error, j.started_at, j.finished_at
+		FROM crawl_jobs j
+		JOIN users u ON u.user_id = j.user_id
+		`+clause, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query postgres: %v", err)
+	}
+	defer rows.Close()
+	var jobs []CrawlJob
+	for rows.Next() {
+		var job CrawlJob
+		var finishedAt sql.NullTime
+		if err := rows.Scan(&job.ID, &job.WorkspaceID, &job.UserID, &job.Email, &job.StartingURL, &job.Status, &job.Error, &job.StartedAt, &finishedAt); err != nil {
+			return nil, fmt.Errorf("could not scan postgres: %v", err)
+		}
+		if finishedAt.Valid {
+			job.FinishedAt = &finishedAt.Time
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate postgres: %v", err)
+	}
+	return jobs, nil
+}
+
+func (db *database) Ping(ctx context.Context) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	return db.db.PingContext(ctx)
+}
+
+func (db *database) Stats() sql.DBStats {
+	if db.db == nil {
+		return sql.DBStats{}
+	}
+	return db.db.Stats()
+}
+
+func (db *database) Close() error {
+	if db.db == nil {
+		return nil
+	}
+	return db.db.Close()
+}
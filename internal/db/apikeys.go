@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type APIKey struct {
+	ID         int
+	UserID     string
+	Name       string
+	Prefix     string
+	Scopes     []string
+	CreatedAt  time.Time
+	LastUsedAt sql.NullTime
+}
+
+func (db *database) CreateAPIKey(ctx context.Context, userID, name, prefix, keyHash string, scopes []string) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	_, err := db.db.ExecContext(ctx, `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, created_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+	`, userID, name, prefix, keyHash, strings.Join(scopes, ","))
+	if err != nil {
+		return fmt.Errorf("could not insert api key: %v", err)
+	}
+	return nil
+}
+
+// GetAPIKeys lists the user's keys that haven't been revoked.
+func (db *database) GetAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	if db.db == nil {
+		return nil, fmt.Errorf("database is nil")
+	}
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT id, user_id, name, prefix, scopes, created_at, last_used_at
+		FROM api_keys
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY id
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not query postgres: %v", err)
+	}
+	defer rows.Close()
+	var keys []APIKey
+	for rows.Next() {
+		var key APIKey
+		var scopes string
+		if err := rows.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &scopes, &key.CreatedAt, &key.LastUsedAt); err != nil {
+			return nil, fmt.Errorf("could not scan postgres: %v", err)
+		}
+		key.Scopes = splitScopes(scopes)
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate postgres: %v", err)
+	}
+	return keys, nil
+}
+
+// AuthenticateAPIKey finds the active key with this hash, and records that it was used.
+// Keys of disabled accounts, or accounts waiting to be deleted, aren't active.
+func (db *database) AuthenticateAPIKey(ctx context.Context, keyHash string) (APIKey, error) {
+	if db.db == nil {
+		return APIKey{}, fmt.Errorf("database is nil")
+	}
+	var key APIKey
+	var scopes string
+	err := db.db.QueryRowContext(ctx, `
+		UPDATE api_keys
+		SET last_used_at = NOW()
+		WHERE key_hash = $1 AND revoked_at IS NULL AND user_id IN (
+			SELECT user_id FROM users WHERE disabled_at IS NULL AND deletion_requested_at IS NULL
+		)
+		RETURNING id, user_id, name, prefix, scopes, created_at, last_used_at
+	`, keyHash).Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &scopes, &key.CreatedAt, &key.LastUsedAt)
+	if err != nil {
+		return APIKey{}, fmt.Errorf("invalid api key")
+	}
+	key.Scopes = splitScopes(scopes)
+	return key, nil
+}
+
+func (db *database) RevokeAPIKey(ctx context.Context, userID string, id int) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	_, err := db.db.ExecContext(ctx, `
+		UPDATE api_keys
+		SET revoked_at = NOW()
+		WHERE user_id = $1 AND id = $2 AND revoked_at IS NULL
+	`, userID, id)
+	if err != nil {
+		return fmt.Errorf("could not revoke api key: %v", err)
+	}
+	return nil
+}
+
+func splitScopes(scopes string) []string {
+	if scopes == "" {
+		return nil
+	}
+	return strings.Split(scopes, ",")
+}
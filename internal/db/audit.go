@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const MAX_AUDIT_EVENTS = 500 // Number of events returned by an audit log query
+
+// Audit log actions
+const (
+	AUDIT_REGISTER         = "register"
+	AUDIT_LOGIN            = "login"
+	AUDIT_LOGOUT           = "logout"
+	AUDIT_CRAWL_START      = "crawl_start"
+	AUDIT_CRAWL_KILL       = "crawl_kill"
+	AUDIT_EXPORT           = "export"
+	AUDIT_DOWNLOAD         = "download"
+	AUDIT_ACCOUNT_EXPORT   = "account_export"
+	AUDIT_ACCOUNT_DELETE   = "account_delete"
+	AUDIT_ADMIN_ROLE       = "admin_role"
+	AUDIT_ADMIN_DISABLE    = "admin_disable"
+	AUDIT_ADMIN_ENABLE     = "admin_enable"
+	AUDIT_ADMIN_PURGE      = "admin_purge"
+	AUDIT_ADMIN_CRAWL_KILL = "admin_crawl_kill"
+)
+
+type AuditEvent struct {
+	ID          int64
+	ActorID     string // The user who acted
+	Action      string
+	WorkspaceID string // The workspace acted on, if any
+	Target      string // What was acted on, a URL, table, file or user id
+	Detail      string
+	IPAddress   string
+	UserAgent   string
+	CreatedAt   time.Time
+}
+
+type AuditFilter struct {
+	ActorID string
+	Since   time.Time
+	Until   time.Time
+}
+
+func (db *database) RecordAuditEvent(ctx context.Context, event AuditEvent) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	_, err := db.db.ExecContext(ctx, `
+		INSERT INTO audit_log (actor_id, action, workspace_id, target, detail, ip_address, user_agent, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+	`, event.ActorID, event.Action, event.WorkspaceID, event.Target, event.Detail, event.IPAddress, event.UserAgent)
+	if err != nil {
+		return fmt.Errorf("could not insert audit event: %v", err)
+	}
+	return nil
+}
+
+// GetAuditEvents lists the events matching the filter, newest first. Zero values in the filter match everything.
+func (db *database) GetAuditEvents(ctx context.Context, filter AuditFilter) ([]AuditEvent, error) {
+	if db.db == nil {
+		return nil, fmt.Errorf("database is nil")
+	}
+	var conditions []string
+	var args []interface{}
+	if filter.ActorID != "" {
+		args = append(args, filter.ActorID)
+		conditions = append(conditions, fmt.Sprintf("actor_id = $%d", len(args)))
+	}
+	if !filter.Since.IsZero() {
+		args = append(args, filter.Since.UTC())
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if !filter.Until.IsZero() {
+		args = append(args, filter.Until.UTC())
+		conditions = append(conditions, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, MAX_AUDIT_EVENTS)
+	return db.queryAuditEvents(ctx, fmt.Sprintf(`
+		SELECT id, actor_id, action, workspace_id, target, detail, ip_address, user_agent, created_at
+		FROM audit_log
+		%s
+		ORDER BY created_at DESC, id DESC
+		LIMIT $%d
+	`, where, len(args)), args...)
+}
+
+// GetUserAuditEvents lists every event the user did, or that was done to them, oldest first.
+func (db *database) GetUserAuditEvents(ctx context.Context, userID string) ([]AuditEvent, error) {
+	if db.db == nil {
+		return nil, fmt.Errorf("database is nil")
+	}
+	return db.queryAuditEvents(ctx, `
+		SELECT id, actor_id, action, workspace_id, target, detail, ip_address, user_agent, created_at
+		FROM audit_log
+		WHERE actor_id = $1 OR target = $1
+		ORDER BY created_at, id
+	`, userID)
+}
+
+func (db *database) queryAuditEvents(ctx context.Context, query string, args ...interface{}) ([]AuditEvent, error) {
+	rows, err := db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query postgres: %v", err)
+	}
+	defer rows.Close()
+	var events []AuditEvent
+	for rows.Next() {
+		var e AuditEvent
+		if err := rows.Scan(&e.ID, &e.ActorID, &e.Action, &e.WorkspaceID, &e.Target, &e.Detail, &e.IPAddress, &e.UserAgent, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("could not scan postgres: %v", err)
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate postgres: %v", err)
+	}
+	return events, nil
+}
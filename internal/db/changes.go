@@ -0,0 +1,372 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Ztkent/data-manager/internal/processor"
+)
+
+const MAX_CRAWL_RUNS = 50 // Number of runs shown in the crawl history
+
+type CrawlRun struct {
+	ID          string
+	StartingURL string
+	StartedAt   time.Time
+	FinishedAt  time.Time
+	Pages       int
+	Duplicates  int
+	PreviousID  string // Previous run of the same starting URL, if any
+}
+
+type PageChange struct {
+	URL  string
+	Kind string
+}
+
+type NearDuplicate struct {
+	URL         string
+	DuplicateOf string
+}
+
+type CrawlDiff struct {
+	From       CrawlRun
+	To         CrawlRun
+	New        []PageChange
+	Removed    []PageChange
+	Changed    []PageChange
+	Duplicates []NearDuplicate
+}
+
+type runPage struct {
+	url         string
+	kind        string // "page" or "image"
+	contentHash string
+	simhash     uint64
+	hasSimhash  bool
+	duplicateOf string
+}
+
+// RecordCrawlRun snapshots the pages and images collected by a run, with their content hashes,
+// and flags pages that are near-duplicates of another page in the same run.
+// Other crawls of the workspace may be writing at the same time, so only pages reached from the run's starting URL are included.
+func (db *database) RecordCrawlRun(ctx context.Context, runID string, startingURL string, startedAt time.Time, finishedAt time.Time) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	since := startedAt.UTC().Format("2006-01-02 15:04:05")
+	crawled, err := db.crawledURLs(ctx, startingURL, since)
+	if err != nil {
+		return err
+	}
+	pages := make(map[string]*runPage)
+	getPage := func(kind, url string) *runPage {
+		key := kind + " " + url
+		if pages[key] == nil {
+			pages[key] = &runPage{url: url, kind: kind}
+		}
+		return pages[key]
+	}
+	for url := range crawled {
+		getPage("page", url)
+	}
+
+	for _, table := range []string{"html", "images"} {
+		exists, err := db.tableExists(ctx, table)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		var query string
+		switch table {
+		case "html":
+			query = "SELECT url, '', html FROM html WHERE CAST(updated_at AS TEXT) >= $1"
+		case "images":
+			query = "SELECT url, COALESCE(referrer, ''), image FROM images WHERE success = 1 AND image IS NOT NULL AND CAST(updated_at AS TEXT) >= $1"
+		}
+		rows, err := db.db.QueryContext(ctx, query, since)
+		if err != nil {
+			return fmt.Errorf("could not query sqlite: %v", err)
+		}
+		for rows.Next() {
+			var url, referrer string
+			var content []byte
+			if err := rows.Scan(&url, &referrer, &content); err != nil {
+				rows.Close()
+				return fmt.Errorf("could not scan sqlite: %v", err)
+			}
+			switch table {
+			case "html":
+				if !crawled[url] {
+					continue
+				}
+				p := getPage("page", url)
+				sum := sha256.Sum256(content)
+				p.contentHash = hex.EncodeToString(sum[:])
+				// Pages without text would all share the empty fingerprint
+				if text := processor.ExtractText(string(content)); strings.TrimSpace(text) != "" {
+					p.simhash = processor.Simhash(text)
+					p.hasSimhash = true
+				}
+			case "images":
+				if !crawled[url] && !crawled[referrer] {
+					continue
+				}
+				p := getPage("image", url)
+				sum := sha256.Sum256(content)
+				p.contentHash = hex.EncodeToString(sum[:])
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("could not iterate sqlite: %v", err)
+		}
+	}
+
+	// Compare each page against the pages before it, the first page of a group is the original.
+	// Originals are indexed by content hash and simhash band, so each page is only compared with likely matches.
+	ordered := make([]*runPage, 0, len(pages))
+	for _, p := range pages {
+		ordered = append(ordered, p)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].kind == ordered[j].kind {
+			return ordered[i].url < ordered[j].url
+		}
+		return ordered[i].kind < ordered[j].kind
+	})
+	byHash := make(map[string]int)
+	byBand := make(map[uint64][]int)
+	for i, p := range ordered {
+		if p.contentHash == "" {
+			continue
+		}
+		match := -1
+		if j, ok := byHash[p.kind+" "+p.contentHash]; ok {
+			match = j
+		}
+		if p.hasSimhash {
+			for _, key := range processor.SimhashBands(p.simhash) {
+				for _, j := range byBand[key] {
+					if match >= 0 && j >= match {
+						break
+					}
+					if ordered[j].kind == p.kind && processor.IsNearDuplicate(ordered[j].simhash, p.simhash) {
+						match = j
+						break
+					}
+				}
+			}
+		}
+		if match >= 0 {
+			p.duplicateOf = ordered[match].url
+			continue
+		}
+		byHash[p.kind+" "+p.contentHash] = i
+		if p.hasSimhash {
+			for _, key := range processor.SimhashBands(p.simhash) {
+				byBand[key] = append(byBand[key], i)
+			}
+		}
+	}
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO crawl_runs (id, starting_url, started_at, finished_at)
+		VALUES ($1, $2, $3, $4)
+	`, runID, startingURL, since, finishedAt.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return fmt.Errorf("could not insert crawl run: %v", err)
+	}
+	for _, p := range ordered {
+		var simhash interface{}
+		if p.hasSimhash {
+			simhash = int64(p.simhash)
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO crawl_run_pages (run_id, url, kind, content_hash, simhash, duplicate_of)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, runID, p.url, p.kind, p.contentHash, simhash, p.duplicateOf)
+		if err != nil {
+			return fmt.Errorf("could not insert crawl run page: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// crawledURLs follows the referrer chain of the pages visited since the run started, from its starting URL.
+func (db *database) crawledURLs(ctx context.Context, startingURL string, since string) (map[string]bool, error) {
+	crawled := make(map[string]bool)
+	exists, err := db.tableExists(ctx, "visited")
+	if err != nil || !exists {
+		return crawled, err
+	}
+	rows, err := db.db.QueryContext(ctx, "SELECT url, COALESCE(referrer, '') FROM visited WHERE CAST(last_visited_at AS TEXT) >= $1", since)
+	if err != nil {
+		return nil, fmt.Errorf("could not query sqlite: %v", err)
+	}
+	defer rows.Close()
+	referred := make(map[string][]string)
+	for rows.Next() {
+		var url, referrer string
+		if err := rows.Scan(&url, &referrer); err != nil {
+			return nil, fmt.Errorf("could not scan sqlite: %v", err)
+		}
+		referred[referrer] = append(referred[referrer], url)
+		if sameURL(url, startingURL) {
+			crawled[url] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate sqlite: %v", err)
+	}
+
+	// The crawler may record the seed as its pages' referrer without visiting it under the same spelling
+	queue := []string{}
+	for referrer := range referred {
+		if sameURL(referrer, startingURL) || crawled[referrer] {
+			queue = append(queue, referrer)
+		}
+	}
+	for len(queue) > 0 {
+		referrer := queue[0]
+		queue = queue[1:]
+		for _, url := range referred[referrer] {
+			if !crawled[url] {
+				crawled[url] = true
+				queue = append(queue, url)
+			}
+		}
+	}
+	return crawled, nil
+}
+
+func sameURL(a string, b string) bool {
+	return strings.TrimSuffix(a, "/") == strings.TrimSuffix(b, "/")
+}
+
+func (db *database) GetCrawlRuns(ctx context.Context) ([]CrawlRun, error) {
+	if db.db == nil {
+		return nil, fmt.Errorf("database is nil")
+	}
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT r.id, r.starting_url, r.started_at, r.finished_at,
+			(SELECT COUNT(*) FROM crawl_run_pages p WHERE p.run_id = r.id),
+			(SELECT COUNT(*) FROM crawl_run_pages p WHERE p.run_id = r.id AND p.duplicate_of != ''),
+			COALESCE((SELECT prev.id FROM crawl_runs prev
+				WHERE prev.starting_url = r.starting_url AND prev.started_at < r.started_at
+				ORDER BY prev.started_at DESC LIMIT 1), '')
+		FROM crawl_runs r
+		ORDER BY r.started_at DESC
+		LIMIT $1
+	`, MAX_CRAWL_RUNS)
+	if err != nil {
+		return nil, fmt.Errorf("could not query sqlite: %v", err)
+	}
+	defer rows.Close()
+	var runs []CrawlRun
+	for rows.Next() {
+		var run CrawlRun
+		if err := rows.Scan(&run.ID, &run.StartingURL, &run.StartedAt, &run.FinishedAt, &run.Pages, &run.Duplicates, &run.PreviousID); err != nil {
+			return nil, fmt.Errorf("could not scan sqlite: %v", err)
+		}
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate sqlite: %v", err)
+	}
+	return runs, nil
+}
+
+// DiffCrawlRuns lists the pages that are new, removed or changed between two runs of the same starting URL.
+func (db *database) DiffCrawlRuns(ctx context.Context, fromID string, toID string) (CrawlDiff, error) {
+	if db.db == nil {
+		return CrawlDiff{}, fmt.Errorf("database is nil")
+	}
+	var diff CrawlDiff
+	ids := []string{fromID, toID}
+	for i, run := range []*CrawlRun{&diff.From, &diff.To} {
+		id := ids[i]
+		err := db.db.QueryRowContext(ctx, `
+			SELECT id, starting_url, started_at, finished_at
+			FROM crawl_runs
+			WHERE id = $1
+		`, id).Scan(&run.ID, &run.StartingURL, &run.StartedAt, &run.FinishedAt)
+		if err != nil {
+			return CrawlDiff{}, fmt.Errorf("could not find crawl run %s: %v", id, err)
+		}
+	}
+	if diff.From.StartingURL != diff.To.StartingURL {
+		return CrawlDiff{}, fmt.Errorf("crawl runs have different starting urls")
+	}
+
+	fromPages, err := db.getRunPages(ctx, fromID)
+	if err != nil {
+		return CrawlDiff{}, err
+	}
+	toPages, err := db.getRunPages(ctx, toID)
+	if err != nil {
+		return CrawlDiff{}, err
+	}
+	diff.From.Pages = len(fromPages)
+	diff.To.Pages = len(toPages)
+
+	for key, to := range toPages {
+		change := PageChange{URL: to.url, Kind: to.kind}
+		from, ok := fromPages[key]
+		if !ok {
+			diff.New = append(diff.New, change)
+		} else if from.contentHash != "" && to.contentHash != "" && from.contentHash != to.contentHash {
+			diff.Changed = append(diff.Changed, change)
+		}
+		if to.duplicateOf != "" {
+			diff.Duplicates = append(diff.Duplicates, NearDuplicate{URL: to.url, DuplicateOf: to.duplicateOf})
+		}
+	}
+	for key, from := range fromPages {
+		if _, ok := toPages[key]; !ok {
+			diff.Removed = append(diff.Removed, PageChange{URL: from.url, Kind: from.kind})
+		}
+	}
+
+	for _, changes := range [][]PageChange{diff.New, diff.Removed, diff.Changed} {
+		sort.Slice(changes, func(i, j int) bool { return changes[i].URL < changes[j].URL })
+	}
+	sort.Slice(diff.Duplicates, func(i, j int) bool { return diff.Duplicates[i].URL < diff.Duplicates[j].URL })
+	return diff, nil
+}
+
+func (db *database) getRunPages(ctx context.Context, runID string) (map[string]runPage, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT url, kind, content_hash, duplicate_of
+		FROM crawl_run_pages
+		WHERE run_id = $1
+	`, runID)
+	if err != nil {
+		return nil, fmt.Errorf("could not query sqlite: %v", err)
+	}
+	defer rows.Close()
+	pages := make(map[string]runPage)
+	for rows.Next() {
+		var p runPage
+		if err := rows.Scan(&p.url, &p.kind, &p.contentHash, &p.duplicateOf); err != nil {
+			return nil, fmt.Errorf("could not scan sqlite: %v", err)
+		}
+		pages[p.kind+" "+p.url] = p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate sqlite: %v", err)
+	}
+	return pages, nil
+}
@@ -3,19 +3,29 @@ package db
 import (
 	"context"
 	"database/sql"
+	sqldriver "database/sql/driver"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"time"
 
+	"github.com/XSAM/otelsql"
+	"github.com/Ztkent/data-manager/internal/config"
+	"github.com/Ztkent/data-manager/internal/email"
+	"github.com/Ztkent/data-manager/internal/tracing"
+	"github.com/Ztkent/data-manager/internal/webhook"
 	"github.com/go-redis/redis/v8"
-	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
 	_ "github.com/lib/pq"           // PostgreSQL driver
 	_ "github.com/mattn/go-sqlite3" // SQLite driver
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -23,23 +33,117 @@ type database struct {
 	db *sql.DB
 }
 
+// Results DB tables that may be exported to CSV
+var ExportableTables = map[string]bool{
+	"visited":           true,
+	"page_metadata":     true,
+	"extracted_records": true,
+}
+
 type MasterDatabase interface {
-	CreateUser(userID, email, password string) error
-	LoginUser(email, password string) (string, string, error)
-	UpdateUserAuth(userID, token string) error
-	GetRecentlyActiveUsers() ([]string, error)
-	ConfirmUUIDandToken(userID, token string) error
+	CreateUser(ctx context.Context, userID, email, password string) (string, error)
+	LoginUser(ctx context.Context, email, password string) (string, error)
+	UpdateUserAuth(ctx context.Context, userID, sessionID, token string, expiresAt time.Time, userAgent, ipAddress string) error
+	DeleteUserAuth(ctx context.Context, userID, sessionID string) error
+	GetSessions(ctx context.Context, userID string) ([]Session, error)
+	DeleteOtherSessions(ctx context.Context, userID, sessionID string) ([]string, error)
+	CreateAPIKey(ctx context.Context, userID, name, prefix, keyHash string, scopes []string) error
+	GetAPIKeys(ctx context.Context, userID string) ([]APIKey, error)
+	AuthenticateAPIKey(ctx context.Context, keyHash string) (APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID string, id int) error
+	GetRecentlyActiveUsers(ctx context.Context) ([]string, error)
+	ConfirmSession(ctx context.Context, userID, sessionID string) error
+	CreateWebhook(ctx context.Context, userID, url, secret string) error
+	GetWebhooks(ctx context.Context, userID string) ([]webhook.Hook, error)
+	GetWebhook(ctx context.Context, userID string, id int) (webhook.Hook, error)
+	DeleteWebhook(ctx context.Context, userID string, id int) error
+	LogWebhookDelivery(webhookID int, event string, payload string, attempt int, statusCode int, deliveryErr string) error
+	GetWebhookDeliveries(ctx context.Context, userID string) ([]WebhookDelivery, error)
+	GetUserEmail(ctx context.Context, userID string) (string, error)
+	GetNotificationPreferences(ctx context.Context, userID string) (email.Preferences, error)
+	UpdateNotificationPreferences(ctx context.Context, userID string, prefs email.Preferences) error
+	CreateUserToken(ctx context.Context, userID string, purpose string, ttl time.Duration) (string, error)
+	ConsumeUserToken(ctx context.Context, token string, purpose string) (string, error)
+	GetUserIDByEmail(ctx context.Context, email string) (string, error)
+	IsEmailVerified(ctx context.Context, userID string) (bool, error)
+	MarkEmailVerified(ctx context.Context, userID string) error
+	UpdatePassword(ctx context.Context, userID string, password string) error
+	GetUserIDByIdentity(ctx context.Context, issuer, subject string) (string, error)
+	LinkIdentity(ctx context.Context, userID, issuer, subject, email string) error
+	CreateSSOUser(ctx context.Context, userID, email string) error
+	GetUserIdentities(ctx context.Context, userID string) ([]LinkedIdentity, error)
+	GetTOTP(ctx context.Context, userID string) (TOTP, error)
+	SetPendingTOTPSecret(ctx context.Context, userID, secret string) error
+	EnableTOTP(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error
+	DisableTOTP(ctx context.Context, userID string) error
+	UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error)
+	SetRecoveryCodes(ctx context.Context, userID string, recoveryCodeHashes []string) error
+	UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error)
+	CountRecoveryCodes(ctx context.Context, userID string) (int, error)
+	RecordLoginAttempt(ctx context.Context, attempt LoginAttempt) error
+	GetUserLoginAttempts(ctx context.Context, userID string) ([]LoginAttempt, error)
+	CreateWorkspace(ctx context.Context, workspaceID, name, ownerID string) error
+	GetWorkspaces(ctx context.Context, userID string) ([]Workspace, error)
+	GetWorkspaceRole(ctx context.Context, workspaceID, userID string) (string, error)
+	GetWorkspaceMembers(ctx context.Context, workspaceID string) ([]WorkspaceMember, error)
+	SetWorkspaceMember(ctx context.Context, workspaceID, userID, role string) error
+	RemoveWorkspaceMember(ctx context.Context, workspaceID, userID string) error
+	InviteWorkspaceMember(ctx context.Context, workspaceID, email, role, invitedBy string) error
+	GetWorkspaceInvites(ctx context.Context, workspaceID string) ([]WorkspaceInvite, error)
+	GetUserWorkspaceInvites(ctx context.Context, userID string) ([]WorkspaceInvite, error)
+	AcceptWorkspaceInvite(ctx context.Context, workspaceID, userID string) error
+	DeleteWorkspaceInvite(ctx context.Context, workspaceID, email string) error
+	GetRecentlyActiveWorkspaces(ctx context.Context) ([]string, error)
+	GetUserAccess(ctx context.Context, userID string) (string, bool, error)
+	SetUserRole(ctx context.Context, userID, role string) error
+	PromoteAdmins(ctx context.Context, emails []string) error
+	GetUsers(ctx context.Context) ([]UserSummary, error)
+	DisableUser(ctx context.Context, userID string) ([]string, error)
+	EnableUser(ctx context.Context, userID string) error
+	StartCrawlJob(ctx context.Context, job CrawlJob) error
+	FinishCrawlJob(ctx context.Context, jobID, status, jobErr string, finishedAt time.Time) error
+	AbandonRunningCrawlJobs(ctx context.Context) error
+	GetRecentCrawlJobs(ctx context.Context) ([]CrawlJob, error)
+	Ping(ctx context.Context) error
+	RequestAccountDeletion(ctx context.Context, userID string) ([]string, error)
+	CancelAccountDeletion(ctx context.Context, userID string) (bool, error)
+	GetAccountsDueForDeletion(ctx context.Context, requestedBefore time.Time) ([]string, error)
+	DeleteAccount(ctx context.Context, userID string) ([]string, error)
+	GetUserSummary(ctx context.Context, userID string) (UserSummary, error)
+	GetUserCrawlJobs(ctx context.Context, userID string) ([]CrawlJob, error)
+	IsIDInUse(ctx context.Context, id string) (bool, error)
+	RecordAuditEvent(ctx context.Context, event AuditEvent) error
+	GetAuditEvents(ctx context.Context, filter AuditFilter) ([]AuditEvent, error)
+	GetUserAuditEvents(ctx context.Context, userID string) ([]AuditEvent, error)
 }
 
 type ManagerDatabase interface {
-	GetRecentVisited() ([]Visited, error)
-	ExportToCSV(path string, table string) (string, error)
-	GetFilesForType(fileType string) (FileCollection, error)
-	DownloadFile(fileType string, id int) (string, error)
+	GetRecentVisited(ctx context.Context, filter ResultsFilter) (VisitedPage, error)
+	ExportToCSV(ctx context.Context, path string, table string) (string, error)
+	GetFilesForType(ctx context.Context, fileType string, filter ResultsFilter) (FileCollection, error)
+	DownloadFile(ctx context.Context, fileType string, id int) (string, error)
+	IndexContent(ctx context.Context, since time.Time) error
+	ProcessMetadata(ctx context.Context) error
+	ApplyExtractionRules(ctx context.Context, startingURL string, rules []config.ExtractionRule, since time.Time) error
+	ExportToJSON(ctx context.Context, table string) (string, error)
+	RecordCrawlRun(ctx context.Context, runID string, startingURL string, startedAt time.Time, finishedAt time.Time) error
+	GetCrawlRuns(ctx context.Context) ([]CrawlRun, error)
+	DiffCrawlRuns(ctx context.Context, fromID string, toID string) (CrawlDiff, error)
+	SearchContent(ctx context.Context, query string, page int) (SearchResults, error)
+	MergeResults(ctx context.Context, sourcePath string) error
+	Stats() sql.DBStats
+	Close() error
 }
 
 func NewManagerDatabase(db *sql.DB) ManagerDatabase {
-	return &database{db: db}
+	manager := &database{db: db}
+	if db != nil {
+		err := manager.migrateResults()
+		if err != nil {
+			slog.Error("results db migration failed", "error", err)
+		}
+	}
+	return manager
 }
 
 func NewMasterDatabase(db *sql.DB) MasterDatabase {
@@ -55,131 +159,191 @@ type Visited struct {
 	IsBlocked     bool
 }
 
+type VisitedPage struct {
+	Visited    []Visited
+	Filter     ResultsFilter
+	NextCursor string
+}
+
 type File struct {
-	ID       int
-	FileName string
-	FileType string
-	FileSize string
-	FileDate string
+	ID        int
+	FileName  string
+	FileType  string
+	FileSize  string
+	FileDate  string
+	Title     string
+	WordCount int
 }
 
 type FileCollection struct {
-	FileType string
-	Files    []File
+	FileType   string
+	Files      []File
+	Filter     ResultsFilter
+	NextCursor string
 }
 
-func (db *database) CreateUser(userID, email, password string) error {
+// CreateUser creates the account, and returns its user_id.
+// The requested id is only used if no account or workspace has it, otherwise the account gets a new one.
+func (db *database) CreateUser(ctx context.Context, userID, email, password string) (string, error) {
 	if db.db == nil {
-		return fmt.Errorf("database is nil")
+		return "", fmt.Errorf("database is nil")
 	}
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return fmt.Errorf("could not hash password: %v", err)
+		return "", fmt.Errorf("could not hash password: %v", err)
 	}
-	_, err = db.db.Exec(`
-        INSERT INTO users (user_id, email, password, created_at, updated_at)
-        VALUES ($1, $2, $3, NOW(), NOW())
-    `, userID, email, hashedPassword)
+	inUse, err := db.IsIDInUse(ctx, userID)
 	if err != nil {
-		// if users want to create a second account while we have a cookie active, we need to generate a new user_id
-		if strings.Contains(err.Error(), "users_user_id_key") {
-			userID = uuid.New().String()
-		}
-		_, err = db.db.Exec(`
+		return "", err
+	} else if inUse || userID == "" {
+		userID = uuid.New().String()
+	}
+	// The unique constraint on email is case sensitive, older accounts may differ from the new one only in case
+	result, err := db.db.ExecContext(ctx, `
         INSERT INTO users (user_id, email, password, created_at, updated_at)
-        VALUES ($1, $2, $3, NOW(), NOW())
-    `, userID, email, hashedPassword)
+        SELECT $1, $2, $3, NOW(), NOW()
+        WHERE NOT EXISTS (SELECT 1 FROM users WHERE LOWER(email) = $2)
+    `, userID, normalizeEmail(email), hashedPassword)
+	if err != nil {
+		return "", fmt.Errorf("could not create user: %v", err)
+	}
+	if created, err := result.RowsAffected(); err != nil || created == 0 {
+		return "", fmt.Errorf("could not create user: email is already registered")
 	}
-	return err
+	return userID, nil
+}
+
+// Emails are stored and compared in lower case, so an address matches however it was capitalized.
+// Accounts created before that may have capitals, so queries compare them with LOWER(email).
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
 
-func (db *database) LoginUser(email, password string) (string, string, error) {
+// IsIDInUse reports whether an account or team workspace has the id, so it can't be claimed by anyone else.
+func (db *database) IsIDInUse(ctx context.Context, id string) (bool, error) {
 	if db.db == nil {
-		return "", "", fmt.Errorf("database is nil")
+		return false, fmt.Errorf("database is nil")
+	}
+	var inUse bool
+	err := db.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM users WHERE user_id = $1)
+			OR EXISTS (SELECT 1 FROM workspaces WHERE workspace_id = $1)
+	`, id).Scan(&inUse)
+	if err != nil {
+		return false, fmt.Errorf("could not query postgres: %v", err)
+	}
+	return inUse, nil
+}
+
+// A bcrypt hash with the default cost, for an unguessable password
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte(uuid.New().String()), bcrypt.DefaultCost)
+
+// LoginUser checks the user's password and returns their user_id, the caller starts the session.
+func (db *database) LoginUser(ctx context.Context, email, password string) (string, error) {
+	if db.db == nil {
+		return "", fmt.Errorf("database is nil")
 	}
 	var userId string
 	var hashedPassword string
-	err := db.db.QueryRow(`
+	err := db.db.QueryRowContext(ctx, `
 		SELECT user_id, password
 		FROM users
-		WHERE email = $1
-	`, email).Scan(&userId, &hashedPassword)
+		WHERE LOWER(email) = $1
+	`, normalizeEmail(email)).Scan(&userId, &hashedPassword)
 	if err != nil {
-		return "", "", fmt.Errorf("could not find user: %v", err)
+		// Compare against a dummy hash anyway, so a missing user takes as long to reject as a wrong password
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return "", fmt.Errorf("could not find user: %v", err)
+	}
+	if hashedPassword == "" {
+		// Users who only sign in with SSO have no password
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return "", fmt.Errorf("user has no password")
 	}
 	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 	if err != nil {
-		return "", "", fmt.Errorf("could not compare password: %v", err)
+		return "", fmt.Errorf("could not compare password: %v", err)
 	}
+	return userId, nil
+}
 
-	token, err := generateJWT()
-	if err != nil {
-		return "", "", fmt.Errorf("could not generate JWT: %v", err)
+// UpdateUserAuth stores one of the user's sessions, with the latest token issued to it and the device it was issued to.
+func (db *database) UpdateUserAuth(ctx context.Context, userID, sessionID, token string, expiresAt time.Time, userAgent, ipAddress string) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
 	}
-
-	err = db.UpdateUserAuth(userId, token)
+	_, err := db.db.ExecContext(ctx, `
+        INSERT INTO auth (user_id, session_token, jti, expires_at, user_agent, ip_address, created_at, updated_at, last_seen_at)
+        VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW(), NOW())
+        ON CONFLICT (jti) DO UPDATE 
+        SET session_token = $2, expires_at = $4, user_agent = $5, ip_address = $6, updated_at = NOW(), last_seen_at = NOW()
+        WHERE auth.user_id = $1
+    `, userID, token, sessionID, expiresAt.UTC(), userAgent, ipAddress)
 	if err != nil {
-		return "", "", fmt.Errorf("could not update user auth: %v", err)
+		return fmt.Errorf("could not upsert user auth: %v", err)
 	}
-	return userId, token, nil
+	return nil
 }
 
-func (db *database) UpdateUserAuth(userID, token string) error {
+func (db *database) DeleteUserAuth(ctx context.Context, userID, sessionID string) error {
 	if db.db == nil {
 		return fmt.Errorf("database is nil")
 	}
-	_, err := db.db.Exec(`
-        INSERT INTO auth (user_id, session_token, created_at, updated_at)
-        VALUES ($1, $2, NOW(), NOW())
-        ON CONFLICT (user_id) DO UPDATE 
-        SET session_token = $2, updated_at = NOW()
-    `, userID, token)
+	_, err := db.db.ExecContext(ctx, "DELETE FROM auth WHERE user_id = $1 AND jti = $2", userID, sessionID)
 	if err != nil {
-		return fmt.Errorf("could not upsert user auth: %v", err)
+		return fmt.Errorf("could not delete user auth: %v", err)
 	}
 	return nil
 }
 
-func (db *database) GetRecentVisited() ([]Visited, error) {
+func (db *database) GetRecentVisited(ctx context.Context, filter ResultsFilter) (VisitedPage, error) {
 	if db.db == nil {
-		return nil, fmt.Errorf("database is nil")
+		return VisitedPage{}, fmt.Errorf("database is nil")
 	}
-	rows, err := db.db.Query(`
-        SELECT id, url, referrer, last_visited_at, is_complete, is_blocked
+	q, err := newFilterQuery(filter, "last_visited_at", true, true, DEFAULT_VISITED_LIMIT)
+	if err != nil {
+		return VisitedPage{}, err
+	}
+	rows, err := db.db.QueryContext(ctx, `
+        SELECT id, url, referrer, last_visited_at, is_complete, is_blocked, `+q.keyColumn()+`
         FROM visited
-        ORDER BY last_visited_at DESC
-        LIMIT 25
-    `)
+        WHERE 1 = 1`+q.where()+q.orderAndLimit(), q.args...)
 	if err != nil {
-		return nil, fmt.Errorf("could not query sqlite: %v", err)
+		return VisitedPage{}, fmt.Errorf("could not query sqlite: %v", err)
 	}
 	defer rows.Close()
 	var visiteds []Visited
+	var keys []string
 	for rows.Next() {
 		var v Visited
-		if err := rows.Scan(&v.ID, &v.URL, &v.Referrer, &v.LastVisitedAt, &v.IsComplete, &v.IsBlocked); err != nil {
-			return nil, fmt.Errorf("could not scan sqlite: %v", err)
+		var key string
+		if err := rows.Scan(&v.ID, &v.URL, &v.Referrer, &v.LastVisitedAt, &v.IsComplete, &v.IsBlocked, &key); err != nil {
+			return VisitedPage{}, fmt.Errorf("could not scan sqlite: %v", err)
 		}
 		visiteds = append(visiteds, v)
+		keys = append(keys, key)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("could not iterate sqlite: %v", err)
+		return VisitedPage{}, fmt.Errorf("could not iterate sqlite: %v", err)
 	}
 
-	sort.Slice(visiteds, func(i, j int) bool {
-		if visiteds[i].LastVisitedAt.Equal(visiteds[j].LastVisitedAt) {
-			return visiteds[i].ID > visiteds[j].ID
-		}
-		return visiteds[i].LastVisitedAt.After(visiteds[j].LastVisitedAt)
-	})
-
-	return visiteds, nil
+	page := VisitedPage{Filter: filter}
+	if len(visiteds) > q.limit {
+		visiteds = visiteds[:q.limit]
+		last := visiteds[len(visiteds)-1]
+		page.NextCursor = encodeCursor(keys[len(visiteds)-1], last.ID)
+	}
+	page.Visited = visiteds
+	return page, nil
 }
 
-func (db *database) ExportToCSV(path string, table string) (string, error) {
+func (db *database) ExportToCSV(ctx context.Context, path string, table string) (string, error) {
 	if db.db == nil {
 		return "", fmt.Errorf("database is nil")
 	}
+	if !ExportableTables[table] {
+		return "", fmt.Errorf("invalid export table: %s", table)
+	}
 
 	// Create a new CSV file
 	uuid := uuid.New().String()
@@ -190,7 +354,7 @@ func (db *database) ExportToCSV(path string, table string) (string, error) {
 		return "", fmt.Errorf("could not create file: %v", err)
 	}
 	defer file.Close()
-	rows, err := db.db.Query("SELECT * FROM " + table)
+	rows, err := db.db.QueryContext(ctx, "SELECT * FROM "+table)
 	if err != nil {
 		return "", fmt.Errorf("could not query sqlite: %v", err)
 	}
@@ -200,44 +364,101 @@ func (db *database) ExportToCSV(path string, table string) (string, error) {
 		return "", fmt.Errorf("could not get columns: %v", err)
 	}
 	// Write the header row
-	for i, colName := range columns {
-		if i > 0 {
-			file.WriteString(",")
-		}
-		file.WriteString(colName)
-	}
-	file.WriteString("\n")
+	writer := csv.NewWriter(file)
+	writer.Write(columns)
 
 	// Write the data rows
 	values := make([]interface{}, len(columns))
 	valuePtrs := make([]interface{}, len(columns))
+	record := make([]string, len(columns))
 	for rows.Next() {
 		for i := range columns {
 			valuePtrs[i] = &values[i]
 		}
 		rows.Scan(valuePtrs...)
 		for i := range columns {
-			if i > 0 {
-				file.WriteString(",")
-			}
 			switch v := values[i].(type) {
+			case nil:
+				record[i] = ""
 			case int64:
-				file.WriteString(fmt.Sprintf("%d", v))
+				record[i] = fmt.Sprintf("%d", v)
 			case bool:
-				file.WriteString(fmt.Sprintf("%t", v))
+				record[i] = fmt.Sprintf("%t", v)
 			case time.Time:
-				file.WriteString(v.Format("2006-01-02 15:04:05"))
+				record[i] = v.Format("2006-01-02 15:04:05")
+			case []byte:
+				record[i] = string(v)
+			default:
+				record[i] = fmt.Sprintf("%v", v)
+			}
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return "", fmt.Errorf("could not write csv: %v", err)
+	}
+
+	return filePath, nil
+}
+
+func (db *database) ExportToJSON(ctx context.Context, table string) (string, error) {
+	if db.db == nil {
+		return "", fmt.Errorf("database is nil")
+	}
+	if !ExportableTables[table] {
+		return "", fmt.Errorf("invalid export table: %s", table)
+	}
+
+	// Create a new JSON file
+	uuid := uuid.New().String()
+	fileName := table + "_" + uuid + ".json"
+	filePath := filepath.Join("user/data-crawler/", fileName)
+	file, err := os.Create(filePath)
+	if err != nil {
+		return "", fmt.Errorf("could not create file: %v", err)
+	}
+	defer file.Close()
+	rows, err := db.db.QueryContext(ctx, "SELECT * FROM "+table)
+	if err != nil {
+		return "", fmt.Errorf("could not query sqlite: %v", err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", fmt.Errorf("could not get columns: %v", err)
+	}
+
+	// Write an array of objects keyed by column name
+	records := make([]map[string]interface{}, 0)
+	values := make([]interface{}, len(columns))
+	valuePtrs := make([]interface{}, len(columns))
+	for rows.Next() {
+		for i := range columns {
+			valuePtrs[i] = &values[i]
+		}
+		rows.Scan(valuePtrs...)
+		record := make(map[string]interface{}, len(columns))
+		for i, colName := range columns {
+			switch v := values[i].(type) {
+			case []byte:
+				record[colName] = string(v)
 			default:
-				file.WriteString(fmt.Sprintf("%s", v))
+				record[colName] = v
 			}
 		}
-		file.WriteString("\n")
+		records = append(records, record)
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(records); err != nil {
+		return "", fmt.Errorf("could not write json: %v", err)
 	}
 
 	return filePath, nil
 }
 
-func (db *database) DownloadFile(fileType string, id int) (string, error) {
+func (db *database) DownloadFile(ctx context.Context, fileType string, id int) (string, error) {
 	if db.db == nil {
 		return "", fmt.Errorf("database is nil")
 	}
@@ -245,7 +466,7 @@ func (db *database) DownloadFile(fileType string, id int) (string, error) {
 	if fileType == "HTML" {
 		var url string
 		var html string
-		row := db.db.QueryRow("SELECT url, html FROM html WHERE id = $1", id)
+		row := db.db.QueryRowContext(ctx, "SELECT url, html FROM html WHERE id = $1", id)
 		err := row.Scan(&url, &html)
 		if err != nil {
 			return "", fmt.Errorf("could not query sqlite: %v", err)
@@ -263,7 +484,7 @@ func (db *database) DownloadFile(fileType string, id int) (string, error) {
 		var url string
 		var image string
 		var name string
-		row := db.db.QueryRow("SELECT url, image, name FROM images WHERE id = $1", id)
+		row := db.db.QueryRowContext(ctx, "SELECT url, image, name FROM images WHERE id = $1", id)
 		err := row.Scan(&url, &image, &name)
 		if err != nil {
 			return "", fmt.Errorf("could not query sqlite: %v", err)
@@ -281,27 +502,41 @@ func (db *database) DownloadFile(fileType string, id int) (string, error) {
 	return filePath, nil
 }
 
-func (db *database) GetFilesForType(fileType string) (FileCollection, error) {
+func (db *database) GetFilesForType(ctx context.Context, fileType string, filter ResultsFilter) (FileCollection, error) {
 	if db.db == nil {
 		return FileCollection{}, fmt.Errorf("database is nil")
 	}
 
 	var query string
+	var q *filterQuery
+	var err error
 	switch fileType {
 	case "HTML":
-		query = "SELECT id, url, html, updated_at FROM html ORDER BY updated_at DESC LIMIT 50"
+		q, err = newFilterQuery(filter, "updated_at", false, false, DEFAULT_FILE_LIMIT)
+		if err != nil {
+			return FileCollection{}, err
+		}
+		query = `SELECT id, url, html, updated_at, title, word_count, ` + q.keyColumn() + ` FROM (
+				SELECT h.id, h.url, h.html, h.updated_at, COALESCE(m.title, '') AS title, COALESCE(m.word_count, 0) AS word_count
+				FROM html h LEFT JOIN page_metadata m ON m.url = h.url
+			) WHERE 1 = 1` + q.where() + q.orderAndLimit()
 	case "Image":
-		query = "SELECT id, referrer, url, image, name, updated_at FROM images WHERE success = 1 AND image IS NOT NULL ORDER BY updated_at DESC LIMIT 50"
+		q, err = newFilterQuery(filter, "updated_at", true, false, DEFAULT_FILE_LIMIT)
+		if err != nil {
+			return FileCollection{}, err
+		}
+		query = "SELECT id, referrer, url, image, name, updated_at, " + q.keyColumn() + " FROM images WHERE success = 1 AND image IS NOT NULL" + q.where() + q.orderAndLimit()
 	default:
 		return FileCollection{}, fmt.Errorf(fmt.Sprintf("invalid file type: %s", fileType))
 	}
 
-	rows, err := db.db.Query(query)
+	rows, err := db.db.QueryContext(ctx, query, q.args...)
 	if err != nil {
 		return FileCollection{}, fmt.Errorf("could not query sqlite: %v", err)
 	}
 	defer rows.Close()
 	var files []File
+	var keys []string
 	for rows.Next() {
 		var f File
 		if fileType == "HTML" {
@@ -309,7 +544,8 @@ func (db *database) GetFilesForType(fileType string) (FileCollection, error) {
 			var url string
 			var html string
 			var updatedAt time.Time
-			if err := rows.Scan(&id, &url, &html, &updatedAt); err != nil {
+			var key string
+			if err := rows.Scan(&id, &url, &html, &updatedAt, &f.Title, &f.WordCount, &key); err != nil {
 				return FileCollection{}, fmt.Errorf("could not scan sqlite: %v", err)
 			}
 			f.ID = id
@@ -317,6 +553,7 @@ func (db *database) GetFilesForType(fileType string) (FileCollection, error) {
 			f.FileType = "HTML"
 			f.FileSize = fmt.Sprintf("%d", len(html))
 			f.FileDate = updatedAt.Format("2006-01-02 15:04:05")
+			keys = append(keys, key)
 		} else {
 			var id int
 			var referrer string
@@ -324,8 +561,9 @@ func (db *database) GetFilesForType(fileType string) (FileCollection, error) {
 			var image string
 			var name string
 			var updatedAt time.Time
+			var key string
 
-			if err := rows.Scan(&id, &referrer, &url, &image, &name, &updatedAt); err != nil {
+			if err := rows.Scan(&id, &referrer, &url, &image, &name, &updatedAt, &key); err != nil {
 				return FileCollection{}, fmt.Errorf("could not scan sqlite: %v", err)
 			}
 			f.ID = id
@@ -333,6 +571,7 @@ func (db *database) GetFilesForType(fileType string) (FileCollection, error) {
 			f.FileType = "Image"
 			f.FileSize = fmt.Sprintf("%d", len(image))
 			f.FileDate = updatedAt.Format("2006-01-02 15:04:05")
+			keys = append(keys, key)
 		}
 		files = append(files, f)
 	}
@@ -340,20 +579,26 @@ func (db *database) GetFilesForType(fileType string) (FileCollection, error) {
 		return FileCollection{}, fmt.Errorf("could not iterate sqlite: %v", err)
 	}
 
-	return FileCollection{
+	fc := FileCollection{
 		FileType: fileType,
-		Files:    files,
-	}, nil
+		Filter:   filter,
+	}
+	if len(files) > q.limit {
+		files = files[:q.limit]
+		fc.NextCursor = encodeCursor(keys[len(files)-1], files[len(files)-1].ID)
+	}
+	fc.Files = files
+	return fc, nil
 }
 
-func (db *database) GetRecentlyActiveUsers() ([]string, error) {
+func (db *database) GetRecentlyActiveUsers(ctx context.Context) ([]string, error) {
 	if db.db == nil {
 		return nil, fmt.Errorf("database is nil")
 	}
-	rows, err := db.db.Query(`
-		SELECT user_id
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT DISTINCT user_id
 		FROM auth
-		WHERE updated_at > NOW() - INTERVAL '72 hours'
+		WHERE COALESCE(last_seen_at, updated_at) > NOW() - INTERVAL '72 hours'
 	`)
 	if err != nil {
 		return nil, fmt.Errorf("could not query postgres: %v", err)
@@ -373,24 +618,33 @@ func (db *database) GetRecentlyActiveUsers() ([]string, error) {
 	return users, nil
 }
 
-func (db *database) ConfirmUUIDandToken(userID, token string) error {
+func (db *database) ConfirmSession(ctx context.Context, userID, sessionID string) error {
 	if db.db == nil {
 		return fmt.Errorf("database is nil")
 	}
 
-	// confirm that the uuid and token match what we have in the database
-	row := db.db.QueryRow("SELECT COUNT(*) FROM auth WHERE user_id = $1 AND session_token = $2", userID, token)
-
-	var count int
-	err := row.Scan(&count)
-	if err != nil {
+	// confirm that the session is still active for this user
+	row := db.db.QueryRowContext(ctx, `
+		SELECT COALESCE(last_seen_at, updated_at)
+		FROM auth
+		WHERE user_id = $1 AND jti = $2 AND expires_at > $3
+	`, userID, sessionID, time.Now().UTC())
+
+	var lastSeen time.Time
+	err := row.Scan(&lastSeen)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("session is not active")
+	} else if err != nil {
 		return fmt.Errorf("could not query user auth: %v", err)
 	}
 
-	if count == 0 {
-		return fmt.Errorf("uuid and token do not match")
+	// keep track of when each session was last used, without writing on every request
+	if time.Since(lastSeen) > SESSION_LAST_SEEN_INTERVAL {
+		_, err = db.db.ExecContext(ctx, "UPDATE auth SET last_seen_at = NOW() WHERE jti = $1", sessionID)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not update session last seen", "error", err)
+		}
 	}
-
 	return nil
 }
 
@@ -414,6 +668,7 @@ func ConnectRedis() (*redis.Client, error) {
 		Username: redisUser,
 		DB:       0,
 	})
+	client.AddHook(tracing.RedisHook{})
 
 	// Test the connection
 	ctx5, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -490,30 +745,83 @@ func RunMigrations(db *sql.DB) error {
 	return nil
 }
 
-func generateJWT() (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"exp": time.Now().Add(time.Hour * 24).Unix(),
-	})
-	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET_TOKEN")))
+// Create the tables we maintain alongside the crawler's in each results DB
+func (db *database) migrateResults() error {
+	_, err := db.db.Exec(`
+		CREATE TABLE IF NOT EXISTS page_metadata (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			url TEXT UNIQUE NOT NULL,
+			title TEXT,
+			description TEXT,
+			canonical_url TEXT,
+			language TEXT,
+			open_graph TEXT,
+			twitter TEXT,
+			headings TEXT,
+			word_count INTEGER,
+			source_updated_at TEXT,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS extracted_records (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			starting_url TEXT NOT NULL,
+			page_url TEXT NOT NULL,
+			rule_name TEXT NOT NULL,
+			value TEXT,
+			extracted_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS extracted_records_page_rule ON extracted_records (page_url, rule_name);
+		CREATE TABLE IF NOT EXISTS crawl_runs (
+			id TEXT PRIMARY KEY,
+			starting_url TEXT NOT NULL,
+			started_at DATETIME NOT NULL,
+			finished_at DATETIME
+		);
+		CREATE TABLE IF NOT EXISTS crawl_run_pages (
+			run_id TEXT NOT NULL,
+			url TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			content_hash TEXT NOT NULL DEFAULT '',
+			simhash INTEGER,
+			duplicate_of TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (run_id, kind, url),
+			FOREIGN KEY (run_id) REFERENCES crawl_runs (id)
+		);
+	`)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("could not migrate results db: %v", err)
 	}
-	return tokenString, nil
+	return nil
+}
+
+// Database systems reported on query spans, by driver
+var dbSystems = map[string]attribute.KeyValue{
+	"postgres": semconv.DBSystemPostgreSQL,
+	"sqlite3":  semconv.DBSystemSqlite,
 }
 
 func connectWithBackoff(driver string, connStr string, maxRetries int) (*sql.DB, error) {
 	var db *sql.DB
 	var err error
 	for i := 0; i < maxRetries; i++ {
-		db, err = sql.Open(driver, connStr)
+		db, err = otelsql.Open(driver, connStr,
+			otelsql.WithAttributes(dbSystems[driver]),
+			otelsql.WithSpanOptions(otelsql.SpanOptions{
+				OmitConnResetSession: true,
+				OmitRows:             true,
+				SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []sqldriver.NamedValue) bool {
+					return tracing.HasSpan(ctx)
+				},
+			}),
+		)
 		if err != nil {
-			fmt.Println("Failed attempt to connect to " + driver + ": " + err.Error())
+			slog.Warn("failed attempt to connect", "driver", driver, "attempt", i+1, "error", err)
 			time.Sleep(time.Duration(i+1) * (3 * time.Second))
 			continue
 		}
 		err = db.Ping()
 		if err != nil {
-			fmt.Println("Failed attempt to connect to " + driver + ": " + err.Error())
+			slog.Warn("failed attempt to connect", "driver", driver, "attempt", i+1, "error", err)
 			time.Sleep(time.Duration(i+1) * (3 * time.Second))
 			continue
 		}
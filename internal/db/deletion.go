@@ -0,0 +1,207 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RequestAccountDeletion schedules the account for deletion, revokes its API keys and removes its sessions.
+// It returns the removed session ids.
+func (db *database) RequestAccountDeletion(ctx context.Context, userID string) ([]string, error) {
+	if db.db == nil {
+		return nil, fmt.Errorf("database is nil")
+	}
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, "UPDATE users SET deletion_requested_at = NOW(), updated_at = NOW() WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not request account deletion: %v", err)
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE api_keys SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not revoke api keys: %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("could not request account deletion: %v", err)
+	}
+	return db.DeleteOtherSessions(ctx, userID, "")
+}
+
+// CancelAccountDeletion keeps an account that was scheduled for deletion, and reports whether it was scheduled.
+func (db *database) CancelAccountDeletion(ctx context.Context, userID string) (bool, error) {
+	if db.db == nil {
+		return false, fmt.Errorf("database is nil")
+	}
+	result, err := db.db.ExecContext(ctx, `
+		UPDATE users SET deletion_requested_at = NULL, updated_at = NOW()
+		WHERE user_id = $1 AND deletion_requested_at IS NOT NULL
+	`, userID)
+	if err != nil {
+		return false, fmt.Errorf("could not cancel account deletion: %v", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("could not cancel account deletion: %v", err)
+	}
+	return rows > 0, nil
+}
+
+// GetAccountsDueForDeletion lists the accounts whose deletion was requested before the given time.
+func (db *database) GetAccountsDueForDeletion(ctx context.Context, requestedBefore time.Time) ([]string, error) {
+	if db.db == nil {
+		return nil, fmt.Errorf("database is nil")
+	}
+	rows, err := db.db.QueryContext(ctx, "SELECT user_id FROM users WHERE deletion_requested_at < $1", requestedBefore.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("could not query postgres: %v", err)
+	}
+	defer rows.Close()
+	var users []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("could not scan postgres: %v", err)
+		}
+		users = append(users, userID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate postgres: %v", err)
+	}
+	return users, nil
+}
+
+// DeleteAccount removes the user and every row that belongs to them, including the team workspaces only they belong to.
+// Team workspaces with other members are kept, and handed to their longest-standing member if the user was the only owner.
+// It returns the ids of the deleted workspaces, starting with the personal workspace, so their files can be removed.
+func (db *database) DeleteAccount(ctx context.Context, userID string) ([]string, error) {
+	if db.db == nil {
+		return nil, fmt.Errorf("database is nil")
+	}
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var deleted, shared []string
+	rows, err := tx.QueryContext(ctx, `
+		SELECT w.workspace_id,
+			(SELECT COUNT(*) FROM workspace_members m WHERE m.workspace_id = w.workspace_id AND m.user_id != $1)
+		FROM workspaces w
+		WHERE w.created_by = $1 OR w.workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = $1)
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not query postgres: %v", err)
+	}
+	for rows.Next() {
+		var workspaceID string
+		var others int
+		if err := rows.Scan(&workspaceID, &others); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("could not scan postgres: %v", err)
+		}
+		if others == 0 {
+			deleted = append(deleted, workspaceID)
+		} else {
+			shared = append(shared, workspaceID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate postgres: %v", err)
+	}
+
+	for _, workspaceID := range shared {
+		// Promote the earliest remaining member, unless another owner already exists
+		var ownerID string
+		err = tx.QueryRowContext(ctx, `
+			UPDATE workspace_members SET role = 'owner'
+			WHERE workspace_id = $1 AND user_id = (
+				SELECT user_id FROM workspace_members
+				WHERE workspace_id = $1 AND user_id != $2
+				ORDER BY role = 'owner' DESC, created_at, user_id
+				LIMIT 1
+			)
+			RETURNING user_id
+		`, workspaceID, userID).Scan(&ownerID)
+		if err != nil {
+			return nil, fmt.Errorf("could not transfer workspace: %v", err)
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE workspaces SET created_by = $1 WHERE workspace_id = $2 AND created_by = $3", ownerID, workspaceID, userID)
+		if err != nil {
+			return nil, fmt.Errorf("could not transfer workspace: %v", err)
+		}
+	}
+	for _, workspaceID := range deleted {
+		for _, statement := range []string{
+			"DELETE FROM crawl_jobs WHERE workspace_id = $1",
+			"DELETE FROM workspace_invites WHERE workspace_id = $1",
+			"DELETE FROM workspace_members WHERE workspace_id = $1",
+			"DELETE FROM workspaces WHERE workspace_id = $1",
+		} {
+			_, err = tx.ExecContext(ctx, statement, workspaceID)
+			if err != nil {
+				return nil, fmt.Errorf("could not delete workspace: %v", err)
+			}
+		}
+	}
+
+	statements := []string{
+		"DELETE FROM crawl_jobs WHERE user_id = $1 OR workspace_id = $1",
+		"DELETE FROM workspace_members WHERE user_id = $1",
+		"DELETE FROM workspace_invites WHERE invited_by = $1 OR email = (SELECT LOWER(email) FROM users WHERE user_id = $1)",
+		"DELETE FROM login_attempts WHERE user_id = $1 OR LOWER(email) = (SELECT LOWER(email) FROM users WHERE user_id = $1)",
+		"DELETE FROM recovery_codes WHERE user_id = $1",
+		"DELETE FROM user_identities WHERE user_id = $1",
+		"DELETE FROM api_keys WHERE user_id = $1",
+		"DELETE FROM webhooks WHERE user_id = $1",
+		"DELETE FROM notification_preferences WHERE user_id = $1",
+		"DELETE FROM user_tokens WHERE user_id = $1",
+		"DELETE FROM auth WHERE user_id = $1",
+		"DELETE FROM users WHERE user_id = $1",
+	}
+	for _, statement := range statements {
+		_, err = tx.ExecContext(ctx, statement, userID)
+		if err != nil {
+			return nil, fmt.Errorf("could not delete account: %v", err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("could not delete account: %v", err)
+	}
+	// The personal workspace may also have a workspaces row, list it once so its files are only purged once
+	workspaces := []string{userID}
+	for _, workspaceID := range deleted {
+		if workspaceID != userID {
+			workspaces = append(workspaces, workspaceID)
+		}
+	}
+	return workspaces, nil
+}
+
+func (db *database) GetUserSummary(ctx context.Context, userID string) (UserSummary, error) {
+	if db.db == nil {
+		return UserSummary{}, fmt.Errorf("database is nil")
+	}
+	users, err := db.getUserSummaries(ctx, "WHERE u.user_id = $1", userID)
+	if err != nil {
+		return UserSummary{}, err
+	} else if len(users) == 0 {
+		return UserSummary{}, fmt.Errorf("could not find user")
+	}
+	return users[0], nil
+}
+
+// GetUserCrawlJobs lists every crawl the user started, newest first.
+func (db *database) GetUserCrawlJobs(ctx context.Context, userID string) ([]CrawlJob, error) {
+	if db.db == nil {
+		return nil, fmt.Errorf("database is nil")
+	}
+	return db.getCrawlJobs(ctx, "WHERE j.user_id = $1 ORDER BY j.started_at DESC", userID)
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Ztkent/data-manager/internal/config"
+	"github.com/Ztkent/data-manager/internal/processor"
+)
+
+// ApplyExtractionRules runs the crawl's rules against each page collected since the crawl started.
+// Records from a previous extraction of the same page and rule are replaced.
+func (db *database) ApplyExtractionRules(ctx context.Context, startingURL string, rules []config.ExtractionRule, since time.Time) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	compiled, err := processor.CompileRules(rules)
+	if err != nil {
+		return err
+	}
+	hasHTML, err := db.tableExists(ctx, "html")
+	if err != nil || !hasHTML {
+		return err
+	}
+
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT url, html
+		FROM html
+		WHERE CAST(updated_at AS TEXT) >= $1
+	`, since.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return fmt.Errorf("could not query sqlite: %v", err)
+	}
+	extracted := make(map[string][]processor.ExtractedValue)
+	for rows.Next() {
+		var url, html string
+		if err := rows.Scan(&url, &html); err != nil {
+			rows.Close()
+			return fmt.Errorf("could not scan sqlite: %v", err)
+		}
+		values, err := processor.ApplyRules(html, compiled)
+		if err != nil {
+			slog.WarnContext(ctx, "could not apply extraction rules", "url", url, "error", err)
+			continue
+		}
+		extracted[url] = values
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate sqlite: %v", err)
+	}
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	for url, values := range extracted {
+		for _, rule := range rules {
+			_, err = tx.ExecContext(ctx, "DELETE FROM extracted_records WHERE page_url = $1 AND rule_name = $2", url, rule.Name)
+			if err != nil {
+				return fmt.Errorf("could not clear extracted records: %v", err)
+			}
+		}
+		for _, value := range values {
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO extracted_records (starting_url, page_url, rule_name, value, extracted_at)
+				VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+			`, startingURL, url, value.Rule, value.Value)
+			if err != nil {
+				return fmt.Errorf("could not insert extracted record: %v", err)
+			}
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_VISITED_LIMIT = 25  // Default page size for visited results
+	DEFAULT_FILE_LIMIT    = 50  // Default page size for file results
+	MAX_RESULTS_LIMIT     = 200 // Largest page size a request may ask for
+)
+
+// ResultsFilter describes a page of results from a user's results DB.
+// Zero values mean "no filter", IsBlocked/IsComplete only apply to visited.
+type ResultsFilter struct {
+	Cursor     string
+	Limit      int
+	SortBy     string // "date", "url" or "id"
+	Ascending  bool
+	Domain     string
+	Referrer   string
+	IsBlocked  *bool
+	IsComplete *bool
+	From       time.Time
+	To         time.Time // Inclusive
+}
+
+// Form values for the tri-state filters, "" when unset
+func (f ResultsFilter) BlockedParam() string {
+	return boolParam(f.IsBlocked)
+}
+
+func (f ResultsFilter) CompleteParam() string {
+	return boolParam(f.IsComplete)
+}
+
+func boolParam(value *bool) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprintf("%t", *value)
+}
+
+type resultsCursor struct {
+	Key string `json:"k"`
+	ID  int    `json:"i"`
+}
+
+func encodeCursor(key string, id int) string {
+	data, _ := json.Marshal(resultsCursor{Key: key, ID: id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (resultsCursor, error) {
+	var c resultsCursor
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor: %v", err)
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("invalid cursor: %v", err)
+	}
+	return c, nil
+}
+
+// Builds the WHERE and ORDER BY clauses for a filtered, keyset-paginated query
+type filterQuery struct {
+	conditions []string
+	args       []interface{}
+	sortKey    string
+	direction  string
+	limit      int
+}
+
+func (q *filterQuery) arg(value interface{}) string {
+	q.args = append(q.args, value)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+// newFilterQuery maps the filter onto a table, dateColumn is the column used for "date" sorting and ranges.
+// Filters on columns the table doesn't have are ignored.
+func newFilterQuery(filter ResultsFilter, dateColumn string, hasReferrer bool, hasVisitedFlags bool, defaultLimit int) (*filterQuery, error) {
+	q := &filterQuery{limit: filter.Limit, direction: "DESC"}
+	if q.limit <= 0 {
+		q.limit = defaultLimit
+	} else if q.limit > MAX_RESULTS_LIMIT {
+		q.limit = MAX_RESULTS_LIMIT
+	}
+	if filter.Ascending {
+		q.direction = "ASC"
+	}
+
+	// Keys are compared as text, which orders sqlite timestamps chronologically
+	switch filter.SortBy {
+	case "", "date":
+		q.sortKey = fmt.Sprintf("COALESCE(CAST(%s AS TEXT), '')", dateColumn)
+	case "url":
+		q.sortKey = "COALESCE(url, '')"
+	case "id":
+		q.sortKey = ""
+	default:
+		return nil, fmt.Errorf("invalid sort: %s", filter.SortBy)
+	}
+
+	if filter.Domain != "" {
+		domain := escapeLike(strings.ToLower(strings.TrimSpace(filter.Domain)))
+		q.conditions = append(q.conditions, fmt.Sprintf(
+			`(LOWER(url) LIKE %s ESCAPE '\' OR LOWER(url) LIKE %s ESCAPE '\' OR LOWER(url) LIKE %s ESCAPE '\' OR LOWER(url) LIKE %s ESCAPE '\')`,
+			q.arg("%://"+domain), q.arg("%://"+domain+"/%"), q.arg("%."+domain), q.arg("%."+domain+"/%")))
+	}
+	if filter.Referrer != "" && hasReferrer {
+		q.conditions = append(q.conditions, fmt.Sprintf(`referrer LIKE %s ESCAPE '\'`, q.arg("%"+escapeLike(filter.Referrer)+"%")))
+	}
+	if filter.IsBlocked != nil && hasVisitedFlags {
+		q.conditions = append(q.conditions, "is_blocked = "+q.arg(*filter.IsBlocked))
+	}
+	if filter.IsComplete != nil && hasVisitedFlags {
+		q.conditions = append(q.conditions, "is_complete = "+q.arg(*filter.IsComplete))
+	}
+	if !filter.From.IsZero() {
+		q.conditions = append(q.conditions, fmt.Sprintf("CAST(%s AS TEXT) >= %s", dateColumn, q.arg(filter.From.UTC().Format("2006-01-02 15:04:05"))))
+	}
+	if !filter.To.IsZero() {
+		q.conditions = append(q.conditions, fmt.Sprintf("CAST(%s AS TEXT) <= %s", dateColumn, q.arg(filter.To.UTC().Format("2006-01-02 15:04:05"))))
+	}
+
+	if filter.Cursor != "" {
+		c, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		op := "<"
+		if filter.Ascending {
+			op = ">"
+		}
+		if q.sortKey == "" {
+			q.conditions = append(q.conditions, fmt.Sprintf("id %s %s", op, q.arg(c.ID)))
+		} else {
+			key := q.arg(c.Key)
+			q.conditions = append(q.conditions, fmt.Sprintf("(%s %s %s OR (%s = %s AND id %s %s))",
+				q.sortKey, op, key, q.sortKey, key, op, q.arg(c.ID)))
+		}
+	}
+	return q, nil
+}
+
+// Select expression for the cursor key of each row
+func (q *filterQuery) keyColumn() string {
+	if q.sortKey == "" {
+		return "''"
+	}
+	return q.sortKey
+}
+
+func (q *filterQuery) where() string {
+	if len(q.conditions) == 0 {
+		return ""
+	}
+	return " AND " + strings.Join(q.conditions, " AND ")
+}
+
+// Fetch one extra row to know if there is another page
+func (q *filterQuery) orderAndLimit() string {
+	order := "id " + q.direction
+	if q.sortKey != "" {
+		order = q.sortKey + " " + q.direction + ", " + order
+	}
+	return fmt.Sprintf(" ORDER BY %s LIMIT %d", order, q.limit+1)
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// An external identity linked to a user
+type LinkedIdentity struct {
+	Issuer      string
+	Subject     string
+	Email       string
+	CreatedAt   time.Time
+	LastLoginAt *time.Time
+}
+
+// GetUserIDByIdentity returns the user linked to an external identity, or "" if it isn't linked to anyone.
+// Each successful lookup is recorded as a login with that identity.
+func (db *database) GetUserIDByIdentity(ctx context.Context, issuer, subject string) (string, error) {
+	if db.db == nil {
+		return "", fmt.Errorf("database is nil")
+	}
+	var userID string
+	err := db.db.QueryRowContext(ctx, `
+		UPDATE user_identities
+		SET last_login_at = NOW()
+		WHERE issuer = $1 AND subject = $2
+		RETURNING user_id
+	`, issuer, subject).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("could not query postgres: %v", err)
+	}
+	return userID, nil
+}
+
+func (db *database) LinkIdentity(ctx context.Context, userID, issuer, subject, email string) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	_, err := db.db.ExecContext(ctx, `
+		INSERT INTO user_identities (user_id, issuer, subject, email, created_at, last_login_at)
+		VALUES ($1, $2, $3, $4, NOW(), NOW())
+	`, userID, issuer, subject, normalizeEmail(email))
+	if err != nil {
+		return fmt.Errorf("could not link identity: %v", err)
+	}
+	return nil
+}
+
+// CreateSSOUser creates a user who signs in with an external identity. They have no password until they reset it,
+// and their email is already verified by the identity provider.
+func (db *database) CreateSSOUser(ctx context.Context, userID, email string) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	_, err := db.db.ExecContext(ctx, `
+		INSERT INTO users (user_id, email, password, email_verified_at, created_at, updated_at)
+		VALUES ($1, $2, '', NOW(), NOW(), NOW())
+	`, userID, normalizeEmail(email))
+	if err != nil {
+		return fmt.Errorf("could not create user: %v", err)
+	}
+	return nil
+}
+
+// GetUserIdentities lists the external identities linked to the user.
+func (db *database) GetUserIdentities(ctx context.Context, userID string) ([]LinkedIdentity, error) {
+	if db.db == nil {
+		return nil, fmt.Errorf("database is nil")
+	}
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT issuer, subject, COALESCE(email, ''), created_at, last_login_at
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY created_at, id
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not query postgres: %v", err)
+	}
+	defer rows.Close()
+	var identities []LinkedIdentity
+	for rows.Next() {
+		var identity LinkedIdentity
+		if err := rows.Scan(&identity.Issuer, &identity.Subject, &identity.Email, &identity.CreatedAt, &identity.LastLoginAt); err != nil {
+			return nil, fmt.Errorf("could not scan postgres: %v", err)
+		}
+		identities = append(identities, identity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate postgres: %v", err)
+	}
+	return identities, nil
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Reasons recorded with each login attempt
+const (
+	LOGIN_SUCCESS            = "success"
+	LOGIN_BAD_CREDENTIALS    = "bad_credentials"
+	LOGIN_LOCKED_OUT         = "locked_out"
+	LOGIN_TWO_FACTOR_PENDING = "two_factor_pending"
+	LOGIN_BAD_TWO_FACTOR     = "bad_two_factor"
+)
+
+type LoginAttempt struct {
+	Email     string
+	UserID    string // Empty when the email doesn't belong to a user, or we didn't check
+	IPAddress string
+	UserAgent string
+	Success   bool
+	Reason    string
+	CreatedAt time.Time
+}
+
+func (db *database) RecordLoginAttempt(ctx context.Context, attempt LoginAttempt) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	_, err := db.db.ExecContext(ctx, `
+		INSERT INTO login_attempts (email, user_id, ip_address, user_agent, success, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+	`, normalizeEmail(attempt.Email), sql.NullString{String: attempt.UserID, Valid: attempt.UserID != ""}, attempt.IPAddress, attempt.UserAgent, attempt.Success, attempt.Reason)
+	if err != nil {
+		return fmt.Errorf("could not record login attempt: %v", err)
+	}
+	return nil
+}
+
+// GetUserLoginAttempts lists the logins tried with the user's account or email, oldest first.
+func (db *database) GetUserLoginAttempts(ctx context.Context, userID string) ([]LoginAttempt, error) {
+	if db.db == nil {
+		return nil, fmt.Errorf("database is nil")
+	}
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT email, COALESCE(user_id, ''), COALESCE(ip_address, ''), COALESCE(user_agent, ''), success, reason, created_at
+		FROM login_attempts
+		WHERE user_id = $1 OR LOWER(email) = (SELECT LOWER(email) FROM users WHERE user_id = $1)
+		ORDER BY created_at, id
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not query postgres: %v", err)
+	}
+	defer rows.Close()
+	var attempts []LoginAttempt
+	for rows.Next() {
+		var a LoginAttempt
+		if err := rows.Scan(&a.Email, &a.UserID, &a.IPAddress, &a.UserAgent, &a.Success, &a.Reason, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("could not scan postgres: %v", err)
+		}
+		attempts = append(attempts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate postgres: %v", err)
+	}
+	return attempts, nil
+}
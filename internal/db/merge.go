@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+type tableColumn struct {
+	name string
+	kind string
+	pk   int
+}
+
+// MergeResults copies the results collected in another results DB into this one.
+// Rows that conflict with a unique key here are skipped, so this DB's results win.
+// Integer ids are reassigned, and the search index is left for IndexContent to rebuild.
+func (db *database) MergeResults(ctx context.Context, sourcePath string) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	// Attached databases belong to a single connection
+	conn, err := db.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get sqlite connection: %v", err)
+	}
+	defer conn.Close()
+	_, err = conn.ExecContext(ctx, "ATTACH DATABASE $1 AS source", sourcePath)
+	if err != nil {
+		return fmt.Errorf("could not attach results db: %v", err)
+	}
+	defer conn.ExecContext(ctx, "DETACH DATABASE source")
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT name, sql FROM source.sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name NOT LIKE 'search_index%'
+	`)
+	if err != nil {
+		return fmt.Errorf("could not query sqlite: %v", err)
+	}
+	tables := make(map[string]string)
+	for rows.Next() {
+		var name, schema string
+		if err := rows.Scan(&name, &schema); err != nil {
+			rows.Close()
+			return fmt.Errorf("could not scan sqlite: %v", err)
+		}
+		tables[name] = schema
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate sqlite: %v", err)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	for name, schema := range tables {
+		var exists int
+		err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM main.sqlite_master WHERE type = 'table' AND name = $1", name).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("could not query sqlite: %v", err)
+		}
+		if exists == 0 {
+			// Unqualified tables are created in the main database
+			if _, err = tx.ExecContext(ctx, schema); err != nil {
+				return fmt.Errorf("could not create table %s: %v", name, err)
+			}
+		}
+
+		sourceColumns, err := tableColumns(ctx, tx, "source", name)
+		if err != nil {
+			return err
+		}
+		mainColumns, err := tableColumns(ctx, tx, "main", name)
+		if err != nil {
+			return err
+		}
+		shared := make(map[string]bool)
+		for _, column := range mainColumns {
+			shared[column.name] = true
+		}
+		// Integer primary keys alias the rowid, leave them for sqlite to assign
+		pks := 0
+		for _, column := range sourceColumns {
+			if column.pk > 0 {
+				pks++
+			}
+		}
+		var columns []string
+		for _, column := range sourceColumns {
+			if !shared[column.name] || (pks == 1 && column.pk == 1 && strings.EqualFold(column.kind, "INTEGER")) {
+				continue
+			}
+			columns = append(columns, `"`+strings.ReplaceAll(column.name, `"`, `""`)+`"`)
+		}
+		if len(columns) == 0 {
+			continue
+		}
+		table := `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+		list := strings.Join(columns, ", ")
+		_, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT OR IGNORE INTO main.%s (%s) SELECT %s FROM source.%s", table, list, list, table))
+		if err != nil {
+			return fmt.Errorf("could not merge table %s: %v", name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func tableColumns(ctx context.Context, tx *sql.Tx, schema string, table string) ([]tableColumn, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT name, type, pk FROM pragma_table_info($1, $2)", table, schema)
+	if err != nil {
+		return nil, fmt.Errorf("could not query sqlite: %v", err)
+	}
+	defer rows.Close()
+	var columns []tableColumn
+	for rows.Next() {
+		var column tableColumn
+		if err := rows.Scan(&column.name, &column.kind, &column.pk); err != nil {
+			return nil, fmt.Errorf("could not scan sqlite: %v", err)
+		}
+		columns = append(columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate sqlite: %v", err)
+	}
+	return columns, nil
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// Opens a results DB in the test's temp dir with the given statements run on it
+func newResultsDB(t *testing.T, name string, statements ...string) (*sql.DB, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	sqliteDB := ConnectSqlite(path)
+	if sqliteDB == nil {
+		t.Fatal("could not connect to sqlite")
+	}
+	t.Cleanup(func() { sqliteDB.Close() })
+	for _, statement := range statements {
+		if _, err := sqliteDB.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return sqliteDB, path
+}
+
+func TestMergeResultsKeepsAccountRows(t *testing.T) {
+	account, _ := newResultsDB(t, "account.db",
+		`CREATE TABLE visited (id INTEGER PRIMARY KEY, url TEXT UNIQUE, referrer TEXT)`,
+		`CREATE TABLE html (id INTEGER PRIMARY KEY, url TEXT UNIQUE, html TEXT)`,
+		`INSERT INTO visited (url, referrer) VALUES ('https://a.com', 'account')`,
+		`INSERT INTO html (url, html) VALUES ('https://a.com', '<p>account</p>')`,
+	)
+	_, sourcePath := newResultsDB(t, "anonymous.db",
+		`CREATE TABLE visited (id INTEGER PRIMARY KEY, url TEXT UNIQUE, referrer TEXT)`,
+		`CREATE TABLE html (id INTEGER PRIMARY KEY, url TEXT UNIQUE, html TEXT)`,
+		`CREATE TABLE images (id INTEGER PRIMARY KEY, url TEXT UNIQUE, referrer TEXT)`,
+		// The anonymous rows use the same ids as the account's
+		`INSERT INTO visited (id, url, referrer) VALUES (1, 'https://a.com', 'anonymous'), (2, 'https://b.com', 'anonymous')`,
+		`INSERT INTO html (id, url, html) VALUES (1, 'https://a.com', '<p>anonymous</p>'), (2, 'https://b.com', '<p>anonymous</p>')`,
+		`INSERT INTO images (url, referrer) VALUES ('https://cdn/a.png', 'https://b.com')`,
+	)
+
+	err := NewManagerDatabase(account).MergeResults(context.Background(), sourcePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"account's page wins", `SELECT referrer FROM visited WHERE url = 'https://a.com'`, "account"},
+		{"account's html wins", `SELECT html FROM html WHERE url = 'https://a.com'`, "<p>account</p>"},
+		{"new page is added", `SELECT referrer FROM visited WHERE url = 'https://b.com'`, "anonymous"},
+		{"new html is added", `SELECT html FROM html WHERE url = 'https://b.com'`, "<p>anonymous</p>"},
+		{"ids are reassigned", `SELECT group_concat(id || ':' || url, ' ') FROM (SELECT id, url FROM visited ORDER BY id)`, "1:https://a.com 2:https://b.com"},
+		{"new table is created", `SELECT referrer FROM images WHERE url = 'https://cdn/a.png'`, "https://b.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			if err := account.QueryRow(tt.query).Scan(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/Ztkent/data-manager/internal/processor"
+)
+
+// ProcessMetadata extracts metadata for any collected HTML that is new or changed since it was last processed.
+func (db *database) ProcessMetadata(ctx context.Context) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	hasHTML, err := db.tableExists(ctx, "html")
+	if err != nil || !hasHTML {
+		return err
+	}
+
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT h.url, h.html, CAST(h.updated_at AS TEXT)
+		FROM html h
+		LEFT JOIN page_metadata m ON m.url = h.url
+		WHERE m.url IS NULL OR m.source_updated_at IS NOT CAST(h.updated_at AS TEXT)
+	`)
+	if err != nil {
+		return fmt.Errorf("could not query sqlite: %v", err)
+	}
+	type page struct {
+		url       string
+		meta      processor.Metadata
+		updatedAt string
+	}
+	var pages []page
+	for rows.Next() {
+		var url, html, updatedAt string
+		if err := rows.Scan(&url, &html, &updatedAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("could not scan sqlite: %v", err)
+		}
+		meta, err := processor.ExtractMetadata(html)
+		if err != nil {
+			slog.WarnContext(ctx, "could not extract metadata", "url", url, "error", err)
+			continue
+		}
+		pages = append(pages, page{url: url, meta: meta, updatedAt: updatedAt})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate sqlite: %v", err)
+	}
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	for _, p := range pages {
+		openGraph, _ := json.Marshal(p.meta.OpenGraph)
+		twitter, _ := json.Marshal(p.meta.Twitter)
+		headings, _ := json.Marshal(p.meta.Headings)
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO page_metadata (url, title, description, canonical_url, language, open_graph, twitter, headings, word_count, source_updated_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, CURRENT_TIMESTAMP)
+			ON CONFLICT (url) DO UPDATE
+			SET title = $2, description = $3, canonical_url = $4, language = $5, open_graph = $6, twitter = $7,
+				headings = $8, word_count = $9, source_updated_at = $10, updated_at = CURRENT_TIMESTAMP
+		`, p.url, p.meta.Title, p.meta.Description, p.meta.CanonicalURL, p.meta.Language,
+			string(openGraph), string(twitter), string(headings), p.meta.WordCount, p.updatedAt)
+		if err != nil {
+			return fmt.Errorf("could not upsert page metadata: %v", err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/Ztkent/data-manager/internal/email"
+)
+
+func (db *database) GetUserEmail(ctx context.Context, userID string) (string, error) {
+	if db.db == nil {
+		return "", fmt.Errorf("database is nil")
+	}
+	var address string
+	err := db.db.QueryRowContext(ctx, "SELECT email FROM users WHERE user_id = $1", userID).Scan(&address)
+	if err != nil {
+		return "", fmt.Errorf("could not find user: %v", err)
+	}
+	return address, nil
+}
+
+// GetNotificationPreferences returns the user's preferences, everything is off until they opt in.
+func (db *database) GetNotificationPreferences(ctx context.Context, userID string) (email.Preferences, error) {
+	if db.db == nil {
+		return email.Preferences{}, fmt.Errorf("database is nil")
+	}
+	var prefs email.Preferences
+	err := db.db.QueryRowContext(ctx, `
+		SELECT crawl_summaries, quota_warnings, account_events
+		FROM notification_preferences
+		WHERE user_id = $1
+	`, userID).Scan(&prefs.CrawlSummaries, &prefs.QuotaWarnings, &prefs.AccountEvents)
+	if err == sql.ErrNoRows {
+		return email.Preferences{}, nil
+	} else if err != nil {
+		return email.Preferences{}, fmt.Errorf("could not query notification preferences: %v", err)
+	}
+	return prefs, nil
+}
+
+func (db *database) UpdateNotificationPreferences(ctx context.Context, userID string, prefs email.Preferences) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	_, err := db.db.ExecContext(ctx, `
+		INSERT INTO notification_preferences (user_id, crawl_summaries, quota_warnings, account_events, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (user_id) DO UPDATE
+		SET crawl_summaries = $2, quota_warnings = $3, account_events = $4, updated_at = NOW()
+	`, userID, prefs.CrawlSummaries, prefs.QuotaWarnings, prefs.AccountEvents)
+	if err != nil {
+		return fmt.Errorf("could not upsert notification preferences: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// GetUserAccess returns the user's system role, and whether their account is disabled or scheduled for deletion.
+func (db *database) GetUserAccess(ctx context.Context, userID string) (string, bool, error) {
+	if db.db == nil {
+		return "", false, fmt.Errorf("database is nil")
+	}
+	var role string
+	var disabled bool
+	err := db.db.QueryRowContext(ctx, "SELECT role, disabled_at IS NOT NULL OR deletion_requested_at IS NOT NULL FROM users WHERE user_id = $1", userID).Scan(&role, &disabled)
+	if err != nil {
+		return "", false, fmt.Errorf("could not find user: %v", err)
+	}
+	return role, disabled, nil
+}
+
+func (db *database) SetUserRole(ctx context.Context, userID, role string) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	result, err := db.db.ExecContext(ctx, "UPDATE users SET role = $2, updated_at = NOW() WHERE user_id = $1", userID, role)
+	if err != nil {
+		return fmt.Errorf("could not update role: %v", err)
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return fmt.Errorf("could not find user")
+	}
+	return nil
+}
+
+// PromoteAdmins makes the accounts with these emails admins, so operators can bootstrap access.
+func (db *database) PromoteAdmins(ctx context.Context, emails []string) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	normalized := make([]string, len(emails))
+	for i, email := range emails {
+		normalized[i] = normalizeEmail(email)
+	}
+	_, err := db.db.ExecContext(ctx, "UPDATE users SET role = 'admin', updated_at = NOW() WHERE LOWER(email) = ANY($1) AND role != 'admin'", pq.Array(normalized))
+	if err != nil {
+		return fmt.Errorf("could not promote admins: %v", err)
+	}
+	return nil
+}
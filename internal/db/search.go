@@ -0,0 +1,207 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Ztkent/data-manager/internal/processor"
+)
+
+const SEARCH_PAGE_SIZE = 20 // Number of search results per page
+
+// Markers wrapped around each match in a search snippet, the caller decides how to render them
+const (
+	SnippetMatchStart = "\x02"
+	SnippetMatchEnd   = "\x03"
+)
+
+type SearchResult struct {
+	URL     string
+	Source  string
+	Snippet string
+}
+
+type SearchResults struct {
+	Query   string
+	Page    int
+	HasNext bool
+	Results []SearchResult
+}
+
+// IndexContent adds the visited rows and pages collected since the crawl started to the full-text search index,
+// replacing any earlier entries for the same URLs. The first crawl to build the index includes everything already collected.
+// Requires the sqlite driver to be built with the sqlite_fts5 tag.
+func (db *database) IndexContent(ctx context.Context, since time.Time) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	hasIndex, err := db.tableExists(ctx, "search_index")
+	if err != nil {
+		return err
+	}
+	if !hasIndex {
+		_, err = db.db.ExecContext(ctx, `
+			CREATE VIRTUAL TABLE IF NOT EXISTS search_index
+			USING fts5(url, source UNINDEXED, content, tokenize = 'porter unicode61')
+		`)
+		if err != nil {
+			return fmt.Errorf("could not create search index: %v", err)
+		}
+		since = time.Time{}
+	}
+	updatedSince := since.UTC().Format("2006-01-02 15:04:05")
+
+	type entry struct {
+		url     string
+		source  string
+		content string
+	}
+	var entries []entry
+	hasVisited, err := db.tableExists(ctx, "visited")
+	if err != nil {
+		return err
+	}
+	if hasVisited {
+		rows, err := db.db.QueryContext(ctx, `
+			SELECT url, url || ' ' || COALESCE(referrer, '')
+			FROM visited
+			WHERE CAST(last_visited_at AS TEXT) >= $1
+		`, updatedSince)
+		if err != nil {
+			return fmt.Errorf("could not query sqlite: %v", err)
+		}
+		for rows.Next() {
+			e := entry{source: "visited"}
+			if err := rows.Scan(&e.url, &e.content); err != nil {
+				rows.Close()
+				return fmt.Errorf("could not scan sqlite: %v", err)
+			}
+			entries = append(entries, e)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("could not iterate sqlite: %v", err)
+		}
+	}
+
+	hasHTML, err := db.tableExists(ctx, "html")
+	if err != nil {
+		return err
+	}
+	if hasHTML {
+		rows, err := db.db.QueryContext(ctx, "SELECT url, html FROM html WHERE CAST(updated_at AS TEXT) >= $1", updatedSince)
+		if err != nil {
+			return fmt.Errorf("could not query sqlite: %v", err)
+		}
+		for rows.Next() {
+			var url, html string
+			if err := rows.Scan(&url, &html); err != nil {
+				rows.Close()
+				return fmt.Errorf("could not scan sqlite: %v", err)
+			}
+			entries = append(entries, entry{url: url, source: "html", content: processor.ExtractText(html)})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("could not iterate sqlite: %v", err)
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	for _, e := range entries {
+		// Match on the url column first so the delete uses the index instead of scanning every entry
+		if match := buildColumnMatchQuery("url", e.url); match != "" {
+			_, err = tx.ExecContext(ctx, "DELETE FROM search_index WHERE search_index MATCH $1 AND url = $2 AND source = $3", match, e.url, e.source)
+			if err != nil {
+				return fmt.Errorf("could not clear search index: %v", err)
+			}
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO search_index (url, source, content) VALUES ($1, $2, $3)", e.url, e.source, e.content)
+		if err != nil {
+			return fmt.Errorf("could not index %s: %v", e.source, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *database) SearchContent(ctx context.Context, query string, page int) (SearchResults, error) {
+	if db.db == nil {
+		return SearchResults{}, fmt.Errorf("database is nil")
+	}
+	if page < 1 {
+		page = 1
+	}
+	results := SearchResults{Query: query, Page: page}
+	match := buildMatchQuery(query)
+	if match == "" {
+		return results, nil
+	}
+
+	hasIndex, err := db.tableExists(ctx, "search_index")
+	if err != nil || !hasIndex {
+		return results, err
+	}
+
+	// Fetch one extra row to know if there is another page
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT url, source, snippet(search_index, 2, $1, $2, '...', 16)
+		FROM search_index
+		WHERE search_index MATCH $3
+		ORDER BY rank
+		LIMIT $4 OFFSET $5
+	`, SnippetMatchStart, SnippetMatchEnd, match, SEARCH_PAGE_SIZE+1, (page-1)*SEARCH_PAGE_SIZE)
+	if err != nil {
+		return results, fmt.Errorf("could not query sqlite: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r SearchResult
+		if err := rows.Scan(&r.URL, &r.Source, &r.Snippet); err != nil {
+			return results, fmt.Errorf("could not scan sqlite: %v", err)
+		}
+		results.Results = append(results.Results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return results, fmt.Errorf("could not iterate sqlite: %v", err)
+	}
+	if len(results.Results) > SEARCH_PAGE_SIZE {
+		results.HasNext = true
+		results.Results = results.Results[:SEARCH_PAGE_SIZE]
+	}
+	return results, nil
+}
+
+// Quote each term so user input can't break the FTS5 query syntax, terms are implicitly AND'ed
+func buildMatchQuery(query string) string {
+	terms := strings.Fields(query)
+	for i, term := range terms {
+		terms[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	return strings.Join(terms, " ")
+}
+
+// Match the value as a single phrase within one column
+func buildColumnMatchQuery(column string, value string) string {
+	if strings.TrimSpace(value) == "" {
+		return ""
+	}
+	return column + ` : "` + strings.ReplaceAll(value, `"`, `""`) + `"`
+}
+
+func (db *database) tableExists(ctx context.Context, name string) (bool, error) {
+	var count int
+	err := db.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE name = $1", name).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("could not query sqlite: %v", err)
+	}
+	return count > 0, nil
+}
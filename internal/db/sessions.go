@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const SESSION_LAST_SEEN_INTERVAL = 5 * time.Minute // How stale a session's last seen time can get before we update it
+
+type Session struct {
+	ID         string
+	UserAgent  string
+	IPAddress  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+}
+
+// GetSessions lists the user's active sessions, most recently used first.
+func (db *database) GetSessions(ctx context.Context, userID string) ([]Session, error) {
+	if db.db == nil {
+		return nil, fmt.Errorf("database is nil")
+	}
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT jti, COALESCE(user_agent, ''), COALESCE(ip_address, ''), created_at, COALESCE(last_seen_at, updated_at), expires_at
+		FROM auth
+		WHERE user_id = $1 AND expires_at > $2
+		ORDER BY COALESCE(last_seen_at, updated_at) DESC
+	`, userID, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("could not query postgres: %v", err)
+	}
+	defer rows.Close()
+	var sessions []Session
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.UserAgent, &s.IPAddress, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("could not scan postgres: %v", err)
+		}
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate postgres: %v", err)
+	}
+	return sessions, nil
+}
+
+// DeleteOtherSessions removes every session except the given one, and returns the removed session ids.
+func (db *database) DeleteOtherSessions(ctx context.Context, userID, sessionID string) ([]string, error) {
+	if db.db == nil {
+		return nil, fmt.Errorf("database is nil")
+	}
+	rows, err := db.db.QueryContext(ctx, "DELETE FROM auth WHERE user_id = $1 AND jti != $2 RETURNING jti", userID, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("could not delete sessions: %v", err)
+	}
+	defer rows.Close()
+	var removed []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("could not scan postgres: %v", err)
+		}
+		removed = append(removed, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate postgres: %v", err)
+	}
+	return removed, nil
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Purposes for single-use user tokens
+const (
+	TOKEN_VERIFY_EMAIL   = "verify_email"
+	TOKEN_RESET_PASSWORD = "reset_password"
+)
+
+const VERIFY_EMAIL_TOKEN_TTL = 24 * time.Hour
+const RESET_PASSWORD_TOKEN_TTL = 1 * time.Hour
+
+// CreateUserToken returns a new random token for the user, only its hash is stored.
+func (db *database) CreateUserToken(ctx context.Context, userID string, purpose string, ttl time.Duration) (string, error) {
+	if db.db == nil {
+		return "", fmt.Errorf("database is nil")
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("could not generate token: %v", err)
+	}
+	token := hex.EncodeToString(raw)
+	_, err := db.db.ExecContext(ctx, `
+		INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, NOW())
+	`, userID, purpose, hashToken(token), time.Now().UTC().Add(ttl))
+	if err != nil {
+		return "", fmt.Errorf("could not insert user token: %v", err)
+	}
+	return token, nil
+}
+
+// ConsumeUserToken marks an unused, unexpired token as used and returns the user it belongs to.
+func (db *database) ConsumeUserToken(ctx context.Context, token string, purpose string) (string, error) {
+	if db.db == nil {
+		return "", fmt.Errorf("database is nil")
+	}
+	var userID string
+	err := db.db.QueryRowContext(ctx, `
+		UPDATE user_tokens
+		SET used_at = NOW()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3
+		RETURNING user_id
+	`, hashToken(token), purpose, time.Now().UTC()).Scan(&userID)
+	if err != nil {
+		return "", fmt.Errorf("invalid or expired token")
+	}
+	return userID, nil
+}
+
+func (db *database) GetUserIDByEmail(ctx context.Context, email string) (string, error) {
+	if db.db == nil {
+		return "", fmt.Errorf("database is nil")
+	}
+	var userID string
+	err := db.db.QueryRowContext(ctx, "SELECT user_id FROM users WHERE LOWER(email) = $1", normalizeEmail(email)).Scan(&userID)
+	if err != nil {
+		return "", fmt.Errorf("could not find user: %v", err)
+	}
+	return userID, nil
+}
+
+func (db *database) IsEmailVerified(ctx context.Context, userID string) (bool, error) {
+	if db.db == nil {
+		return false, fmt.Errorf("database is nil")
+	}
+	var verified bool
+	err := db.db.QueryRowContext(ctx, "SELECT email_verified_at IS NOT NULL FROM users WHERE user_id = $1", userID).Scan(&verified)
+	if err != nil {
+		return false, fmt.Errorf("could not find user: %v", err)
+	}
+	return verified, nil
+}
+
+func (db *database) MarkEmailVerified(ctx context.Context, userID string) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	_, err := db.db.ExecContext(ctx, `
+		UPDATE users
+		SET email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+		WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("could not verify email: %v", err)
+	}
+	return nil
+}
+
+// UpdatePassword sets a new password, then signs the user out and invalidates any other reset links.
+func (db *database) UpdatePassword(ctx context.Context, userID string, password string) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("could not hash password: %v", err)
+	}
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, "UPDATE users SET password = $2, updated_at = NOW() WHERE user_id = $1", userID, hashedPassword)
+	if err != nil {
+		return fmt.Errorf("could not update password: %v", err)
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM auth WHERE user_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("could not clear user auth: %v", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE user_tokens
+		SET used_at = NOW()
+		WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL
+	`, userID, TOKEN_RESET_PASSWORD)
+	if err != nil {
+		return fmt.Errorf("could not clear reset tokens: %v", err)
+	}
+	return tx.Commit()
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// The user's authenticator secret. The secret is pending until the user confirms a code from it.
+type TOTP struct {
+	Secret  string
+	Enabled bool
+}
+
+func (db *database) GetTOTP(ctx context.Context, userID string) (TOTP, error) {
+	if db.db == nil {
+		return TOTP{}, fmt.Errorf("database is nil")
+	}
+	var secret sql.NullString
+	var totp TOTP
+	err := db.db.QueryRowContext(ctx, `
+		SELECT totp_secret, totp_enabled_at IS NOT NULL
+		FROM users
+		WHERE user_id = $1
+	`, userID).Scan(&secret, &totp.Enabled)
+	if err != nil {
+		return TOTP{}, fmt.Errorf("could not find user: %v", err)
+	}
+	totp.Secret = secret.String
+	return totp, nil
+}
+
+// SetPendingTOTPSecret stores a new secret while the user adds it to their authenticator.
+// It doesn't replace the secret of a user who already has 2FA enabled.
+func (db *database) SetPendingTOTPSecret(ctx context.Context, userID, secret string) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	_, err := db.db.ExecContext(ctx, `
+		UPDATE users
+		SET totp_secret = $2, totp_last_step = NULL, updated_at = NOW()
+		WHERE user_id = $1 AND totp_enabled_at IS NULL
+	`, userID, secret)
+	if err != nil {
+		return fmt.Errorf("could not update totp secret: %v", err)
+	}
+	return nil
+}
+
+// EnableTOTP turns on 2FA with the pending secret, once the user has confirmed the code for step.
+func (db *database) EnableTOTP(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users
+		SET totp_enabled_at = NOW(), totp_last_step = $2, updated_at = NOW()
+		WHERE user_id = $1 AND totp_secret IS NOT NULL
+	`, userID, step)
+	if err != nil {
+		return fmt.Errorf("could not enable totp: %v", err)
+	}
+	err = replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *database) DisableTOTP(ctx context.Context, userID string) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users
+		SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL, updated_at = NOW()
+		WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("could not disable totp: %v", err)
+	}
+	err = replaceRecoveryCodes(ctx, tx, userID, nil)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that the code for step was used, and reports false if it, or a later one, was used already.
+func (db *database) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	if db.db == nil {
+		return false, fmt.Errorf("database is nil")
+	}
+	result, err := db.db.ExecContext(ctx, `
+		UPDATE users
+		SET totp_last_step = $2
+		WHERE user_id = $1 AND (totp_last_step IS NULL OR totp_last_step < $2)
+	`, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("could not update totp step: %v", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("could not update totp step: %v", err)
+	}
+	return rows == 1, nil
+}
+
+func (db *database) SetRecoveryCodes(ctx context.Context, userID string, recoveryCodeHashes []string) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	err = replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode marks an unused recovery code as used, and reports false if the user has no such code.
+func (db *database) UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error) {
+	if db.db == nil {
+		return false, fmt.Errorf("database is nil")
+	}
+	result, err := db.db.ExecContext(ctx, `
+		UPDATE recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("could not use recovery code: %v", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("could not use recovery code: %v", err)
+	}
+	return rows == 1, nil
+}
+
+func (db *database) CountRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	if db.db == nil {
+		return 0, fmt.Errorf("database is nil")
+	}
+	var count int
+	err := db.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL", userID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("could not query postgres: %v", err)
+	}
+	return count, nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID string, recoveryCodeHashes []string) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("could not clear recovery codes: %v", err)
+	}
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO recovery_codes (user_id, code_hash, created_at)
+			VALUES ($1, $2, NOW())
+		`, userID, hash)
+		if err != nil {
+			return fmt.Errorf("could not insert recovery code: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Ztkent/data-manager/internal/webhook"
+)
+
+const MAX_WEBHOOK_DELIVERIES = 25 // Number of recent deliveries shown to a user
+
+type WebhookDelivery struct {
+	ID         int
+	WebhookID  int
+	URL        string
+	Event      string
+	Attempt    int
+	StatusCode int
+	Error      string
+	CreatedAt  time.Time
+}
+
+func (db *database) CreateWebhook(ctx context.Context, userID, url, secret string) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	_, err := db.db.ExecContext(ctx, `
+		INSERT INTO webhooks (user_id, url, secret, created_at)
+		VALUES ($1, $2, $3, NOW())
+	`, userID, url, secret)
+	if err != nil {
+		return fmt.Errorf("could not insert webhook: %v", err)
+	}
+	return nil
+}
+
+func (db *database) GetWebhooks(ctx context.Context, userID string) ([]webhook.Hook, error) {
+	if db.db == nil {
+		return nil, fmt.Errorf("database is nil")
+	}
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT id, url, secret, created_at
+		FROM webhooks
+		WHERE user_id = $1
+		ORDER BY id
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not query postgres: %v", err)
+	}
+	defer rows.Close()
+	var hooks []webhook.Hook
+	for rows.Next() {
+		var hook webhook.Hook
+		if err := rows.Scan(&hook.ID, &hook.URL, &hook.Secret, &hook.CreatedAt); err != nil {
+			return nil, fmt.Errorf("could not scan postgres: %v", err)
+		}
+		hooks = append(hooks, hook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate postgres: %v", err)
+	}
+	return hooks, nil
+}
+
+func (db *database) GetWebhook(ctx context.Context, userID string, id int) (webhook.Hook, error) {
+	if db.db == nil {
+		return webhook.Hook{}, fmt.Errorf("database is nil")
+	}
+	var hook webhook.Hook
+	err := db.db.QueryRowContext(ctx, `
+		SELECT id, url, secret, created_at
+		FROM webhooks
+		WHERE user_id = $1 AND id = $2
+	`, userID, id).Scan(&hook.ID, &hook.URL, &hook.Secret, &hook.CreatedAt)
+	if err != nil {
+		return webhook.Hook{}, fmt.Errorf("could not find webhook: %v", err)
+	}
+	return hook, nil
+}
+
+func (db *database) DeleteWebhook(ctx context.Context, userID string, id int) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	_, err := db.db.ExecContext(ctx, "DELETE FROM webhooks WHERE user_id = $1 AND id = $2", userID, id)
+	if err != nil {
+		return fmt.Errorf("could not delete webhook: %v", err)
+	}
+	return nil
+}
+
+func (db *database) LogWebhookDelivery(webhookID int, event string, payload string, attempt int, statusCode int, deliveryErr string) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	var status sql.NullInt64
+	if statusCode != 0 {
+		status = sql.NullInt64{Int64: int64(statusCode), Valid: true}
+	}
+	_, err := db.db.Exec(`
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, attempt, status_code, error, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+	`, webhookID, event, payload, attempt, status, deliveryErr)
+	if err != nil {
+		return fmt.Errorf("could not log webhook delivery: %v", err)
+	}
+	return nil
+}
+
+func (db *database) GetWebhookDeliveries(ctx context.Context, userID string) ([]WebhookDelivery, error) {
+	if db.db == nil {
+		return nil, fmt.Errorf("database is nil")
+	}
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT d.id, d.webhook_id, w.url, d.event, d.attempt, COALESCE(d.status_code, 0), COALESCE(d.error, ''), d.created_at
+		FROM webhook_deliveries d
+		JOIN webhooks w ON w.id = d.webhook_id
+		WHERE w.user_id = $1
+		ORDER BY d.id DESC
+		LIMIT $2
+	`, userID, MAX_WEBHOOK_DELIVERIES)
+	if err != nil {
+		return nil, fmt.Errorf("could not query postgres: %v", err)
+	}
+	defer rows.Close()
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.URL, &d.Event, &d.Attempt, &d.StatusCode, &d.Error, &d.CreatedAt); err != nil {
+			return nil, fmt.Errorf("could not scan postgres: %v", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate postgres: %v", err)
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,338 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrLastWorkspaceOwner = errors.New("a workspace needs at least one owner")
+var ErrInviteNotFound = errors.New("workspace invite not found")
+
+// A team workspace the user belongs to. Every user also has a personal workspace,
+// which isn't stored here: its id is their user id, and only they can use it.
+type Workspace struct {
+	ID        string
+	Name      string
+	Role      string
+	CreatedAt time.Time
+}
+
+type WorkspaceMember struct {
+	UserID    string
+	Email     string
+	Role      string
+	CreatedAt time.Time
+}
+
+// An invitation to join a team workspace, which the user with the email has to accept
+type WorkspaceInvite struct {
+	WorkspaceID   string
+	WorkspaceName string
+	Email         string
+	Role          string
+	CreatedAt     time.Time
+}
+
+// CreateWorkspace creates a team workspace, owned by the user who created it.
+func (db *database) CreateWorkspace(ctx context.Context, workspaceID, name, ownerID string) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO workspaces (workspace_id, name, created_by, created_at)
+		VALUES ($1, $2, $3, NOW())
+	`, workspaceID, name, ownerID)
+	if err != nil {
+		return fmt.Errorf("could not insert workspace: %v", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO workspace_members (workspace_id, user_id, role, created_at)
+		VALUES ($1, $2, 'owner', NOW())
+	`, workspaceID, ownerID)
+	if err != nil {
+		return fmt.Errorf("could not insert workspace member: %v", err)
+	}
+	return tx.Commit()
+}
+
+// GetWorkspaces lists the team workspaces the user belongs to, with their role in each.
+func (db *database) GetWorkspaces(ctx context.Context, userID string) ([]Workspace, error) {
+	if db.db == nil {
+		return nil, fmt.Errorf("database is nil")
+	}
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT w.workspace_id, w.name, m.role, w.created_at
+		FROM workspaces w
+		JOIN workspace_members m ON m.workspace_id = w.workspace_id
+		WHERE m.user_id = $1
+		ORDER BY w.name
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not query postgres: %v", err)
+	}
+	defer rows.Close()
+	var workspaces []Workspace
+	for rows.Next() {
+		var w Workspace
+		if err := rows.Scan(&w.ID, &w.Name, &w.Role, &w.CreatedAt); err != nil {
+			return nil, fmt.Errorf("could not scan postgres: %v", err)
+		}
+		workspaces = append(workspaces, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate postgres: %v", err)
+	}
+	return workspaces, nil
+}
+
+// GetWorkspaceRole returns the user's role in a team workspace, or "" if they aren't a member.
+func (db *database) GetWorkspaceRole(ctx context.Context, workspaceID, userID string) (string, error) {
+	if db.db == nil {
+		return "", fmt.Errorf("database is nil")
+	}
+	var role string
+	err := db.db.QueryRowContext(ctx, `
+		SELECT role
+		FROM workspace_members
+		WHERE workspace_id = $1 AND user_id = $2
+	`, workspaceID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("could not query postgres: %v", err)
+	}
+	return role, nil
+}
+
+func (db *database) GetWorkspaceMembers(ctx context.Context, workspaceID string) ([]WorkspaceMember, error) {
+	if db.db == nil {
+		return nil, fmt.Errorf("database is nil")
+	}
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT m.user_id, u.email, m.role, m.created_at
+		FROM workspace_members m
+		JOIN users u ON u.user_id = m.user_id
+		WHERE m.workspace_id = $1
+		ORDER BY m.created_at
+	`, workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("could not query postgres: %v", err)
+	}
+	defer rows.Close()
+	var members []WorkspaceMember
+	for rows.Next() {
+		var member WorkspaceMember
+		if err := rows.Scan(&member.UserID, &member.Email, &member.Role, &member.CreatedAt); err != nil {
+			return nil, fmt.Errorf("could not scan postgres: %v", err)
+		}
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate postgres: %v", err)
+	}
+	return members, nil
+}
+
+// SetWorkspaceMember changes the role of a member of a team workspace, users join by accepting an invite.
+// A workspace always keeps at least one owner.
+func (db *database) SetWorkspaceMember(ctx context.Context, workspaceID, userID, role string) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `
+		UPDATE workspace_members
+		SET role = $3
+		WHERE workspace_id = $1 AND user_id = $2
+	`, workspaceID, userID, role)
+	if err != nil {
+		return fmt.Errorf("could not update workspace member: %v", err)
+	}
+	err = requireWorkspaceOwner(ctx, tx, workspaceID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveWorkspaceMember removes the user from a team workspace, unless they're its last owner.
+func (db *database) RemoveWorkspaceMember(ctx context.Context, workspaceID, userID string) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, "DELETE FROM workspace_members WHERE workspace_id = $1 AND user_id = $2", workspaceID, userID)
+	if err != nil {
+		return fmt.Errorf("could not remove workspace member: %v", err)
+	}
+	err = requireWorkspaceOwner(ctx, tx, workspaceID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// InviteWorkspaceMember invites whoever has the email to a team workspace, replacing any earlier invite for it.
+// Invites aren't checked against existing accounts, so they don't reveal which emails have one.
+func (db *database) InviteWorkspaceMember(ctx context.Context, workspaceID, email, role, invitedBy string) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	_, err := db.db.ExecContext(ctx, `
+		INSERT INTO workspace_invites (workspace_id, email, role, invited_by, created_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (workspace_id, email) DO UPDATE SET role = EXCLUDED.role, invited_by = EXCLUDED.invited_by, created_at = NOW()
+	`, workspaceID, normalizeEmail(email), role, invitedBy)
+	if err != nil {
+		return fmt.Errorf("could not insert workspace invite: %v", err)
+	}
+	return nil
+}
+
+// GetWorkspaceInvites lists the pending invites to a team workspace.
+func (db *database) GetWorkspaceInvites(ctx context.Context, workspaceID string) ([]WorkspaceInvite, error) {
+	return db.queryWorkspaceInvites(ctx, `
+		SELECT i.workspace_id, w.name, i.email, i.role, i.created_at
+		FROM workspace_invites i
+		JOIN workspaces w ON w.workspace_id = i.workspace_id
+		WHERE i.workspace_id = $1
+		ORDER BY i.created_at
+	`, workspaceID)
+}
+
+// GetUserWorkspaceInvites lists the pending invites to the user's email.
+func (db *database) GetUserWorkspaceInvites(ctx context.Context, userID string) ([]WorkspaceInvite, error) {
+	return db.queryWorkspaceInvites(ctx, `
+		SELECT i.workspace_id, w.name, i.email, i.role, i.created_at
+		FROM workspace_invites i
+		JOIN workspaces w ON w.workspace_id = i.workspace_id
+		JOIN users u ON LOWER(u.email) = i.email
+		WHERE u.user_id = $1
+		ORDER BY w.name
+	`, userID)
+}
+
+func (db *database) queryWorkspaceInvites(ctx context.Context, query string, args ...interface{}) ([]WorkspaceInvite, error) {
+	if db.db == nil {
+		return nil, fmt.Errorf("database is nil")
+	}
+	rows, err := db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query postgres: %v", err)
+	}
+	defer rows.Close()
+	var invites []WorkspaceInvite
+	for rows.Next() {
+		var invite WorkspaceInvite
+		if err := rows.Scan(&invite.WorkspaceID, &invite.WorkspaceName, &invite.Email, &invite.Role, &invite.CreatedAt); err != nil {
+			return nil, fmt.Errorf("could not scan postgres: %v", err)
+		}
+		invites = append(invites, invite)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate postgres: %v", err)
+	}
+	return invites, nil
+}
+
+// AcceptWorkspaceInvite adds the user to the workspace with the role they were invited with.
+// Users who are already members keep their current role.
+func (db *database) AcceptWorkspaceInvite(ctx context.Context, workspaceID, userID string) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	var role string
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM workspace_invites
+		WHERE workspace_id = $1 AND email = (SELECT LOWER(email) FROM users WHERE user_id = $2)
+		RETURNING role
+	`, workspaceID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return ErrInviteNotFound
+	} else if err != nil {
+		return fmt.Errorf("could not delete workspace invite: %v", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO workspace_members (workspace_id, user_id, role, created_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (workspace_id, user_id) DO NOTHING
+	`, workspaceID, userID, role)
+	if err != nil {
+		return fmt.Errorf("could not insert workspace member: %v", err)
+	}
+	return tx.Commit()
+}
+
+// DeleteWorkspaceInvite cancels or declines an invite.
+func (db *database) DeleteWorkspaceInvite(ctx context.Context, workspaceID, email string) error {
+	if db.db == nil {
+		return fmt.Errorf("database is nil")
+	}
+	_, err := db.db.ExecContext(ctx, "DELETE FROM workspace_invites WHERE workspace_id = $1 AND email = $2", workspaceID, normalizeEmail(email))
+	if err != nil {
+		return fmt.Errorf("could not delete workspace invite: %v", err)
+	}
+	return nil
+}
+
+// GetRecentlyActiveWorkspaces lists the team workspaces with a member who was active in the last 3 days.
+func (db *database) GetRecentlyActiveWorkspaces(ctx context.Context) ([]string, error) {
+	if db.db == nil {
+		return nil, fmt.Errorf("database is nil")
+	}
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT DISTINCT m.workspace_id
+		FROM workspace_members m
+		JOIN auth a ON a.user_id = m.user_id
+		WHERE COALESCE(a.last_seen_at, a.updated_at) > NOW() - INTERVAL '72 hours'
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("could not query postgres: %v", err)
+	}
+	defer rows.Close()
+	var workspaces []string
+	for rows.Next() {
+		var workspace string
+		if err := rows.Scan(&workspace); err != nil {
+			return nil, fmt.Errorf("could not scan postgres: %v", err)
+		}
+		workspaces = append(workspaces, workspace)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate postgres: %v", err)
+	}
+	return workspaces, nil
+}
+
+func requireWorkspaceOwner(ctx context.Context, tx *sql.Tx, workspaceID string) error {
+	var owners int
+	err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM workspace_members WHERE workspace_id = $1 AND role = 'owner'", workspaceID).Scan(&owners)
+	if err != nil {
+		return fmt.Errorf("could not query postgres: %v", err)
+	}
+	if owners == 0 {
+		return ErrLastWorkspaceOwner
+	}
+	return nil
+}
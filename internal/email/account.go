@@ -0,0 +1,32 @@
+package email
+
+import "fmt"
+
+// Account emails are always sent, they don't depend on notification preferences
+
+func VerifyEmailMessage(to string, link string) Message {
+	return Message{
+		To:      to,
+		Subject: "Verify your Data Manager email",
+		Body: fmt.Sprintf("Welcome to Data Manager!\n\nConfirm this is your email address by opening the link below:\n%s\n\n"+
+			"The link expires in 24 hours. If you didn't create an account, you can ignore this email.\n", link),
+	}
+}
+
+func PasswordResetMessage(to string, link string) Message {
+	return Message{
+		To:      to,
+		Subject: "Reset your Data Manager password",
+		Body: fmt.Sprintf("We received a request to reset your password. Choose a new one by opening the link below:\n%s\n\n"+
+			"The link expires in 1 hour and can only be used once. If you didn't ask for a reset, you can ignore this email.\n", link),
+	}
+}
+
+func WorkspaceInviteMessage(to string, workspace string, link string) Message {
+	return Message{
+		To:      to,
+		Subject: "You've been invited to a Data Manager workspace",
+		Body: fmt.Sprintf("You've been invited to join the %s workspace on Data Manager.\n\nLog in or create an account with this email, then accept the invite from the Workspaces menu:\n%s\n\n"+
+			"If you weren't expecting this, you can ignore this email.\n", workspace, link),
+	}
+}
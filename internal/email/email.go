@@ -0,0 +1,101 @@
+package email
+
+import (
+	"fmt"
+	"log/slog"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers a plain text email
+type Sender interface {
+	Send(msg Message) error
+}
+
+// NewSenderFromEnv returns an SMTP sender when SMTP_HOST is set, otherwise a fake sender that only logs.
+func NewSenderFromEnv() Sender {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return &FakeSender{}
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	return &SMTPSender{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+}
+
+type SMTPSender struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPSender) Send(msg Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	err := smtp.SendMail(s.Host+":"+s.Port, auth, s.From, []string{msg.To}, buildMessage(s.From, msg))
+	if err != nil {
+		return fmt.Errorf("could not send email: %v", err)
+	}
+	return nil
+}
+
+// FakeSender keeps every message in memory instead of sending it, for local development and tests.
+type FakeSender struct {
+	sent []Message
+	sync.Mutex
+}
+
+func (s *FakeSender) Send(msg Message) error {
+	s.Lock()
+	defer s.Unlock()
+	s.sent = append(s.sent, msg)
+	slog.Info("email", "to", msg.To, "subject", msg.Subject)
+	return nil
+}
+
+func (s *FakeSender) Sent() []Message {
+	s.Lock()
+	defer s.Unlock()
+	sent := make([]Message, len(s.sent))
+	copy(sent, s.sent)
+	return sent
+}
+
+func buildMessage(from string, msg Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + headerValue(from) + "\r\n")
+	b.WriteString("To: " + headerValue(msg.To) + "\r\n")
+	b.WriteString("Subject: " + headerValue(msg.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// Header values can't contain line breaks, or they could inject extra headers
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
@@ -0,0 +1,79 @@
+package email
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Notification categories a user can opt in to
+const (
+	CrawlSummaries = "crawl_summaries"
+	QuotaWarnings  = "quota_warnings"
+	AccountEvents  = "account_events"
+)
+
+type Preferences struct {
+	CrawlSummaries bool
+	QuotaWarnings  bool
+	AccountEvents  bool
+}
+
+func (p Preferences) Enabled(category string) bool {
+	switch category {
+	case CrawlSummaries:
+		return p.CrawlSummaries
+	case QuotaWarnings:
+		return p.QuotaWarnings
+	case AccountEvents:
+		return p.AccountEvents
+	}
+	return false
+}
+
+type CrawlSummary struct {
+	StartingURL string
+	Status      string
+	StartedAt   time.Time
+	FinishedAt  time.Time
+	Pages       int
+	Duplicates  int
+	Error       string
+}
+
+func CrawlSummaryMessage(to string, summary CrawlSummary) Message {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Your crawl of %s has %s.\n\n", summary.StartingURL, summary.Status)
+	fmt.Fprintf(&b, "Started: %s\n", summary.StartedAt.UTC().Format("2006-01-02 15:04:05 MST"))
+	fmt.Fprintf(&b, "Finished: %s\n", summary.FinishedAt.UTC().Format("2006-01-02 15:04:05 MST"))
+	fmt.Fprintf(&b, "Duration: %s\n", summary.FinishedAt.Sub(summary.StartedAt).Round(time.Second))
+	fmt.Fprintf(&b, "Pages collected: %d\n", summary.Pages)
+	fmt.Fprintf(&b, "Near-duplicates: %d\n", summary.Duplicates)
+	if summary.Error != "" {
+		fmt.Fprintf(&b, "Error: %s\n", summary.Error)
+	}
+	b.WriteString(footer)
+	return Message{
+		To:      to,
+		Subject: fmt.Sprintf("Crawl %s: %s", summary.Status, summary.StartingURL),
+		Body:    b.String(),
+	}
+}
+
+func QuotaWarningMessage(to string, warning string) Message {
+	return Message{
+		To:      to,
+		Subject: "Data Manager quota warning",
+		Body:    warning + "\n" + footer,
+	}
+}
+
+func AccountEventMessage(to string, event string, detail string) Message {
+	return Message{
+		To:      to,
+		Subject: "Data Manager account: " + event,
+		Body:    detail + "\n\nIf this wasn't you, reset your password and sign out your other sessions.\n" + footer,
+	}
+}
+
+const footer = "\n--\nYou are receiving this because you opted in to Data Manager notifications. Update your preferences from the Account menu."
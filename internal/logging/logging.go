@@ -0,0 +1,193 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const REQUEST_ID_HEADER = "X-Request-ID" // Header used to pass request ids in and out
+const MAX_REQUEST_ID_LENGTH = 64         // Longest incoming request id we'll reuse
+
+type contextKey string
+
+const (
+	requestIDKey contextKey = "request_id"
+	jobIDKey     contextKey = "job_id"
+)
+
+// Setup makes a JSON logger the default for both log/slog and the standard log package.
+// The minimum level is read from LOG_LEVEL (debug, info, warn or error), and defaults to info.
+func Setup() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))); err != nil {
+		level = slog.LevelInfo
+	}
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(&contextHandler{Handler: handler}))
+}
+
+// Fatal logs the error and exits.
+func Fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// contextHandler adds the request, job and trace ids carried by the context to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String(string(requestIDKey), id))
+	}
+	if id := JobID(ctx); id != "" {
+		record.AddAttrs(slog.String(string(jobIDKey), id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithJobID marks everything logged with the context as concerning a crawl job.
+func WithJobID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, jobIDKey, id)
+}
+
+func JobID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(jobIDKey).(string)
+	return id
+}
+
+// RequestIDMiddleware gives each request an id, reusing the caller's if it sent a sensible one,
+// and returns it in the response so problems can be matched to the logs.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(REQUEST_ID_HEADER)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, id)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > MAX_REQUEST_ID_LENGTH {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// AccessLog logs each request once it has been served, at warn for client errors and error for server errors.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		} else if status >= http.StatusBadRequest {
+			level = slog.LevelWarn
+		}
+		slog.LogAttrs(r.Context(), level, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Int("bytes", ww.BytesWritten()),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("user_agent", r.UserAgent()),
+		)
+	})
+}
+
+// LineWriter logs each line written to it, so a subprocess's output ends up in the logs with the context's ids.
+// Close logs anything left after the last newline.
+type LineWriter struct {
+	ctx    context.Context
+	level  slog.Level
+	attrs  []any
+	buffer []byte
+	sync.Mutex
+}
+
+func NewLineWriter(ctx context.Context, level slog.Level, attrs ...any) *LineWriter {
+	return &LineWriter{ctx: ctx, level: level, attrs: attrs}
+}
+
+func (lw *LineWriter) Write(p []byte) (int, error) {
+	lw.Lock()
+	defer lw.Unlock()
+	lw.buffer = append(lw.buffer, p...)
+	for {
+		i := bytes.IndexByte(lw.buffer, '\n')
+		if i < 0 {
+			break
+		}
+		lw.log(lw.buffer[:i])
+		lw.buffer = lw.buffer[i+1:]
+	}
+	return len(p), nil
+}
+
+func (lw *LineWriter) Close() error {
+	lw.Lock()
+	defer lw.Unlock()
+	lw.log(lw.buffer)
+	lw.buffer = nil
+	return nil
+}
+
+func (lw *LineWriter) log(line []byte) {
+	msg := strings.TrimSpace(string(line))
+	if msg == "" {
+		return
+	}
+	slog.Log(lw.ctx, lw.level, msg, lw.attrs...)
+}
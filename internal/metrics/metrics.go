@@ -0,0 +1,185 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const NAMESPACE = "data_manager" // Prefix for every metric we export
+
+var (
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to serve HTTP requests, by route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	CrawlerExits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "crawler_exits_total",
+		Help:      "Crawler subprocesses that have exited, by how they ended.",
+	}, []string{"status"})
+
+	GraphGenerationDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "graph_generation_duration_seconds",
+		Help:      "Time taken by the processor to generate a network graph.",
+		Buckets:   prometheus.ExponentialBuckets(0.25, 2, 10),
+	})
+
+	ExportBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "export_size_bytes",
+		Help:      "Size of exported results, by format.",
+		Buckets:   prometheus.ExponentialBuckets(1<<10, 4, 10),
+	}, []string{"format"})
+)
+
+// Middleware records how long each request took, labelled with the route pattern rather than the path,
+// so ids in paths don't create new series.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		RequestDuration.WithLabelValues(r.Method, route, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// RegisterPostgres exports the Postgres connection pool stats.
+func RegisterPostgres(db *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, "postgres"))
+}
+
+// RegisterCrawlers exports the number of active crawlers, per user and in total.
+// crawlers is called on each scrape, and returns the active crawler count for each user.
+func RegisterCrawlers(crawlers func() map[string]int) {
+	prometheus.MustRegister(&crawlerCollector{crawlers: crawlers})
+}
+
+// RegisterSQLite exports the connection pool stats of the open results DBs, summed together.
+// stats is called on each scrape.
+func RegisterSQLite(stats func() []sql.DBStats) {
+	prometheus.MustRegister(&sqliteCollector{stats: stats})
+}
+
+// RegisterRedis exports whether Redis answers a ping, and its connection pool stats.
+func RegisterRedis(client *redis.Client) {
+	prometheus.MustRegister(&redisCollector{client: client})
+}
+
+var (
+	activeCrawlersDesc      = prometheus.NewDesc(NAMESPACE+"_user_crawlers_running", "Crawlers currently running, by the user who started them.", []string{"user"}, nil)
+	activeCrawlersTotalDesc = prometheus.NewDesc(NAMESPACE+"_crawlers_running", "Crawlers currently running across every workspace.", nil, nil)
+)
+
+type crawlerCollector struct {
+	crawlers func() map[string]int
+}
+
+func (c *crawlerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- activeCrawlersDesc
+	ch <- activeCrawlersTotalDesc
+}
+
+func (c *crawlerCollector) Collect(ch chan<- prometheus.Metric) {
+	total := 0
+	for user, count := range c.crawlers() {
+		total += count
+		ch <- prometheus.MustNewConstMetric(activeCrawlersDesc, prometheus.GaugeValue, float64(count), user)
+	}
+	ch <- prometheus.MustNewConstMetric(activeCrawlersTotalDesc, prometheus.GaugeValue, float64(total))
+}
+
+var (
+	sqliteOpenDBsDesc         = prometheus.NewDesc(NAMESPACE+"_sqlite_open_databases", "Results DBs currently open.", nil, nil)
+	sqliteOpenConnectionsDesc = prometheus.NewDesc(NAMESPACE+"_sqlite_open_connections", "Open connections across every results DB.", nil, nil)
+	sqliteInUseDesc           = prometheus.NewDesc(NAMESPACE+"_sqlite_in_use_connections", "Connections in use across every results DB.", nil, nil)
+	sqliteWaitCountDesc       = prometheus.NewDesc(NAMESPACE+"_sqlite_wait_count_total", "Times a results DB query waited for a connection.", nil, nil)
+	sqliteWaitDurationDesc    = prometheus.NewDesc(NAMESPACE+"_sqlite_wait_duration_seconds_total", "Time results DB queries spent waiting for a connection.", nil, nil)
+)
+
+type sqliteCollector struct {
+	stats func() []sql.DBStats
+}
+
+func (c *sqliteCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sqliteOpenDBsDesc
+	ch <- sqliteOpenConnectionsDesc
+	ch <- sqliteInUseDesc
+	ch <- sqliteWaitCountDesc
+	ch <- sqliteWaitDurationDesc
+}
+
+func (c *sqliteCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.stats()
+	var total sql.DBStats
+	for _, s := range stats {
+		total.OpenConnections += s.OpenConnections
+		total.InUse += s.InUse
+		total.WaitCount += s.WaitCount
+		total.WaitDuration += s.WaitDuration
+	}
+	ch <- prometheus.MustNewConstMetric(sqliteOpenDBsDesc, prometheus.GaugeValue, float64(len(stats)))
+	ch <- prometheus.MustNewConstMetric(sqliteOpenConnectionsDesc, prometheus.GaugeValue, float64(total.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(sqliteInUseDesc, prometheus.GaugeValue, float64(total.InUse))
+	ch <- prometheus.MustNewConstMetric(sqliteWaitCountDesc, prometheus.CounterValue, float64(total.WaitCount))
+	ch <- prometheus.MustNewConstMetric(sqliteWaitDurationDesc, prometheus.CounterValue, total.WaitDuration.Seconds())
+}
+
+const REDIS_PING_TIMEOUT = 2 * time.Second // How long a scrape waits for Redis to answer
+
+var (
+	redisUpDesc          = prometheus.NewDesc(NAMESPACE+"_redis_up", "Whether Redis answered a ping.", nil, nil)
+	redisPingDesc        = prometheus.NewDesc(NAMESPACE+"_redis_ping_seconds", "Time Redis took to answer a ping.", nil, nil)
+	redisConnectionsDesc = prometheus.NewDesc(NAMESPACE+"_redis_connections", "Redis pool connections, by state.", []string{"state"}, nil)
+	redisTimeoutsDesc    = prometheus.NewDesc(NAMESPACE+"_redis_pool_timeouts_total", "Times the Redis pool timed out handing out a connection.", nil, nil)
+)
+
+type redisCollector struct {
+	client *redis.Client
+}
+
+func (c *redisCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- redisUpDesc
+	ch <- redisPingDesc
+	ch <- redisConnectionsDesc
+	ch <- redisTimeoutsDesc
+}
+
+func (c *redisCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), REDIS_PING_TIMEOUT)
+	defer cancel()
+	start := time.Now()
+	up := 1.0
+	if err := c.client.Ping(ctx).Err(); err != nil {
+		up = 0
+	}
+	ch <- prometheus.MustNewConstMetric(redisUpDesc, prometheus.GaugeValue, up)
+	ch <- prometheus.MustNewConstMetric(redisPingDesc, prometheus.GaugeValue, time.Since(start).Seconds())
+
+	stats := c.client.PoolStats()
+	ch <- prometheus.MustNewConstMetric(redisConnectionsDesc, prometheus.GaugeValue, float64(stats.IdleConns), "idle")
+	ch <- prometheus.MustNewConstMetric(redisConnectionsDesc, prometheus.GaugeValue, float64(stats.TotalConns-stats.IdleConns), "in_use")
+	ch <- prometheus.MustNewConstMetric(redisTimeoutsDesc, prometheus.CounterValue, float64(stats.Timeouts))
+}
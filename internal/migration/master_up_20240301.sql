@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS "webhooks" (
+    "id" SERIAL PRIMARY KEY,
+    "user_id" varchar(255) NOT NULL,
+    "url" text NOT NULL,
+    "secret" varchar(255) NOT NULL,
+    "created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY ("user_id") REFERENCES "users" ("user_id")
+);
+
+CREATE TABLE IF NOT EXISTS "webhook_deliveries" (
+    "id" SERIAL PRIMARY KEY,
+    "webhook_id" integer NOT NULL,
+    "event" varchar(64) NOT NULL,
+    "payload" text NOT NULL,
+    "attempt" integer NOT NULL,
+    "status_code" integer,
+    "error" text,
+    "created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY ("webhook_id") REFERENCES "webhooks" ("id") ON DELETE CASCADE
+);
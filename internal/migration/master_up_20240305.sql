@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS "notification_preferences" (
+    "user_id" varchar(255) PRIMARY KEY,
+    "crawl_summaries" boolean NOT NULL DEFAULT false,
+    "quota_warnings" boolean NOT NULL DEFAULT false,
+    "account_events" boolean NOT NULL DEFAULT false,
+    "updated_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY ("user_id") REFERENCES "users" ("user_id")
+);
\ No newline at end of file
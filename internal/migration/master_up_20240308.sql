@@ -0,0 +1,12 @@
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "email_verified_at" timestamp;
+
+CREATE TABLE IF NOT EXISTS "user_tokens" (
+    "id" SERIAL PRIMARY KEY,
+    "user_id" varchar(255) NOT NULL,
+    "purpose" varchar(32) NOT NULL,
+    "token_hash" varchar(64) UNIQUE NOT NULL,
+    "expires_at" timestamp NOT NULL,
+    "used_at" timestamp,
+    "created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY ("user_id") REFERENCES "users" ("user_id")
+);
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE "auth" ADD COLUMN IF NOT EXISTS "jti" varchar(64);
+ALTER TABLE "auth" ADD COLUMN IF NOT EXISTS "expires_at" timestamp;
\ No newline at end of file
@@ -0,0 +1,7 @@
+ALTER TABLE "auth" DROP CONSTRAINT IF EXISTS "auth_user_id_key";
+ALTER TABLE "auth" ADD COLUMN IF NOT EXISTS "user_agent" text;
+ALTER TABLE "auth" ADD COLUMN IF NOT EXISTS "ip_address" varchar(64);
+ALTER TABLE "auth" ADD COLUMN IF NOT EXISTS "last_seen_at" timestamp;
+DELETE FROM "auth" WHERE "jti" IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS "auth_jti_key" ON "auth" ("jti");
+CREATE INDEX IF NOT EXISTS "auth_user_id_idx" ON "auth" ("user_id");
\ No newline at end of file
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS "api_keys" (
+    "id" SERIAL PRIMARY KEY,
+    "user_id" varchar(255) NOT NULL,
+    "name" varchar(255) NOT NULL,
+    "prefix" varchar(16) NOT NULL,
+    "key_hash" varchar(64) UNIQUE NOT NULL,
+    "scopes" text NOT NULL,
+    "created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+    "last_used_at" timestamp,
+    "revoked_at" timestamp,
+    FOREIGN KEY ("user_id") REFERENCES "users" ("user_id")
+);
\ No newline at end of file
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS "user_identities" (
+    "id" SERIAL PRIMARY KEY,
+    "user_id" varchar(255) NOT NULL,
+    "issuer" varchar(255) NOT NULL,
+    "subject" varchar(255) NOT NULL,
+    "email" varchar(255),
+    "created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+    "last_login_at" timestamp,
+    UNIQUE ("issuer", "subject"),
+    FOREIGN KEY ("user_id") REFERENCES "users" ("user_id")
+);
@@ -0,0 +1,13 @@
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "totp_secret" varchar(64);
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "totp_enabled_at" timestamp;
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "totp_last_step" bigint;
+
+CREATE TABLE IF NOT EXISTS "recovery_codes" (
+    "id" SERIAL PRIMARY KEY,
+    "user_id" varchar(255) NOT NULL,
+    "code_hash" varchar(64) NOT NULL,
+    "created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+    "used_at" timestamp,
+    FOREIGN KEY ("user_id") REFERENCES "users" ("user_id")
+);
+CREATE INDEX IF NOT EXISTS "recovery_codes_user_id_idx" ON "recovery_codes" ("user_id");
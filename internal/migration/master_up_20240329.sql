@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS "login_attempts" (
+    "id" SERIAL PRIMARY KEY,
+    "email" varchar(255) NOT NULL,
+    "user_id" varchar(255),
+    "ip_address" varchar(64),
+    "user_agent" text,
+    "success" boolean NOT NULL,
+    "reason" varchar(32) NOT NULL,
+    "created_at" timestamp DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS "login_attempts_email_idx" ON "login_attempts" ("email", "created_at");
+CREATE INDEX IF NOT EXISTS "login_attempts_ip_address_idx" ON "login_attempts" ("ip_address", "created_at");
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS "workspaces" (
+    "id" SERIAL PRIMARY KEY,
+    "workspace_id" varchar(255) UNIQUE NOT NULL,
+    "name" varchar(255) NOT NULL,
+    "created_by" varchar(255) NOT NULL,
+    "created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY ("created_by") REFERENCES "users" ("user_id")
+);
+
+CREATE TABLE IF NOT EXISTS "workspace_members" (
+    "workspace_id" varchar(255) NOT NULL,
+    "user_id" varchar(255) NOT NULL,
+    "role" varchar(16) NOT NULL,
+    "created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("workspace_id", "user_id"),
+    FOREIGN KEY ("workspace_id") REFERENCES "workspaces" ("workspace_id"),
+    FOREIGN KEY ("user_id") REFERENCES "users" ("user_id")
+);
+CREATE INDEX IF NOT EXISTS "workspace_members_user_id_idx" ON "workspace_members" ("user_id");
@@ -0,0 +1 @@
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "role" varchar(16) NOT NULL DEFAULT 'member';
@@ -0,0 +1,15 @@
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "disabled_at" timestamp;
+
+CREATE TABLE IF NOT EXISTS "crawl_jobs" (
+    "id" SERIAL PRIMARY KEY,
+    "job_id" varchar(255) UNIQUE NOT NULL,
+    "workspace_id" varchar(255) NOT NULL,
+    "user_id" varchar(255) NOT NULL,
+    "starting_url" text NOT NULL,
+    "status" varchar(16) NOT NULL,
+    "error" text NOT NULL DEFAULT '',
+    "started_at" timestamp NOT NULL,
+    "finished_at" timestamp,
+    FOREIGN KEY ("user_id") REFERENCES "users" ("user_id")
+);
+CREATE INDEX IF NOT EXISTS "crawl_jobs_started_at_idx" ON "crawl_jobs" ("started_at");
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS "audit_log" (
+    "id" BIGSERIAL PRIMARY KEY,
+    "actor_id" varchar(255) NOT NULL DEFAULT '',
+    "action" varchar(64) NOT NULL,
+    "workspace_id" varchar(255) NOT NULL DEFAULT '',
+    "target" text NOT NULL DEFAULT '',
+    "detail" text NOT NULL DEFAULT '',
+    "ip_address" varchar(64) NOT NULL DEFAULT '',
+    "user_agent" text NOT NULL DEFAULT '',
+    "created_at" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS "audit_log_actor_id_idx" ON "audit_log" ("actor_id", "created_at");
+CREATE INDEX IF NOT EXISTS "audit_log_created_at_idx" ON "audit_log" ("created_at");
+
+-- The audit log is append-only, rows outlive the accounts they mention
+CREATE OR REPLACE FUNCTION "audit_log_append_only"() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS "audit_log_append_only" ON "audit_log";
+CREATE TRIGGER "audit_log_append_only" BEFORE UPDATE OR DELETE OR TRUNCATE ON "audit_log"
+    FOR EACH STATEMENT EXECUTE FUNCTION "audit_log_append_only"();
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS "workspace_invites" (
+    "workspace_id" varchar(255) NOT NULL,
+    "email" varchar(255) NOT NULL,
+    "role" varchar(16) NOT NULL,
+    "invited_by" varchar(255) NOT NULL,
+    "created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("workspace_id", "email"),
+    FOREIGN KEY ("workspace_id") REFERENCES "workspaces" ("workspace_id"),
+    FOREIGN KEY ("invited_by") REFERENCES "users" ("user_id")
+);
+CREATE INDEX IF NOT EXISTS "workspace_invites_email_idx" ON "workspace_invites" ("email");
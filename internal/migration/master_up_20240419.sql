@@ -0,0 +1,10 @@
+-- Emails are compared in lower case
+CREATE INDEX IF NOT EXISTS "users_email_lower_idx" ON "users" (LOWER("email"));
+
+-- Invites are stored in lower case, keep the latest invite when two differ only in case
+DELETE FROM "workspace_invites" a
+USING "workspace_invites" b
+WHERE a."workspace_id" = b."workspace_id"
+    AND LOWER(a."email") = LOWER(b."email")
+    AND (COALESCE(a."created_at", 'epoch'), a."ctid") < (COALESCE(b."created_at", 'epoch'), b."ctid");
+UPDATE "workspace_invites" SET "email" = LOWER("email") WHERE "email" != LOWER("email");
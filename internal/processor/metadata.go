@@ -0,0 +1,125 @@
+package processor
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const MAX_HEADINGS = 100 // Maximum number of headings kept per page
+
+type Heading struct {
+	Level int    `json:"level"`
+	Text  string `json:"text"`
+}
+
+type Metadata struct {
+	Title        string
+	Description  string
+	CanonicalURL string
+	Language     string
+	OpenGraph    map[string]string
+	Twitter      map[string]string
+	Headings     []Heading
+	WordCount    int
+}
+
+// ExtractMetadata parses an HTML document and collects the page's descriptive metadata.
+func ExtractMetadata(document string) (Metadata, error) {
+	meta := Metadata{
+		OpenGraph: make(map[string]string),
+		Twitter:   make(map[string]string),
+	}
+	root, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return meta, err
+	}
+
+	var body strings.Builder
+	var walk func(n *html.Node, inBody bool)
+	walk = func(n *html.Node, inBody bool) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Html:
+				meta.Language = strings.TrimSpace(attr(n, "lang"))
+			case atom.Title:
+				if meta.Title == "" {
+					meta.Title = nodeText(n)
+				}
+			case atom.Meta:
+				collectMetaTag(n, &meta)
+			case atom.Link:
+				if strings.EqualFold(attr(n, "rel"), "canonical") && meta.CanonicalURL == "" {
+					meta.CanonicalURL = strings.TrimSpace(attr(n, "href"))
+				}
+			case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+				if text := nodeText(n); text != "" && len(meta.Headings) < MAX_HEADINGS {
+					meta.Headings = append(meta.Headings, Heading{Level: int(n.Data[1] - '0'), Text: text})
+				}
+			case atom.Body:
+				inBody = true
+			}
+			if skippedElements[n.Data] {
+				return
+			}
+		} else if n.Type == html.TextNode && inBody {
+			body.WriteString(n.Data)
+			body.WriteString(" ")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, inBody)
+		}
+	}
+	walk(root, false)
+
+	meta.WordCount = len(strings.Fields(body.String()))
+	return meta, nil
+}
+
+func collectMetaTag(n *html.Node, meta *Metadata) {
+	content := strings.TrimSpace(attr(n, "content"))
+	name := strings.ToLower(strings.TrimSpace(attr(n, "name")))
+	// OpenGraph uses property=, though plenty of sites use name=
+	property := strings.ToLower(strings.TrimSpace(attr(n, "property")))
+	if property == "" {
+		property = name
+	}
+
+	switch {
+	case name == "description" && meta.Description == "":
+		meta.Description = content
+	case strings.HasPrefix(property, "og:"):
+		meta.OpenGraph[strings.TrimPrefix(property, "og:")] = content
+	case strings.HasPrefix(property, "twitter:"):
+		meta.Twitter[strings.TrimPrefix(property, "twitter:")] = content
+	case strings.EqualFold(attr(n, "http-equiv"), "content-language") && meta.Language == "":
+		meta.Language = content
+	}
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, key) {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// Text content of a node and its children, with whitespace collapsed
+func nodeText(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+			sb.WriteString(" ")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
@@ -0,0 +1,91 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Ztkent/data-manager/internal/config"
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"golang.org/x/net/html"
+)
+
+// A rule with its selector and regex compiled, ready to apply to many pages
+type CompiledRule struct {
+	config.ExtractionRule
+	css   cascadia.Sel
+	xpath *xpath.Expr
+	regex *regexp.Regexp
+}
+
+type ExtractedValue struct {
+	Rule  string
+	Value string
+}
+
+func CompileRules(rules []config.ExtractionRule) ([]CompiledRule, error) {
+	compiled := make([]CompiledRule, 0, len(rules))
+	for _, rule := range rules {
+		c := CompiledRule{ExtractionRule: rule}
+		var err error
+		switch rule.Type {
+		case "css":
+			c.css, err = cascadia.Parse(rule.Selector)
+		case "xpath":
+			c.xpath, err = xpath.Compile(rule.Selector)
+		default:
+			err = fmt.Errorf("unknown type %s", rule.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector for rule %s: %v", rule.Name, err)
+		}
+		if rule.Regex != "" {
+			c.regex, err = regexp.Compile(rule.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex for rule %s: %v", rule.Name, err)
+			}
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// ApplyRules returns every value matched by the rules, in rule order.
+func ApplyRules(document string, rules []CompiledRule) ([]ExtractedValue, error) {
+	root, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]ExtractedValue, 0)
+	for _, rule := range rules {
+		var nodes []*html.Node
+		if rule.css != nil {
+			nodes = cascadia.QueryAll(root, rule.css)
+		} else {
+			nodes = htmlquery.QuerySelectorAll(root, rule.xpath)
+		}
+		for _, n := range nodes {
+			value := nodeText(n)
+			if rule.Attribute != "" {
+				value = strings.TrimSpace(attr(n, rule.Attribute))
+			}
+			if rule.regex != nil {
+				match := rule.regex.FindStringSubmatch(value)
+				if match == nil {
+					continue
+				}
+				value = match[0]
+				if len(match) > 1 {
+					value = match[1]
+				}
+			}
+			if value != "" {
+				values = append(values, ExtractedValue{Rule: rule.Name, Value: value})
+			}
+		}
+	}
+	return values, nil
+}
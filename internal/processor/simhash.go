@@ -0,0 +1,66 @@
+package processor
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+)
+
+const NEAR_DUPLICATE_DISTANCE = 3                 // Maximum simhash bit difference for two pages to count as near-duplicates
+const SIMHASH_BANDS = NEAR_DUPLICATE_DISTANCE + 1 // Bands a fingerprint is split into, near-duplicates always share one
+
+// Simhash fingerprints text so that similar documents produce fingerprints with a small hamming distance.
+// Features are overlapping 3-word shingles of the lower-cased text, text without any words has no features and hashes to 0.
+func Simhash(text string) uint64 {
+	words := strings.Fields(strings.ToLower(text))
+	if len(words) == 0 {
+		return 0
+	}
+	shingleSize := 3
+	if len(words) < shingleSize {
+		shingleSize = len(words)
+	}
+
+	var weights [64]int
+	for i := 0; i+shingleSize <= len(words); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:i+shingleSize], " ")))
+		feature := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if feature&(1<<uint(bit)) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var fingerprint uint64
+	for bit := 0; bit < 64; bit++ {
+		if weights[bit] > 0 {
+			fingerprint |= 1 << uint(bit)
+		}
+	}
+	return fingerprint
+}
+
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func IsNearDuplicate(a, b uint64) bool {
+	return HammingDistance(a, b) <= NEAR_DUPLICATE_DISTANCE
+}
+
+// SimhashBands splits a fingerprint into keys that can be indexed, so near-duplicates are found
+// by comparing only fingerprints that share a key rather than every pair.
+// Each key includes its band's position, so equal bits in different bands don't collide.
+func SimhashBands(fingerprint uint64) []uint64 {
+	width := 64 / SIMHASH_BANDS
+	keys := make([]uint64, SIMHASH_BANDS)
+	for i := range keys {
+		band := (fingerprint >> uint(i*width)) & (1<<uint(width) - 1)
+		keys[i] = uint64(i)<<uint(width) | band
+	}
+	return keys
+}
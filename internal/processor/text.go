@@ -0,0 +1,44 @@
+package processor
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Elements whose text content is never visible on the page
+var skippedElements = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"noscript": true,
+	"template": true,
+	"svg":      true,
+}
+
+// ExtractText returns the visible text of an HTML document, with whitespace collapsed.
+func ExtractText(document string) string {
+	tokenizer := html.NewTokenizer(strings.NewReader(document))
+	var sb strings.Builder
+	skipDepth := 0
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(sb.String()), " ")
+		case html.StartTagToken:
+			name, _ := tokenizer.TagName()
+			if skippedElements[string(name)] {
+				skipDepth++
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if skippedElements[string(name)] && skipDepth > 0 {
+				skipDepth--
+			}
+		case html.TextToken:
+			if skipDepth == 0 {
+				sb.Write(tokenizer.Text())
+				sb.WriteString(" ")
+			}
+		}
+	}
+}
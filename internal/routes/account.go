@@ -0,0 +1,223 @@
+package routes
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Ztkent/data-manager/internal/auth"
+	"github.com/Ztkent/data-manager/internal/db"
+	"github.com/Ztkent/data-manager/internal/email"
+)
+
+// Limits on how often account emails can be requested
+const (
+	RESET_REQUESTS_PER_EMAIL = 3
+	RESET_REQUESTS_PER_IP    = 10
+	VERIFY_REQUESTS_PER_USER = 3
+	ACCOUNT_EMAIL_WINDOW     = 1 * time.Hour
+)
+
+type accountModal struct {
+	Token   string
+	Message string
+	Error   string
+}
+
+func (m *CrawlMaster) AccountModal() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("close") == "true" {
+			return
+		}
+		tmpl, err := parseTemplate(r.Context(), "internal/html/templates/account_modal.gohtml")
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not parse template", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = executeTemplate(r.Context(), tmpl, w, struct {
+			Admin bool
+		}{
+			Admin: auth.RoleHasPermission(requestUser(r).Role, auth.PermAdmin),
+		})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not render template", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// Send the user a link to confirm their email address
+func (m *CrawlMaster) sendVerificationEmail(ctx context.Context, userID string) error {
+	address, err := m.DB.GetUserEmail(ctx, userID)
+	if err != nil {
+		return err
+	}
+	token, err := m.DB.CreateUserToken(ctx, userID, db.TOKEN_VERIFY_EMAIL, db.VERIFY_EMAIL_TOKEN_TTL)
+	if err != nil {
+		return err
+	}
+	m.sendEmail(email.VerifyEmailMessage(address, baseURL()+"/?verify="+url.QueryEscape(token)))
+	return nil
+}
+
+// Send an account email in the background, regardless of notification preferences
+func (m *CrawlMaster) sendEmail(msg email.Message) {
+	if m.Email == nil {
+		return
+	}
+	go func() {
+		err := m.Email.Send(msg)
+		if err != nil {
+			slog.Error("could not send email", "error", err)
+		}
+	}()
+}
+
+// Count an attempt against a limit, returns false once the limit is reached for the window
+func (m *CrawlMaster) allowAttempt(ctx context.Context, key string, limit int64, window time.Duration) bool {
+	if m.Redis == nil {
+		return true
+	}
+	count, err := m.Redis.Incr(ctx, "rate_limit:"+key).Result()
+	if err != nil {
+		slog.ErrorContext(ctx, "could not count attempt", "error", err)
+		return true
+	}
+	if count == 1 {
+		m.Redis.Expire(ctx, "rate_limit:"+key, window)
+	}
+	return count <= limit
+}
+
+func (m *CrawlMaster) VerifyEmailHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := m.DB.ConsumeUserToken(r.Context(), r.FormValue("token"), db.TOKEN_VERIFY_EMAIL)
+		if err != nil {
+			serveFailToast(w, r, "Verification link is invalid or expired")
+			return
+		}
+		err = m.DB.MarkEmailVerified(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not mark email verified", "error", err)
+			serveFailToast(w, r, "Failed to verify email")
+			return
+		}
+		serveSuccessToast(w, r, "Email verified")
+	}
+}
+
+func (m *CrawlMaster) ResendVerificationHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := requestUser(r).ID
+
+		if !m.allowAttempt(r.Context(), "verify_email:"+userID, VERIFY_REQUESTS_PER_USER, ACCOUNT_EMAIL_WINDOW) {
+			serveFailToast(w, r, "Too many requests, try again later")
+			return
+		}
+		err := m.sendVerificationEmail(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not send verification email", "error", err)
+			serveFailToast(w, r, "Failed to send verification email")
+			return
+		}
+		serveSuccessToast(w, r, "Verification email sent")
+	}
+}
+
+func (m *CrawlMaster) ForgotPasswordModal() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveAccountModal(w, r, "forgot_password_modal.gohtml", accountModal{})
+	}
+}
+
+// SubmitForgotPassword emails a reset link. The response is the same whether or not the account exists.
+func (m *CrawlMaster) SubmitForgotPassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		address := r.FormValue("email")
+		if !validateEmail(address) {
+			serveAccountModal(w, r, "forgot_password_modal.gohtml", accountModal{Error: "Invalid email"})
+			return
+		}
+		if !m.allowAttempt(r.Context(), "reset_password:ip:"+clientIP(r), RESET_REQUESTS_PER_IP, ACCOUNT_EMAIL_WINDOW) ||
+			!m.allowAttempt(r.Context(), "reset_password:email:"+address, RESET_REQUESTS_PER_EMAIL, ACCOUNT_EMAIL_WINDOW) {
+			serveAccountModal(w, r, "forgot_password_modal.gohtml", accountModal{Error: "Too many reset requests, try again later"})
+			return
+		}
+
+		userID, err := m.DB.GetUserIDByEmail(r.Context(), address)
+		if err == nil {
+			token, err := m.DB.CreateUserToken(r.Context(), userID, db.TOKEN_RESET_PASSWORD, db.RESET_PASSWORD_TOKEN_TTL)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "could not create user token", "error", err)
+			} else {
+				m.sendEmail(email.PasswordResetMessage(address, baseURL()+"/?reset="+url.QueryEscape(token)))
+			}
+		}
+		serveAccountModal(w, r, "forgot_password_modal.gohtml", accountModal{
+			Message: "If an account exists for that email, we sent a link to reset your password.",
+		})
+	}
+}
+
+func (m *CrawlMaster) ResetPasswordModal() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.FormValue("token")
+		if token == "" {
+			return
+		}
+		serveAccountModal(w, r, "reset_password_modal.gohtml", accountModal{Token: token})
+	}
+}
+
+func (m *CrawlMaster) SubmitResetPassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		token := r.FormValue("token")
+		pass := r.FormValue("password")
+		repeatPass := r.FormValue("repeat-password")
+
+		validPass, reason := validatePassword(pass, repeatPass)
+		if !validPass {
+			serveAccountModal(w, r, "reset_password_modal.gohtml", accountModal{Token: token, Error: "Invalid password: " + reason})
+			return
+		}
+		userID, err := m.DB.ConsumeUserToken(r.Context(), token, db.TOKEN_RESET_PASSWORD)
+		if err != nil {
+			serveAccountModal(w, r, "reset_password_modal.gohtml", accountModal{Error: "Reset link is invalid or expired"})
+			return
+		}
+		err = m.DB.UpdatePassword(r.Context(), userID, pass)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not update password", "error", err)
+			serveAccountModal(w, r, "reset_password_modal.gohtml", accountModal{Error: "Failed to reset password"})
+			return
+		}
+		// The link was delivered to their inbox, so the address is confirmed too
+		err = m.DB.MarkEmailVerified(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not mark email verified", "error", err)
+		}
+		m.notifyAccountEvent(r.Context(), userID, "Password changed", "The password for your account was reset.")
+
+		serveSuccessToast(w, r, "Password updated, please log in")
+		m.Login()(w, r)
+	}
+}
+
+func serveAccountModal(w http.ResponseWriter, r *http.Request, name string, modal accountModal) {
+	tmpl, err := parseTemplate(r.Context(), "internal/html/templates/"+name)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not parse template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = executeTemplate(r.Context(), tmpl, w, modal)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not render template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
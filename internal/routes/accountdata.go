@@ -0,0 +1,274 @@
+package routes
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Ztkent/data-manager/internal/auth"
+	"github.com/Ztkent/data-manager/internal/db"
+	"github.com/Ztkent/data-manager/internal/email"
+)
+
+const ACCOUNT_DELETION_GRACE_PERIOD = 7 * 24 * time.Hour // Time a user has to change their mind before their account is deleted
+const ACCOUNT_DELETION_INTERVAL = time.Hour              // Time between checks for accounts due for deletion
+
+// Everything stored about a user, as it appears in their data export
+type accountExport struct {
+	ExportedAt              time.Time
+	Account                 db.UserSummary
+	Workspaces              []db.Workspace
+	WorkspaceInvites        []db.WorkspaceInvite // Invites to the user
+	TeamWorkspaceInvites    []db.WorkspaceInvite // Pending invites to the team workspaces the user owns
+	Sessions                []db.Session
+	APIKeys                 []db.APIKey
+	Webhooks                []webhookExport
+	NotificationPreferences email.Preferences
+	TwoFactor               twoFactorExport
+	Identities              []db.LinkedIdentity
+	LoginAttempts           []db.LoginAttempt
+	AuditLog                []db.AuditEvent
+	CrawlJobs               []db.CrawlJob
+}
+
+// Whether 2FA is on, without the secret or recovery codes
+type twoFactorExport struct {
+	Enabled           bool
+	RecoveryCodesLeft int
+}
+
+// Webhooks are exported without their signing secrets
+type webhookExport struct {
+	ID        int
+	URL       string
+	CreatedAt time.Time
+}
+
+func (m *CrawlMaster) AccountDataHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.serveAccountData(w, r, "")
+	}
+}
+
+// ExportAccountHandler downloads a zip of the user's account details, their personal workspace's files,
+// and the files of the team workspaces they own.
+func (m *CrawlMaster) ExportAccountHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		export, err := m.accountExport(r.Context(), requestUser(r).ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not build account export", "error", err)
+			http.Error(w, "Failed to export account", http.StatusInternalServerError)
+			return
+		}
+		account, err := json.MarshalIndent(export, "", "  ")
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not marshal account export", "error", err)
+			http.Error(w, "Failed to export account", http.StatusInternalServerError)
+			return
+		}
+
+		m.audit(r, db.AuditEvent{Action: db.AUDIT_ACCOUNT_EXPORT})
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=data-manager-export-%s.zip", time.Now().UTC().Format("20060102")))
+		w.Header().Set("Content-Type", "application/zip")
+		archive := zip.NewWriter(w)
+		defer archive.Close()
+		file, err := archive.Create("account.json")
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not create archive file", "error", err)
+			return
+		}
+		file.Write(account)
+
+		// Team workspaces go in their own folder
+		folders := map[string]string{requestUser(r).ID: ""}
+		for _, workspace := range export.Workspaces {
+			if workspace.Role == auth.RoleOwner {
+				folders[workspace.ID] = "workspaces/" + workspace.ID + "/"
+			}
+		}
+		names := []string{"results.db", "network.html", "config.json"}
+		for workspaceID, folder := range folders {
+			for i, path := range workspaceFiles(workspaceID) {
+				err := addFileToArchive(archive, folder+names[i], path)
+				if err != nil && !os.IsNotExist(err) {
+					slog.ErrorContext(r.Context(), "could not add file to archive", "error", err)
+				}
+			}
+		}
+	}
+}
+
+// DeleteAccountHandler schedules the user's account for deletion, and logs them out everywhere.
+// The account is deleted after the grace period, unless they log in again.
+func (m *CrawlMaster) DeleteAccountHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := requestUser(r).ID
+
+		address, err := m.DB.GetUserEmail(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not get user email", "error", err)
+			m.serveAccountData(w, r, "Failed to delete account")
+			return
+		}
+		if !strings.EqualFold(strings.TrimSpace(r.FormValue("confirm")), address) {
+			m.serveAccountData(w, r, "Type your email to confirm")
+			return
+		}
+		removed, err := m.DB.RequestAccountDeletion(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not request account deletion", "error", err)
+			m.serveAccountData(w, r, "Failed to delete account")
+			return
+		}
+		for _, sessionID := range removed {
+			err = auth.Revoke(r.Context(), m.Redis, sessionID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "could not revoke session", "error", err)
+			}
+		}
+		m.audit(r, db.AuditEvent{Action: db.AUDIT_ACCOUNT_DELETE})
+		m.killUserCrawlers(userID)
+		deleteAt := time.Now().Add(ACCOUNT_DELETION_GRACE_PERIOD).UTC().Format("2006-01-02 15:04 MST")
+		m.notifyAccountEvent(r.Context(), userID, "Account scheduled for deletion", fmt.Sprintf(
+			"Your account and everything in it will be deleted after %s. Log in before then to keep it.", deleteAt))
+		clearCookies(w)
+		w.Header().Set("HX-Refresh", "true")
+	}
+}
+
+// AccountDeleter deletes the accounts whose grace period has passed.
+func (m *CrawlMaster) AccountDeleter() {
+	ctx := context.Background()
+	for {
+		users, err := m.DB.GetAccountsDueForDeletion(ctx, time.Now().Add(-ACCOUNT_DELETION_GRACE_PERIOD))
+		if err != nil {
+			slog.Error("could not get accounts due for deletion", "error", err)
+		}
+		for _, userID := range users {
+			workspaces, err := m.DB.DeleteAccount(ctx, userID)
+			if err != nil {
+				slog.Error("could not delete account", "user_id", userID, "error", err)
+				continue
+			}
+			for _, workspaceID := range workspaces {
+				m.killWorkspaceCrawlers(workspaceID)
+				go m.purgeWorkspaceWhenStopped(workspaceID)
+			}
+		}
+		time.Sleep(ACCOUNT_DELETION_INTERVAL)
+	}
+}
+
+// Purge a workspace once its killed crawlers have stopped
+func (m *CrawlMaster) purgeWorkspaceWhenStopped(workspaceID string) {
+	var err error
+	for attempt := 0; attempt < 30; attempt++ {
+		if err = m.purgeWorkspace(workspaceID); err == nil {
+			return
+		}
+		time.Sleep(time.Second)
+	}
+	slog.Error("could not purge workspace", "workspace_id", workspaceID, "error", err)
+}
+
+func (m *CrawlMaster) accountExport(ctx context.Context, userID string) (accountExport, error) {
+	export := accountExport{ExportedAt: time.Now().UTC()}
+	var err error
+	if export.Account, err = m.DB.GetUserSummary(ctx, userID); err != nil {
+		return export, err
+	}
+	if export.Workspaces, err = m.DB.GetWorkspaces(ctx, userID); err != nil {
+		return export, err
+	}
+	if export.Sessions, err = m.DB.GetSessions(ctx, userID); err != nil {
+		return export, err
+	}
+	if export.APIKeys, err = m.DB.GetAPIKeys(ctx, userID); err != nil {
+		return export, err
+	}
+	hooks, err := m.DB.GetWebhooks(ctx, userID)
+	if err != nil {
+		return export, err
+	}
+	for _, hook := range hooks {
+		export.Webhooks = append(export.Webhooks, webhookExport{ID: hook.ID, URL: hook.URL, CreatedAt: hook.CreatedAt})
+	}
+	if export.NotificationPreferences, err = m.DB.GetNotificationPreferences(ctx, userID); err != nil {
+		return export, err
+	}
+	totp, err := m.DB.GetTOTP(ctx, userID)
+	if err != nil {
+		return export, err
+	}
+	export.TwoFactor.Enabled = totp.Enabled
+	if export.TwoFactor.RecoveryCodesLeft, err = m.DB.CountRecoveryCodes(ctx, userID); err != nil {
+		return export, err
+	}
+	if export.Identities, err = m.DB.GetUserIdentities(ctx, userID); err != nil {
+		return export, err
+	}
+	if export.WorkspaceInvites, err = m.DB.GetUserWorkspaceInvites(ctx, userID); err != nil {
+		return export, err
+	}
+	for _, workspace := range export.Workspaces {
+		if workspace.Role != auth.RoleOwner {
+			continue
+		}
+		invites, err := m.DB.GetWorkspaceInvites(ctx, workspace.ID)
+		if err != nil {
+			return export, err
+		}
+		export.TeamWorkspaceInvites = append(export.TeamWorkspaceInvites, invites...)
+	}
+	if export.LoginAttempts, err = m.DB.GetUserLoginAttempts(ctx, userID); err != nil {
+		return export, err
+	}
+	if export.AuditLog, err = m.DB.GetUserAuditEvents(ctx, userID); err != nil {
+		return export, err
+	}
+	if export.CrawlJobs, err = m.DB.GetUserCrawlJobs(ctx, userID); err != nil {
+		return export, err
+	}
+	return export, nil
+}
+
+func (m *CrawlMaster) serveAccountData(w http.ResponseWriter, r *http.Request, message string) {
+	tmpl, err := parseTemplate(r.Context(), "internal/html/templates/account_data.gohtml")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not parse template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = executeTemplate(r.Context(), tmpl, w, struct {
+		GracePeriodDays int
+		Error           string
+	}{
+		GracePeriodDays: int(ACCOUNT_DELETION_GRACE_PERIOD / (24 * time.Hour)),
+		Error:           message,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not render template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func addFileToArchive(archive *zip.Writer, name string, path string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, source)
+	return err
+}
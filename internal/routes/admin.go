@@ -0,0 +1,413 @@
+package routes
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/Ztkent/data-manager/internal/auth"
+	"github.com/Ztkent/data-manager/internal/db"
+	"github.com/Ztkent/data-manager/internal/webhook"
+)
+
+var serverStartedAt = time.Now()
+
+type adminUser struct {
+	db.UserSummary
+	Storage  string // Size of the files in the user's personal workspace
+	Crawlers int    // Active crawlers in the user's personal workspace
+}
+
+type adminUsersView struct {
+	Users   []adminUser
+	Roles   []string
+	AdminID string
+	Message string
+	Error   string
+}
+
+type adminCrawler struct {
+	WorkspaceID string
+	UserID      string // The user who started it
+	URL         string
+}
+
+type adminHealthView struct {
+	Uptime     string
+	GoVersion  string
+	Goroutines int
+	HeapMB     uint64
+	Postgres   string
+	Redis      string
+	Workspaces int
+	Crawlers   int
+	Storage    string
+}
+
+// AdminModal opens the admin console, its sections load themselves.
+func (m *CrawlMaster) AdminModal() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("close") == "true" {
+			return
+		}
+		tmpl, err := parseTemplate(r.Context(), "internal/html/templates/admin_modal.gohtml")
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not parse template", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = executeTemplate(r.Context(), tmpl, w, nil)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not render template", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+func (m *CrawlMaster) AdminHealthHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		view := adminHealthView{
+			Uptime:     time.Since(serverStartedAt).Round(time.Second).String(),
+			GoVersion:  runtime.Version(),
+			Goroutines: runtime.NumGoroutine(),
+			HeapMB:     mem.HeapAlloc >> 20,
+			Postgres:   "ok",
+			Redis:      "ok",
+		}
+		if err := m.DB.Ping(r.Context()); err != nil {
+			view.Postgres = err.Error()
+		}
+		if m.Redis == nil {
+			view.Redis = "not configured"
+		} else if err := m.Redis.Ping(r.Context()).Err(); err != nil {
+			view.Redis = err.Error()
+		}
+		m.RLock()
+		view.Workspaces = len(m.ActiveManagers)
+		m.RUnlock()
+		view.Crawlers = len(m.activeCrawlers())
+		var storage int64
+		for _, dir := range []string{"user/data-crawler", "user/network", "user/config"} {
+			filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					storage += info.Size()
+				}
+				return nil
+			})
+		}
+		view.Storage = formatStorage(storage)
+
+		tmpl, err := parseTemplate(r.Context(), "internal/html/templates/admin_health.gohtml")
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not parse template", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = executeTemplate(r.Context(), tmpl, w, view)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not render template", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+func (m *CrawlMaster) AdminUsersHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.serveAdminUsers(w, r, adminUsersView{})
+	}
+}
+
+// DisableUserHandler stops a user from logging in, and ends their sessions and crawls.
+func (m *CrawlMaster) DisableUserHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.FormValue("user_id")
+		if userID == "" || userID == requestUser(r).ID {
+			m.serveAdminUsers(w, r, adminUsersView{Error: "Can't disable your own account"})
+			return
+		}
+		removed, err := m.DB.DisableUser(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not disable user", "error", err)
+			m.serveAdminUsers(w, r, adminUsersView{Error: "Failed to disable user"})
+			return
+		}
+		for _, sessionID := range removed {
+			err = auth.Revoke(r.Context(), m.Redis, sessionID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "could not revoke session", "error", err)
+			}
+		}
+		m.killUserCrawlers(userID)
+		m.audit(r, db.AuditEvent{Action: db.AUDIT_ADMIN_DISABLE, Target: userID})
+		m.serveAdminUsers(w, r, adminUsersView{Message: "User disabled"})
+	}
+}
+
+func (m *CrawlMaster) EnableUserHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.FormValue("user_id")
+		err := m.DB.EnableUser(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not enable user", "error", err)
+			m.serveAdminUsers(w, r, adminUsersView{Error: "Failed to enable user"})
+			return
+		}
+		m.audit(r, db.AuditEvent{Action: db.AUDIT_ADMIN_ENABLE, Target: userID})
+		m.serveAdminUsers(w, r, adminUsersView{Message: "User enabled"})
+	}
+}
+
+// PurgeUserDataHandler deletes the results, network graph and config of a user's personal workspace.
+func (m *CrawlMaster) PurgeUserDataHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.FormValue("user_id")
+		if userID == "" {
+			m.serveAdminUsers(w, r, adminUsersView{Error: "Invalid user"})
+			return
+		}
+		err := m.purgeWorkspace(userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not purge workspace", "error", err)
+			m.serveAdminUsers(w, r, adminUsersView{Error: err.Error()})
+			return
+		}
+		m.audit(r, db.AuditEvent{Action: db.AUDIT_ADMIN_PURGE, WorkspaceID: userID, Target: userID})
+		m.serveAdminUsers(w, r, adminUsersView{Message: "User data purged"})
+	}
+}
+
+func (m *CrawlMaster) AdminCrawlersHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.serveAdminCrawlers(w, r, m.activeCrawlers())
+	}
+}
+
+// AdminKillCrawlerHandler kills a crawler in any workspace.
+func (m *CrawlMaster) AdminKillCrawlerHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspaceID := r.FormValue("workspace_id")
+		url := r.FormValue("url")
+		m.RLock()
+		manager := m.ActiveManagers[workspaceID]
+		m.RUnlock()
+		if manager != nil {
+			manager.RLock()
+			cancel, ok := manager.CrawlMap[url]
+			manager.RUnlock()
+			if ok {
+				cancel()
+				m.audit(r, db.AuditEvent{Action: db.AUDIT_ADMIN_CRAWL_KILL, WorkspaceID: workspaceID, Target: url})
+			}
+		}
+		m.serveAdminCrawlers(w, r, m.activeCrawlers())
+	}
+}
+
+func (m *CrawlMaster) AdminJobsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobs, err := m.DB.GetRecentCrawlJobs(r.Context())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not get recent crawl jobs", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tmpl, err := parseTemplate(r.Context(), "internal/html/templates/admin_jobs.gohtml")
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not parse template", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = executeTemplate(r.Context(), tmpl, w, jobs)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not render template", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+func (m *CrawlMaster) serveAdminUsers(w http.ResponseWriter, r *http.Request, view adminUsersView) {
+	users, err := m.DB.GetUsers(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not get users", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	crawlers := make(map[string]int)
+	for _, crawler := range m.activeCrawlers() {
+		crawlers[crawler.WorkspaceID]++
+	}
+	for _, user := range users {
+		view.Users = append(view.Users, adminUser{
+			UserSummary: user,
+			Storage:     formatStorage(workspaceStorage(user.ID)),
+			Crawlers:    crawlers[user.ID],
+		})
+	}
+	view.Roles = auth.Roles
+	view.AdminID = requestUser(r).ID
+
+	tmpl, err := parseTemplate(r.Context(), "internal/html/templates/admin_users.gohtml")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not parse template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = executeTemplate(r.Context(), tmpl, w, view)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not render template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (m *CrawlMaster) serveAdminCrawlers(w http.ResponseWriter, r *http.Request, crawlers []adminCrawler) {
+	tmpl, err := parseTemplate(r.Context(), "internal/html/templates/admin_crawlers.gohtml")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not parse template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = executeTemplate(r.Context(), tmpl, w, crawlers)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not render template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Record the crawl in the job history shown in the admin console
+func (m *CrawlMaster) recordCrawlJob(manager *CrawlManager, event string, job *CrawlJob) {
+	ctx := job.logContext()
+	var err error
+	switch event {
+	case webhook.CrawlStarted:
+		err = m.DB.StartCrawlJob(ctx, db.CrawlJob{
+			ID:          job.ID,
+			WorkspaceID: manager.WorkspaceID,
+			UserID:      job.UserID,
+			StartingURL: job.Config.StartingURL,
+			StartedAt:   job.StartedAt,
+		})
+	default:
+		status := db.JOB_FINISHED
+		if event == webhook.CrawlFailed {
+			status = db.JOB_FAILED
+		} else if event == webhook.CrawlKilled {
+			status = db.JOB_KILLED
+		}
+		jobErr := ""
+		if job.Err != nil {
+			jobErr = job.Err.Error()
+		}
+		err = m.DB.FinishCrawlJob(ctx, job.ID, status, jobErr, job.FinishedAt)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "could not record crawl job", "error", err)
+	}
+}
+
+// Every running crawler, across all workspaces
+func (m *CrawlMaster) activeCrawlers() []adminCrawler {
+	m.RLock()
+	defer m.RUnlock()
+	var crawlers []adminCrawler
+	for _, manager := range m.ActiveManagers {
+		manager.RLock()
+		for url := range manager.CrawlMap {
+			crawlers = append(crawlers, adminCrawler{WorkspaceID: manager.WorkspaceID, UserID: manager.CrawlUsers[url], URL: url})
+		}
+		manager.RUnlock()
+	}
+	sort.Slice(crawlers, func(i, j int) bool {
+		if crawlers[i].WorkspaceID == crawlers[j].WorkspaceID {
+			return crawlers[i].URL < crawlers[j].URL
+		}
+		return crawlers[i].WorkspaceID < crawlers[j].WorkspaceID
+	})
+	return crawlers
+}
+
+func (m *CrawlMaster) killWorkspaceCrawlers(workspaceID string) {
+	m.RLock()
+	manager := m.ActiveManagers[workspaceID]
+	m.RUnlock()
+	if manager == nil {
+		return
+	}
+	manager.RLock()
+	defer manager.RUnlock()
+	for _, cancel := range manager.CrawlMap {
+		cancel()
+	}
+}
+
+// Kill the crawlers the user started, in every workspace they belong to
+func (m *CrawlMaster) killUserCrawlers(userID string) {
+	m.RLock()
+	defer m.RUnlock()
+	for _, manager := range m.ActiveManagers {
+		manager.RLock()
+		for url, cancel := range manager.CrawlMap {
+			if manager.CrawlUsers[url] == userID {
+				cancel()
+			}
+		}
+		manager.RUnlock()
+	}
+}
+
+// Delete a workspace's files, and drop its crawl manager so the results DB is reopened empty.
+// Running crawlers still write to the files, so they have to be killed first.
+func (m *CrawlMaster) purgeWorkspace(workspaceID string) error {
+	m.Lock()
+	manager := m.ActiveManagers[workspaceID]
+	if manager != nil {
+		manager.RLock()
+		active := len(manager.CrawlMap)
+		manager.RUnlock()
+		if active > 0 {
+			m.Unlock()
+			return fmt.Errorf("Kill the user's crawlers before purging their data")
+		}
+		delete(m.ActiveManagers, workspaceID)
+	}
+	m.Unlock()
+
+	if manager != nil {
+		err := manager.SqliteDB.Close()
+		if err != nil {
+			slog.Error("could not close results db", "workspace_id", workspaceID, "error", err)
+		}
+	}
+	for _, path := range workspaceFiles(workspaceID) {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Failed to delete %s", filepath.Base(path))
+		}
+	}
+	return nil
+}
+
+func workspaceFiles(workspaceID string) []string {
+	manager := &CrawlManager{WorkspaceID: workspaceID}
+	return []string{manager.GetDBPath(), manager.GetNetworkPath(), manager.GetConfigPath()}
+}
+
+func workspaceStorage(workspaceID string) int64 {
+	var size int64
+	for _, path := range workspaceFiles(workspaceID) {
+		if info, err := os.Stat(path); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+func formatStorage(bytes int64) string {
+	return fmt.Sprintf("%.1f MB", float64(bytes)/(1<<20))
+}
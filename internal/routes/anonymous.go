@@ -0,0 +1,54 @@
+package routes
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// How long results left under an anonymous visitor's id are kept, so they can still be claimed when the visitor logs in
+const ANONYMOUS_RESULTS_TTL = 72 * time.Hour
+
+// Move the results collected under an anonymous visitor's id into the personal workspace of the account they logged in to.
+// If the account already has results they're merged, keeping the account's rows where both have the same page.
+// Ids that belong to an account or workspace are never claimed, they show up in the UI and could be copied into a cookie.
+func (m *CrawlMaster) claimAnonymousData(ctx context.Context, anonymousID string, userID string) {
+	anonymousFiles := workspaceFiles(anonymousID)
+	if _, err := os.Stat(anonymousFiles[0]); err != nil {
+		return
+	}
+	inUse, err := m.DB.IsIDInUse(ctx, anonymousID)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not check id in use", "error", err)
+		return
+	} else if inUse {
+		return
+	}
+
+	manager := m.GetCrawlManager(userID)
+	err = manager.SqliteDB.MergeResults(ctx, anonymousFiles[0])
+	if err != nil {
+		slog.ErrorContext(ctx, "could not claim anonymous results", "anonymous_id", anonymousID, "error", err)
+		return
+	}
+	// Merged rows keep their old timestamps, so everything is reindexed
+	err = manager.SqliteDB.IndexContent(ctx, time.Time{})
+	if err != nil {
+		slog.ErrorContext(ctx, "could not index content", "error", err)
+	}
+	// The network graph and config are only kept if the account doesn't have its own
+	accountFiles := workspaceFiles(userID)
+	for i := 1; i < len(anonymousFiles); i++ {
+		if _, err := os.Stat(accountFiles[i]); os.IsNotExist(err) {
+			err = os.Rename(anonymousFiles[i], accountFiles[i])
+			if err != nil && !os.IsNotExist(err) {
+				slog.ErrorContext(ctx, "could not move file", "error", err)
+			}
+		}
+	}
+	err = m.purgeWorkspace(anonymousID)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not purge workspace", "error", err)
+	}
+}
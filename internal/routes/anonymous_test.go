@@ -0,0 +1,135 @@
+package routes
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/Ztkent/data-manager/internal/db"
+)
+
+type anonymousDB struct {
+	db.MasterDatabase
+	inUse map[string]bool
+}
+
+func (f *anonymousDB) IsIDInUse(ctx context.Context, id string) (bool, error) {
+	return f.inUse[id], nil
+}
+
+// Runs the test from a temp dir with the folders the workspace files are kept in
+func useWorkspaceDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	for _, dir := range []string{"user/data-crawler", "user/network", "user/config"} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Creates a results DB for the workspace with a visited page for each url
+func writeResults(t *testing.T, workspaceID string, urls ...string) {
+	t.Helper()
+	sqliteDB := db.ConnectSqlite(workspaceFiles(workspaceID)[0])
+	if sqliteDB == nil {
+		t.Fatal("could not connect to sqlite")
+	}
+	defer sqliteDB.Close()
+	if _, err := sqliteDB.Exec(`CREATE TABLE visited (id INTEGER PRIMARY KEY, url TEXT UNIQUE, referrer TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range urls {
+		if _, err := sqliteDB.Exec(`INSERT INTO visited (url, referrer) VALUES ($1, $2)`, url, workspaceID); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestClaimAnonymousData(t *testing.T) {
+	tests := []struct {
+		name        string
+		inUse       bool     // The anonymous id belongs to an account or workspace
+		accountURLs []string // Pages the account already has
+		wantURLs    map[string]string
+		wantClaimed bool
+	}{
+		{
+			name:        "account without results",
+			wantURLs:    map[string]string{"https://a.com": "anonymous", "https://b.com": "anonymous"},
+			wantClaimed: true,
+		},
+		{
+			name:        "account with results keeps its rows",
+			accountURLs: []string{"https://a.com"},
+			wantURLs:    map[string]string{"https://a.com": "account", "https://b.com": "anonymous"},
+			wantClaimed: true,
+		},
+		{
+			name:        "id in use is not claimed",
+			inUse:       true,
+			accountURLs: []string{"https://a.com"},
+			wantURLs:    map[string]string{"https://a.com": "account"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useWorkspaceDir(t)
+			writeResults(t, "anonymous", "https://a.com", "https://b.com")
+			if err := os.WriteFile(workspaceFiles("anonymous")[1], []byte("network"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.accountURLs != nil {
+				writeResults(t, "account", tt.accountURLs...)
+			}
+			m := &CrawlMaster{
+				DB:             &anonymousDB{inUse: map[string]bool{"anonymous": tt.inUse}},
+				ActiveManagers: make(map[string]*CrawlManager),
+			}
+
+			m.claimAnonymousData(context.Background(), "anonymous", "account")
+			if manager := m.ActiveManagers["account"]; manager != nil {
+				manager.SqliteDB.Close()
+			}
+
+			sqliteDB := db.ConnectSqlite(workspaceFiles("account")[0])
+			defer sqliteDB.Close()
+			got := make(map[string]string)
+			rows, err := sqliteDB.Query(`SELECT url, referrer FROM visited`)
+			if err == nil {
+				for rows.Next() {
+					var url, referrer string
+					if err := rows.Scan(&url, &referrer); err != nil {
+						t.Fatal(err)
+					}
+					got[url] = referrer
+				}
+				rows.Close()
+			}
+			for url, want := range tt.wantURLs {
+				if got[url] != want {
+					t.Errorf("%s came from %q, want %q", url, got[url], want)
+				}
+			}
+			if len(got) != len(tt.wantURLs) {
+				t.Errorf("account has %d pages, want %d", len(got), len(tt.wantURLs))
+			}
+
+			_, err = os.Stat(workspaceFiles("anonymous")[0])
+			if claimed := os.IsNotExist(err); claimed != tt.wantClaimed {
+				t.Errorf("anonymous results removed = %v, want %v", claimed, tt.wantClaimed)
+			}
+			_, err = os.Stat(workspaceFiles("account")[1])
+			if moved := err == nil; moved != tt.wantClaimed {
+				t.Errorf("network graph moved = %v, want %v", moved, tt.wantClaimed)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package routes
+
+import (
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Ztkent/data-manager/internal/auth"
+	"github.com/Ztkent/data-manager/internal/db"
+)
+
+// The scope an API key needs for each route it may call. Routes not listed here only accept cookie sessions.
+var apiKeyRouteScopes = map[string]string{
+	"GET /active-crawlers":    auth.ScopeRead,
+	"GET /recent-urls":        auth.ScopeRead,
+	"POST /file-collection":   auth.ScopeRead,
+	"GET /search":             auth.ScopeRead,
+	"GET /crawl-history":      auth.ScopeRead,
+	"GET /crawl-diff":         auth.ScopeRead,
+	"GET /network":            auth.ScopeRead,
+	"POST /crawl":             auth.ScopeCrawl,
+	"POST /crawl-random":      auth.ScopeCrawl,
+	"POST /kill-crawler":      auth.ScopeCrawl,
+	"POST /kill-all-crawlers": auth.ScopeCrawl,
+	"GET /export":             auth.ScopeExport,
+	"GET /download":           auth.ScopeExport,
+}
+
+// Check the request's bearer API key, and that it may call this route
+func (m *CrawlMaster) checkAPIKey(r *http.Request) (*db.APIKey, int, error) {
+	key, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || key == "" {
+		return nil, http.StatusUnauthorized, fmt.Errorf("Invalid authorization header")
+	}
+	apiKey, err := m.DB.AuthenticateAPIKey(r.Context(), auth.HashAPIKey(key))
+	if err != nil {
+		return nil, http.StatusUnauthorized, fmt.Errorf("Invalid API key")
+	}
+	scope, ok := apiKeyRouteScopes[r.Method+" "+r.URL.Path]
+	if !ok || !auth.HasScope(apiKey.Scopes, scope) {
+		return nil, http.StatusForbidden, fmt.Errorf("API key is not allowed to access this route")
+	}
+	return &apiKey, http.StatusOK, nil
+}
+
+func (m *CrawlMaster) APIKeysHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.serveAPIKeys(w, r, "", "")
+	}
+}
+
+func (m *CrawlMaster) CreateAPIKeyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := requestUser(r).ID
+
+		r.ParseForm()
+		name := strings.TrimSpace(r.FormValue("name"))
+		scopes := auth.ValidScopes(r.Form["scope"])
+		if name == "" || len(name) > 255 {
+			m.serveAPIKeys(w, r, "", "Name your key so you can recognize it later")
+			return
+		} else if len(scopes) == 0 {
+			m.serveAPIKeys(w, r, "", "Choose at least one scope")
+			return
+		}
+		key, prefix, keyHash, err := auth.GenerateAPIKey()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not generate api key", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = m.DB.CreateAPIKey(r.Context(), userID, name, prefix, keyHash, scopes)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not create api key", "error", err)
+			m.serveAPIKeys(w, r, "", "Failed to create API key")
+			return
+		}
+		m.serveAPIKeys(w, r, key, "")
+	}
+}
+
+func (m *CrawlMaster) RevokeAPIKeyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := requestUser(r).ID
+
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			m.serveAPIKeys(w, r, "", "Invalid API key")
+			return
+		}
+		err = m.DB.RevokeAPIKey(r.Context(), userID, id)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not revoke api key", "error", err)
+			m.serveAPIKeys(w, r, "", "Failed to revoke API key")
+			return
+		}
+		m.serveAPIKeys(w, r, "", "")
+	}
+}
+
+func (m *CrawlMaster) serveAPIKeys(w http.ResponseWriter, r *http.Request, newKey string, message string) {
+	userID := requestUser(r).ID
+	keys, err := m.DB.GetAPIKeys(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not get api keys", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := template.New("api_keys.gohtml").Funcs(template.FuncMap{
+		"join": strings.Join,
+	}).ParseFiles("internal/html/templates/api_keys.gohtml")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not parse template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = executeTemplate(r.Context(), tmpl, w, struct {
+		Keys   []db.APIKey
+		Scopes []string
+		NewKey string
+		Error  string
+	}{
+		Keys:   keys,
+		Scopes: auth.Scopes,
+		NewKey: newKey,
+		Error:  message,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not render template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Ztkent/data-manager/internal/db"
+)
+
+// Append an event to the audit log, with the request's user, workspace, IP and user agent.
+// Events for requests without an authenticated user must set their ActorID.
+func (m *CrawlMaster) audit(r *http.Request, event db.AuditEvent) {
+	if user := requestUser(r); user != nil {
+		if event.ActorID == "" {
+			event.ActorID = user.ID
+		}
+		if event.WorkspaceID == "" {
+			event.WorkspaceID = user.WorkspaceID
+		}
+	}
+	event.IPAddress = clientIP(r)
+	event.UserAgent = r.UserAgent()
+	err := m.DB.RecordAuditEvent(r.Context(), event)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not record audit event", "error", err)
+	}
+}
+
+// AuditLogHandler lets admins query the audit log by user and time.
+// The user may be an id or an email, from and to are dates or RFC 3339 times. Add json=true for JSON.
+func (m *CrawlMaster) AuditLogHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		var filter db.AuditFilter
+		user := strings.TrimSpace(query.Get("user"))
+		filter.ActorID = user
+		if strings.Contains(user, "@") {
+			userID, err := m.DB.GetUserIDByEmail(r.Context(), user)
+			if err != nil {
+				// Deleted accounts keep their events, but their emails can't be looked up anymore
+				userID = "unknown"
+			}
+			filter.ActorID = userID
+		}
+		filter.Since = parseAuditTime(query.Get("from"), false)
+		filter.Until = parseAuditTime(query.Get("to"), true)
+
+		events, err := m.DB.GetAuditEvents(r.Context(), filter)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not get audit events", "error", err)
+			http.Error(w, "Failed to query audit log", http.StatusInternalServerError)
+			return
+		}
+		if query.Get("json") == "true" {
+			w.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(events)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "could not encode audit events", "error", err)
+			}
+			return
+		}
+
+		tmpl, err := parseTemplate(r.Context(), "internal/html/templates/admin_audit.gohtml")
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not parse template", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = executeTemplate(r.Context(), tmpl, w, struct {
+			Events []db.AuditEvent
+			User   string
+			From   string
+			To     string
+		}{
+			Events: events,
+			User:   user,
+			From:   query.Get("from"),
+			To:     query.Get("to"),
+		})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not render template", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// Parse an audit query bound. A date as the upper bound includes the whole day.
+func parseAuditTime(value string, upper bool) time.Time {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		if upper {
+			return t.Add(24 * time.Hour)
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,119 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Ztkent/data-manager/internal/db"
+)
+
+// Limits on failed logins. They're tracked by the email that was tried, whether or not it belongs to a user,
+// so the responses don't reveal which emails have accounts.
+const (
+	LOGIN_FAILURES_BEFORE_LOCKOUT = 5               // Failed logins for an email before it's locked out
+	LOGIN_FAILURE_WINDOW          = 24 * time.Hour  // How long failed logins for an email are remembered
+	LOGIN_LOCKOUT_BASE            = 1 * time.Minute // First lockout, each further failure doubles it
+	LOGIN_LOCKOUT_MAX             = 24 * time.Hour  // Longest lockout
+	SUSPICIOUS_IP_EMAILS          = 10              // Emails with failed logins from one IP before we raise an alert
+	SUSPICIOUS_IP_WINDOW          = 1 * time.Hour   // Window for counting failed emails from one IP
+)
+
+func loginKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// How much longer logins for this email are locked out, if they are
+func (m *CrawlMaster) loginLockout(ctx context.Context, email string) time.Duration {
+	if m.Redis == nil {
+		return 0
+	}
+	ttl, err := m.Redis.PTTL(ctx, "login_lockout:"+loginKey(email)).Result()
+	if err != nil {
+		slog.ErrorContext(ctx, "could not check login lockout", "error", err)
+		return 0
+	}
+	return ttl
+}
+
+// Record a failed login, locking out the email once it has failed too many times.
+// The account owner is emailed when they're locked out.
+func (m *CrawlMaster) loginFailed(r *http.Request, email string, reason string) {
+	m.recordLoginAttempt(r, email, "", false, reason)
+	if m.Redis == nil {
+		return
+	}
+	ctx := r.Context()
+	key := loginKey(email)
+
+	failures, err := m.Redis.Incr(ctx, "login_failures:"+key).Result()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not count failed login", "error", err)
+		return
+	}
+	if failures == 1 {
+		m.Redis.Expire(ctx, "login_failures:"+key, LOGIN_FAILURE_WINDOW)
+	}
+	if failures >= LOGIN_FAILURES_BEFORE_LOCKOUT {
+		lockout := LOGIN_LOCKOUT_MAX
+		if shift := failures - LOGIN_FAILURES_BEFORE_LOCKOUT; shift < 16 && LOGIN_LOCKOUT_BASE<<shift < LOGIN_LOCKOUT_MAX {
+			lockout = LOGIN_LOCKOUT_BASE << shift
+		}
+		err = m.Redis.Set(ctx, "login_lockout:"+key, 1, lockout).Err()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not lock out login", "error", err)
+		}
+	}
+	// Email the owner when the first lockout starts, not for every failure after it
+	if failures == LOGIN_FAILURES_BEFORE_LOCKOUT {
+		if userID, err := m.DB.GetUserIDByEmail(r.Context(), email); err == nil {
+			m.notifyAccountEvent(r.Context(), userID, "Account locked", fmt.Sprintf(
+				"Logins to your account are paused after %d failed attempts, most recently from %s. "+
+					"If this wasn't you, consider resetting your password and enabling two-factor authentication.", failures, clientIP(r)))
+		}
+	}
+
+	// One IP failing against many different emails looks like credential stuffing
+	ipKey := "login_failed_emails:" + clientIP(r)
+	m.Redis.SAdd(ctx, ipKey, key)
+	m.Redis.Expire(ctx, ipKey, SUSPICIOUS_IP_WINDOW)
+	emails, err := m.Redis.SCard(ctx, ipKey).Result()
+	if err == nil && emails >= SUSPICIOUS_IP_EMAILS {
+		first, err := m.Redis.SetNX(ctx, "login_alert:"+clientIP(r), 1, SUSPICIOUS_IP_WINDOW).Result()
+		if err == nil && first {
+			slog.WarnContext(ctx, "security alert: failed logins for many different emails", "emails", emails, "ip", clientIP(r), "window", SUSPICIOUS_IP_WINDOW.String())
+		}
+	}
+}
+
+// Record a correct password, and clear the email's failed logins.
+// If there were failures before it, the owner is told in case someone else guessed it.
+func (m *CrawlMaster) loginSucceeded(r *http.Request, email string, userID string, reason string) {
+	m.recordLoginAttempt(r, email, userID, true, reason)
+	if m.Redis == nil {
+		return
+	}
+	failures, err := m.Redis.GetDel(r.Context(), "login_failures:"+loginKey(email)).Int64()
+	if err == nil && failures > 0 {
+		m.notifyAccountEvent(r.Context(), userID, "Sign-in after failed attempts", fmt.Sprintf(
+			"Your password was entered correctly from %s after %d failed attempts. "+
+				"If this wasn't you, reset your password and sign out your other sessions.", clientIP(r), failures))
+	}
+}
+
+func (m *CrawlMaster) recordLoginAttempt(r *http.Request, email string, userID string, success bool, reason string) {
+	err := m.DB.RecordLoginAttempt(r.Context(), db.LoginAttempt{
+		Email:     loginKey(email),
+		UserID:    userID,
+		IPAddress: clientIP(r),
+		UserAgent: r.UserAgent(),
+		Success:   success,
+		Reason:    reason,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not record login attempt", "error", err)
+	}
+}
@@ -0,0 +1,48 @@
+package routes
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsHandler serves Prometheus metrics. If METRICS_TOKEN is set, scrapers must send it as a bearer token.
+// On the public listener the token is required, without one the metrics aren't served there at all.
+func (m *CrawlMaster) MetricsHandler(public bool) http.HandlerFunc {
+	token := os.Getenv("METRICS_TOKEN")
+	handler := promhttp.Handler()
+	return func(w http.ResponseWriter, r *http.Request) {
+		if public && token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "Invalid metrics token", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}
+}
+
+// CrawlerCounts returns the number of active crawlers started by each user that has any, across every workspace.
+func (m *CrawlMaster) CrawlerCounts() map[string]int {
+	counts := make(map[string]int)
+	for _, crawler := range m.activeCrawlers() {
+		counts[crawler.UserID]++
+	}
+	return counts
+}
+
+// SQLiteStats returns the connection pool stats of each open results DB.
+func (m *CrawlMaster) SQLiteStats() []sql.DBStats {
+	m.RLock()
+	defer m.RUnlock()
+	stats := make([]sql.DBStats, 0, len(m.ActiveManagers))
+	for _, manager := range m.ActiveManagers {
+		stats = append(stats, manager.SqliteDB.Stats())
+	}
+	return stats
+}
@@ -0,0 +1,222 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/Ztkent/data-manager/internal/auth"
+	"github.com/Ztkent/data-manager/internal/db"
+)
+
+type contextKey string
+
+const (
+	userContextKey         contextKey = "user"
+	crawlManagerContextKey contextKey = "crawl_manager"
+)
+
+const WORKSPACE_HEADER = "X-Workspace-ID" // Header API clients choose a team workspace with
+
+// The authenticated user making a request
+type User struct {
+	ID            string
+	Role          string     // The user's system role, see auth.Roles
+	SessionID     string     // Set when the user logged in with a session cookie
+	APIKey        *db.APIKey // Set when the user sent an API key
+	WorkspaceID   string     // The workspace the request acts on, the user's id for their personal workspace
+	WorkspaceRole string
+}
+
+// Authenticate rejects requests without a valid session or API key with a 401.
+// Otherwise it attaches the user and their CrawlManager to the request context.
+func (m *CrawlMaster) Authenticate(next http.Handler) http.Handler {
+	return m.authenticate(next, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "User is not logged in", http.StatusUnauthorized)
+	})
+}
+
+// AuthenticateWithToast is Authenticate for routes triggered by buttons, it tells the user why nothing happened.
+func (m *CrawlMaster) AuthenticateWithToast(next http.Handler) http.Handler {
+	return m.authenticate(next, func(w http.ResponseWriter, r *http.Request) {
+		serveFailToast(w, r, "User is not logged in")
+	})
+}
+
+func (m *CrawlMaster) authenticate(next http.Handler, unauthorized http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var user *User
+		if r.Header.Get("Authorization") != "" {
+			apiKey, status, err := m.checkAPIKey(r)
+			if err != nil {
+				http.Error(w, err.Error(), status)
+				return
+			}
+			user = &User{ID: apiKey.UserID, APIKey: apiKey}
+		} else {
+			claims, err := m.checkSession(w, r)
+			if err != nil {
+				unauthorized(w, r)
+				return
+			}
+			user = &User{ID: claims.Subject, SessionID: claims.Id}
+		}
+		role, disabled, err := m.DB.GetUserAccess(r.Context(), user.ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not get user access", "error", err)
+			http.Error(w, "Failed to load user", http.StatusInternalServerError)
+			return
+		} else if disabled {
+			http.Error(w, "Account is disabled", http.StatusForbidden)
+			return
+		}
+		user.Role = role
+		err = m.resolveWorkspace(r, user)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		ctx = context.WithValue(ctx, crawlManagerContextKey, m.GetCrawlManager(user.WorkspaceID))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Set the workspace the request acts on. API clients choose one with a header, browsers with the workspace cookie.
+// Without either, or if the user has left the cookie's workspace, it's their personal workspace.
+func (m *CrawlMaster) resolveWorkspace(r *http.Request, user *User) error {
+	workspaceID := r.Header.Get(WORKSPACE_HEADER)
+	fromHeader := workspaceID != ""
+	if !fromHeader {
+		workspaceID, _ = getRequestCookie(r, "workspace")
+	}
+	user.WorkspaceID, user.WorkspaceRole = user.ID, auth.RoleOwner
+	if workspaceID == "" || workspaceID == user.ID {
+		return nil
+	}
+
+	role, err := m.DB.GetWorkspaceRole(r.Context(), workspaceID, user.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not get workspace role", "error", err)
+		return fmt.Errorf("Failed to load workspace")
+	} else if role == "" {
+		if fromHeader {
+			return fmt.Errorf("Not a member of this workspace")
+		}
+		return nil
+	}
+	user.WorkspaceID, user.WorkspaceRole = workspaceID, role
+	return nil
+}
+
+// RequireWorkspaceRole rejects requests from users without at least the given role in the active workspace.
+// It must run after Authenticate.
+func RequireWorkspaceRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := requestUser(r)
+			if !auth.WorkspaceRoleAllows(user.WorkspaceRole, role) {
+				if user.APIKey != nil {
+					http.Error(w, "Your role in this workspace doesn't allow that", http.StatusForbidden)
+				} else {
+					serveFailToast(w, r, "Your role in this workspace doesn't allow that")
+				}
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequirePermission rejects requests from users whose system role doesn't grant the permission.
+// It must run after Authenticate.
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := requestUser(r)
+			if !auth.RoleHasPermission(user.Role, permission) {
+				if user.APIKey != nil || r.Header.Get("HX-Request") == "" {
+					http.Error(w, "Your role doesn't allow that", http.StatusForbidden)
+				} else {
+					serveFailToast(w, r, "Your role doesn't allow that")
+				}
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Check the request's session cookies, refreshing the session token if it's getting old
+func (m *CrawlMaster) checkSession(w http.ResponseWriter, r *http.Request) (*auth.Claims, error) {
+	uuidToken, err := getRequestCookie(r, "uuid")
+	if err != nil {
+		return nil, fmt.Errorf("User is not logged in")
+	}
+	sessionToken, err := getRequestCookie(r, "session_token")
+	if err != nil {
+		return nil, fmt.Errorf("User is not logged in")
+	}
+	if uuidToken == "" || sessionToken == "" {
+		return nil, fmt.Errorf("User is not logged in")
+	}
+
+	// Verify the token was signed by us, hasn't expired, and belongs to this user
+	claims, err := auth.ParseToken(sessionToken)
+	if err != nil || claims.Subject != uuidToken {
+		return nil, fmt.Errorf("User is not logged in")
+	}
+	revoked, err := auth.IsRevoked(r.Context(), m.Redis, claims.Id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not check revoked sessions", "error", err)
+		return nil, err
+	} else if revoked {
+		return nil, fmt.Errorf("User is not logged in")
+	}
+	err = m.DB.ConfirmSession(r.Context(), uuidToken, claims.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if auth.NeedsRefresh(claims) {
+		m.refreshSession(w, r, claims)
+	}
+	return claims, nil
+}
+
+// VerifyCSRF rejects state-changing requests that don't carry the CSRF token for the browser's session.
+// Requests authenticated with an API key don't use cookies, so they can't be forged and are exempt.
+func (m *CrawlMaster) VerifyCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		csrfSession, err := getRequestCookie(r, "csrf_session")
+		if err != nil || !auth.ValidCSRFToken(csrfSession, r.Header.Get(auth.CSRF_HEADER)) {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The user attached by Authenticate
+func requestUser(r *http.Request) *User {
+	user, _ := r.Context().Value(userContextKey).(*User)
+	return user
+}
+
+// The CrawlManager attached by Authenticate
+func requestCrawlManager(r *http.Request) *CrawlManager {
+	crawlManager, _ := r.Context().Value(crawlManagerContextKey).(*CrawlManager)
+	return crawlManager
+}
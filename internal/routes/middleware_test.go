@@ -0,0 +1,64 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Ztkent/data-manager/internal/auth"
+)
+
+func TestVerifyCSRF(t *testing.T) {
+	t.Setenv("JWT_SECRET_TOKEN", "test-secret")
+	session, err := auth.NewCSRFSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := auth.NewCSRFSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		session string // csrf_session cookie, if any
+		token   string // CSRF header, if any
+		authz   string // Authorization header, if any
+		want    int
+	}{
+		{"valid token", http.MethodPost, session, auth.CSRFToken(session), "", http.StatusOK},
+		{"missing token", http.MethodPost, session, "", "", http.StatusForbidden},
+		{"missing session", http.MethodPost, "", auth.CSRFToken(session), "", http.StatusForbidden},
+		{"wrong token", http.MethodPost, session, "not-a-token", "", http.StatusForbidden},
+		{"token from another session", http.MethodPost, session, auth.CSRFToken(other), "", http.StatusForbidden},
+		{"delete without token", http.MethodDelete, session, "", "", http.StatusForbidden},
+		{"get is exempt", http.MethodGet, "", "", "", http.StatusOK},
+		{"head is exempt", http.MethodHead, "", "", "", http.StatusOK},
+		{"bearer is exempt", http.MethodPost, "", "", "Bearer dm_key", http.StatusOK},
+		{"basic auth is not exempt", http.MethodPost, "", "", "Basic dXNlcjpwYXNzd29yZA==", http.StatusForbidden},
+	}
+	m := &CrawlMaster{}
+	handler := m.VerifyCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/crawl", nil)
+			if tt.session != "" {
+				r.AddCookie(&http.Cookie{Name: "csrf_session", Value: tt.session})
+			}
+			if tt.token != "" {
+				r.Header.Set(auth.CSRF_HEADER, tt.token)
+			}
+			if tt.authz != "" {
+				r.Header.Set("Authorization", tt.authz)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
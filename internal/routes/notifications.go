@@ -0,0 +1,185 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Ztkent/data-manager/internal/email"
+	"github.com/Ztkent/data-manager/internal/webhook"
+)
+
+const RESULTS_DB_WARNING_SIZE = 256 << 20     // Results DB size, in bytes, that triggers a quota warning
+const QUOTA_WARNING_INTERVAL = 24 * time.Hour // Minimum time between repeated quota warnings of the same kind
+
+// HandleCrawlEvent notifies the webhooks of the user who started the crawl, and their inbox if they opted in,
+// about a crawl lifecycle event.
+func (m *CrawlMaster) HandleCrawlEvent(manager *CrawlManager, event string, job *CrawlJob) {
+	ctx := job.logContext()
+	m.recordCrawlJob(manager, event, job)
+	m.deliverWebhooks(job.UserID, event, job)
+	if event == webhook.CrawlStarted {
+		return
+	}
+
+	summary := email.CrawlSummary{
+		StartingURL: job.Config.StartingURL,
+		StartedAt:   job.StartedAt,
+		FinishedAt:  job.FinishedAt,
+	}
+	switch event {
+	case webhook.CrawlFinished:
+		summary.Status = "finished"
+	case webhook.CrawlFailed:
+		summary.Status = "failed"
+	case webhook.CrawlKilled:
+		summary.Status = "been stopped"
+	}
+	if job.Err != nil {
+		summary.Error = job.Err.Error()
+	}
+	runs, err := manager.SqliteDB.GetCrawlRuns(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not get crawl runs", "error", err)
+	}
+	for _, run := range runs {
+		if run.ID == job.ID {
+			summary.Pages = run.Pages
+			summary.Duplicates = run.Duplicates
+			break
+		}
+	}
+	m.notify(ctx, job.UserID, email.CrawlSummaries, func(to string) email.Message {
+		return email.CrawlSummaryMessage(to, summary)
+	})
+
+	// Warn the user once their results are getting large
+	if info, err := os.Stat(manager.GetDBPath()); err == nil && info.Size() >= RESULTS_DB_WARNING_SIZE {
+		m.notifyQuota(ctx, job.UserID, "results_db_size", fmt.Sprintf(
+			"Your collected results are using %d MB. Export and clear old results to keep crawling smoothly.", info.Size()>>20))
+	}
+}
+
+// Email the user a quota warning, at most once per interval for each kind of warning
+func (m *CrawlMaster) notifyQuota(ctx context.Context, userID string, kind string, warning string) {
+	if m.Redis != nil {
+		first, err := m.Redis.SetNX(ctx, "quota_warning:"+kind+":"+userID, 1, QUOTA_WARNING_INTERVAL).Result()
+		if err != nil {
+			slog.ErrorContext(ctx, "could not check quota warning interval", "error", err)
+			return
+		} else if !first {
+			return
+		}
+	}
+	m.notify(ctx, userID, email.QuotaWarnings, func(to string) email.Message {
+		return email.QuotaWarningMessage(to, warning)
+	})
+}
+
+func (m *CrawlMaster) notifyAccountEvent(ctx context.Context, userID string, event string, detail string) {
+	m.notify(ctx, userID, email.AccountEvents, func(to string) email.Message {
+		return email.AccountEventMessage(to, event, detail)
+	})
+}
+
+// Send an email in the background, if the user has opted in to this category
+func (m *CrawlMaster) notify(ctx context.Context, userID string, category string, message func(to string) email.Message) {
+	if m.Email == nil {
+		return
+	}
+	// The email is sent after the request that triggered it has finished
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		prefs, err := m.DB.GetNotificationPreferences(ctx, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not get notification preferences", "error", err)
+			return
+		} else if !prefs.Enabled(category) {
+			return
+		}
+		// Only send notifications to addresses the user has confirmed
+		verified, err := m.DB.IsEmailVerified(ctx, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not check email verification", "error", err)
+			return
+		} else if !verified {
+			return
+		}
+		to, err := m.DB.GetUserEmail(ctx, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not get user email", "error", err)
+			return
+		}
+		err = m.Email.Send(message(to))
+		if err != nil {
+			slog.ErrorContext(ctx, "could not send email", "error", err)
+		}
+	}()
+}
+
+func (m *CrawlMaster) NotificationPreferencesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := requestUser(r).ID
+
+		saved := false
+		if r.Method == http.MethodPost {
+			r.ParseForm()
+			prefs := email.Preferences{
+				CrawlSummaries: r.FormValue(email.CrawlSummaries) == "on",
+				QuotaWarnings:  r.FormValue(email.QuotaWarnings) == "on",
+				AccountEvents:  r.FormValue(email.AccountEvents) == "on",
+			}
+			err := m.DB.UpdateNotificationPreferences(r.Context(), userID, prefs)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "could not update notification preferences", "error", err)
+				http.Error(w, "Failed to save preferences", http.StatusInternalServerError)
+				return
+			}
+			saved = true
+		}
+
+		prefs, err := m.DB.GetNotificationPreferences(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not get notification preferences", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		address, err := m.DB.GetUserEmail(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not get user email", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		verified, err := m.DB.IsEmailVerified(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not check email verification", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		tmpl, err := parseTemplate(r.Context(), "internal/html/templates/notification_preferences.gohtml")
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not parse template", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = executeTemplate(r.Context(), tmpl, w, struct {
+			Email       string
+			Verified    bool
+			Preferences email.Preferences
+			Saved       bool
+		}{
+			Email:       address,
+			Verified:    verified,
+			Preferences: prefs,
+			Saved:       saved,
+		})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not render template", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
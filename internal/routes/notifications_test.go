@@ -0,0 +1,143 @@
+package routes
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ztkent/data-manager/internal/config"
+	"github.com/Ztkent/data-manager/internal/db"
+	"github.com/Ztkent/data-manager/internal/email"
+	"github.com/Ztkent/data-manager/internal/webhook"
+)
+
+// Serves a single user's notification settings, any other call panics on the nil interface
+type notificationsDB struct {
+	db.MasterDatabase
+	prefs    email.Preferences
+	verified bool
+	checked  chan struct{} // Receives once the user's preferences have been read
+}
+
+func (d *notificationsDB) GetNotificationPreferences(ctx context.Context, userID string) (email.Preferences, error) {
+	defer func() { d.checked <- struct{}{} }()
+	return d.prefs, nil
+}
+
+func (d *notificationsDB) IsEmailVerified(ctx context.Context, userID string) (bool, error) {
+	return d.verified, nil
+}
+
+func (d *notificationsDB) GetUserEmail(ctx context.Context, userID string) (string, error) {
+	return "user@example.com", nil
+}
+
+func (d *notificationsDB) FinishCrawlJob(ctx context.Context, jobID, status, jobErr string, finishedAt time.Time) error {
+	return nil
+}
+
+type crawlRunsDB struct {
+	db.ManagerDatabase
+	runs []db.CrawlRun
+}
+
+func (d *crawlRunsDB) GetCrawlRuns(ctx context.Context) ([]db.CrawlRun, error) {
+	return d.runs, nil
+}
+
+// Waits for the background notification to finish, and returns what was sent
+func sentAfterNotify(t *testing.T, database *notificationsDB, sender *email.FakeSender) []email.Message {
+	t.Helper()
+	select {
+	case <-database.checked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification preferences were never checked")
+	}
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for time.Now().Before(deadline) && len(sender.Sent()) == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	return sender.Sent()
+}
+
+func TestNotifyAccountEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefs    email.Preferences
+		verified bool
+		wantSent bool
+	}{
+		{"opted in", email.Preferences{AccountEvents: true}, true, true},
+		{"opted out", email.Preferences{CrawlSummaries: true, QuotaWarnings: true}, true, false},
+		{"unverified email", email.Preferences{AccountEvents: true}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := &notificationsDB{prefs: tt.prefs, verified: tt.verified, checked: make(chan struct{}, 1)}
+			sender := &email.FakeSender{}
+			m := &CrawlMaster{DB: database, Email: sender}
+
+			m.notifyAccountEvent(context.Background(), "user", "Password changed", "The password for your account was reset.")
+			sent := sentAfterNotify(t, database, sender)
+			if !tt.wantSent {
+				if len(sent) != 0 {
+					t.Errorf("sent %d emails, want none", len(sent))
+				}
+				return
+			}
+			if len(sent) != 1 {
+				t.Fatalf("sent %d emails, want 1", len(sent))
+			}
+			if sent[0].To != "user@example.com" || !strings.Contains(sent[0].Subject, "Password changed") {
+				t.Errorf("sent %+v", sent[0])
+			}
+		})
+	}
+}
+
+func TestCrawlSummaryNotification(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefs    email.Preferences
+		wantSent bool
+	}{
+		{"opted in", email.Preferences{CrawlSummaries: true}, true},
+		{"opted out", email.Preferences{AccountEvents: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := &notificationsDB{prefs: tt.prefs, verified: true, checked: make(chan struct{}, 1)}
+			sender := &email.FakeSender{}
+			m := &CrawlMaster{DB: database, Email: sender}
+			manager := &CrawlManager{
+				WorkspaceID: "workspace",
+				SqliteDB:    &crawlRunsDB{runs: []db.CrawlRun{{ID: "job", Pages: 12, Duplicates: 3}}},
+			}
+			job := &CrawlJob{
+				ID:         "job",
+				UserID:     "user",
+				Config:     &config.Config{StartingURL: "https://example.com"},
+				StartedAt:  time.Now().Add(-time.Minute),
+				FinishedAt: time.Now(),
+			}
+
+			m.HandleCrawlEvent(manager, webhook.CrawlFinished, job)
+			sent := sentAfterNotify(t, database, sender)
+			if !tt.wantSent {
+				if len(sent) != 0 {
+					t.Errorf("sent %d emails, want none", len(sent))
+				}
+				return
+			}
+			if len(sent) != 1 {
+				t.Fatalf("sent %d emails, want 1", len(sent))
+			}
+			for _, want := range []string{"https://example.com", "finished", "12"} {
+				if !strings.Contains(sent[0].Subject+sent[0].Body, want) {
+					t.Errorf("summary is missing %q: %+v", want, sent[0])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package routes
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/Ztkent/data-manager/internal/auth"
+	"github.com/Ztkent/data-manager/internal/db"
+)
+
+// SetUserRoleHandler lets admins change another user's system role.
+func (m *CrawlMaster) SetUserRoleHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.FormValue("user_id")
+		role := r.FormValue("role")
+		if !auth.ValidRole(role) {
+			m.serveAdminUsers(w, r, adminUsersView{Error: "Invalid role"})
+			return
+		} else if userID == "" || userID == requestUser(r).ID {
+			// Admins can't demote themselves, so there's always someone left to manage roles
+			m.serveAdminUsers(w, r, adminUsersView{Error: "Can't change your own role"})
+			return
+		}
+		err := m.DB.SetUserRole(r.Context(), userID, role)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not set user role", "error", err)
+			m.serveAdminUsers(w, r, adminUsersView{Error: "Failed to update role"})
+			return
+		}
+		m.audit(r, db.AuditEvent{Action: db.AUDIT_ADMIN_ROLE, Target: userID, Detail: role})
+		m.serveAdminUsers(w, r, adminUsersView{Message: "Role updated"})
+	}
+}
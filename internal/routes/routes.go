@@ -5,7 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/exec"
@@ -16,10 +16,18 @@ import (
 	"sync"
 	"time"
 
+	"github.com/Ztkent/data-manager/internal/auth"
 	"github.com/Ztkent/data-manager/internal/config"
 	"github.com/Ztkent/data-manager/internal/db"
+	"github.com/Ztkent/data-manager/internal/email"
+	"github.com/Ztkent/data-manager/internal/logging"
+	"github.com/Ztkent/data-manager/internal/metrics"
+	"github.com/Ztkent/data-manager/internal/sso"
+	"github.com/Ztkent/data-manager/internal/tracing"
+	"github.com/Ztkent/data-manager/internal/webhook"
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Manage all users
@@ -27,54 +35,136 @@ type CrawlMaster struct {
 	ActiveManagers map[string]*CrawlManager
 	DB             db.MasterDatabase
 	Redis          *redis.Client
+	Webhooks       *webhook.Dispatcher
+	Email          email.Sender
+	SSO            []*sso.Provider
 	sync.RWMutex
 }
 
-// Manage a single user
+// Manage a single workspace, a user's personal workspace shares their user id
 type CrawlManager struct {
-	UserID    string
-	CrawlMap  map[string]context.CancelFunc
-	CrawlChan chan string
-	SqliteDB  db.ManagerDatabase
-	CreatedAt *time.Time
-	UpdatedAt *time.Time
+	WorkspaceID  string
+	CrawlMap     map[string]context.CancelFunc
+	CrawlUsers   map[string]string // The user who started each crawler, by starting URL
+	CrawlChan    chan string
+	SqliteDB     db.ManagerDatabase
+	OnCrawlEvent func(m *CrawlManager, event string, job *CrawlJob)
+	CreatedAt    *time.Time
+	UpdatedAt    *time.Time
 	sync.RWMutex
 }
 
+// A single run of the crawler
+type CrawlJob struct {
+	ID         string
+	UserID     string // The user who started the crawl
+	Config     *config.Config
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Err        error
+	ctx        context.Context // Carries the job id, the request that started it and the job's span into logs and traces
+}
+
 const MAX_CRALWERS = 5 // Maximum number of concurrent crawlers
 
 // Crawl Manager
 func (m *CrawlManager) GetDBPath() string {
-	return fmt.Sprintf("user/data-crawler/results_%s.db", m.UserID)
+	return fmt.Sprintf("user/data-crawler/results_%s.db", m.WorkspaceID)
 }
 
 func (m *CrawlManager) GetNetworkPath() string {
-	return fmt.Sprintf("user/network/network_%s.html", m.UserID)
+	return fmt.Sprintf("user/network/network_%s.html", m.WorkspaceID)
 }
 
 func (m *CrawlManager) GetConfigPath() string {
-	return fmt.Sprintf("user/config/config_%s.json", m.UserID)
+	return fmt.Sprintf("user/config/config_%s.json", m.WorkspaceID)
 }
 
-func (m *CrawlManager) StartCrawlerWithConfig(ctx context.Context, curr_config *config.Config) error {
+func (m *CrawlManager) StartCrawlerWithConfig(ctx context.Context, userID string, curr_config *config.Config) error {
 	json, err := json.Marshal(curr_config)
 	if err != nil {
 		return err
 	}
 	path := config.WriteJsonToFile(json, m.GetConfigPath())
 	go func() {
+		job := &CrawlJob{
+			ID:        uuid.New().String(),
+			UserID:    userID,
+			Config:    curr_config,
+			StartedAt: time.Now(),
+		}
+		// Post-crawl processing still has to run after the crawler is killed
+		jobCtx, jobSpan := tracing.Start(logging.WithJobID(context.WithoutCancel(ctx), job.ID), "crawl job",
+			attribute.String("job.id", job.ID), attribute.String("crawl.starting_url", curr_config.StartingURL))
+		defer jobSpan.End()
+		job.ctx = jobCtx
+		slog.InfoContext(job.ctx, "crawler started", "workspace_id", m.WorkspaceID, "starting_url", curr_config.StartingURL)
+		m.crawlEvent(webhook.CrawlStarted, job)
 		cmd := exec.CommandContext(ctx, "./pkg/data-crawler/data-crawler", "-c", path)
+		stdout := logging.NewLineWriter(job.ctx, slog.LevelInfo, "source", "crawler")
+		stderr := logging.NewLineWriter(job.ctx, slog.LevelWarn, "source", "crawler")
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		_, crawlerSpan := tracing.Start(job.ctx, "crawler")
 		err := cmd.Run()
-		if err != nil {
-			fmt.Println(err)
-		}
+		stdout.Close()
+		stderr.Close()
+		tracing.End(crawlerSpan, err)
+		job.FinishedAt = time.Now()
+		event := webhook.CrawlFinished
+		if ctx.Err() == context.Canceled {
+			event = webhook.CrawlKilled
+		} else if err != nil {
+			slog.ErrorContext(job.ctx, "crawler failed", "error", err)
+			job.Err = err
+			event = webhook.CrawlFailed
+		}
+		slog.InfoContext(job.ctx, "crawler exited", "event", event, "duration_seconds", job.FinishedAt.Sub(job.StartedAt).Seconds())
+		metrics.CrawlerExits.WithLabelValues(strings.TrimPrefix(event, "crawl.")).Inc()
+		m.ProcessCrawlResults(job)
+		m.crawlEvent(event, job)
 		// Notify the channel that the crawler is done
 		m.CrawlChan <- curr_config.StartingURL
 	}()
 	return nil
 }
 
-func (m *CrawlManager) AddCrawlerToMap(curr_config *config.Config, cancel context.CancelFunc) error {
+func (m *CrawlManager) crawlEvent(event string, job *CrawlJob) {
+	if m.OnCrawlEvent != nil {
+		m.OnCrawlEvent(m, event, job)
+	}
+}
+
+// The context to log and trace anything concerning the job with
+func (j *CrawlJob) logContext() context.Context {
+	if j.ctx == nil {
+		return logging.WithJobID(context.Background(), j.ID)
+	}
+	return j.ctx
+}
+
+// Run any post-crawl processing on the collected results
+func (m *CrawlManager) ProcessCrawlResults(job *CrawlJob) {
+	ctx, span := tracing.Start(job.logContext(), "process crawl results")
+	defer span.End()
+	err := m.SqliteDB.RecordCrawlRun(ctx, job.ID, job.Config.StartingURL, job.StartedAt, time.Now())
+	if err != nil {
+		slog.ErrorContext(ctx, "could not record crawl run", "error", err)
+	}
+	err = m.SqliteDB.ProcessMetadata(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not process metadata", "error", err)
+	}
+	err = m.SqliteDB.ApplyExtractionRules(ctx, job.Config.StartingURL, job.Config.ExtractionRules, job.StartedAt)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not apply extraction rules", "error", err)
+	}
+	err = m.SqliteDB.IndexContent(ctx, job.StartedAt)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not index content", "error", err)
+	}
+}
+
+func (m *CrawlManager) AddCrawlerToMap(curr_config *config.Config, userID string, cancel context.CancelFunc) error {
 	m.Lock()
 	defer m.Unlock()
 
@@ -83,45 +173,68 @@ func (m *CrawlManager) AddCrawlerToMap(curr_config *config.Config, cancel contex
 		return fmt.Errorf("Too many active crawlers")
 	}
 	m.CrawlMap[curr_config.StartingURL] = cancel
+	m.CrawlUsers[curr_config.StartingURL] = userID
 	return nil
 }
 
 // Crawl Master
-func (m *CrawlMaster) GetCrawlManagerForRequest(r *http.Request) (*CrawlManager, error) {
-	uuid, err := r.Cookie("uuid")
-	if err != nil {
-		return nil, fmt.Errorf("Failed to get UUID from request")
-	}
-
-	// Get the crawl manager for the user
+// Get the crawl manager for the workspace, creating it if it doesn't have one yet
+func (m *CrawlMaster) GetCrawlManager(workspaceID string) *CrawlManager {
 	var crawlManager *CrawlManager
 	m.RLock()
-	crawlManager = m.ActiveManagers[uuid.Value]
+	crawlManager = m.ActiveManagers[workspaceID]
 	m.RUnlock()
 
 	if crawlManager == nil {
-		if crawlManager == nil {
-			now := time.Now()
-			crawlManager = &CrawlManager{
-				UserID:    uuid.Value,
-				CrawlMap:  make(map[string]context.CancelFunc),
-				CrawlChan: make(chan string),
-				CreatedAt: &now,
-				UpdatedAt: &now,
-			}
-			crawlManager.SqliteDB = db.NewManagerDatabase(db.ConnectSqlite(crawlManager.GetDBPath()))
-
-			m.Lock()
-			m.ActiveManagers[uuid.Value] = crawlManager
-			m.Unlock()
-		}
+		now := time.Now()
+		crawlManager = &CrawlManager{
+			WorkspaceID:  workspaceID,
+			CrawlMap:     make(map[string]context.CancelFunc),
+			CrawlUsers:   make(map[string]string),
+			CrawlChan:    make(chan string),
+			OnCrawlEvent: m.HandleCrawlEvent,
+			CreatedAt:    &now,
+			UpdatedAt:    &now,
+		}
+		crawlManager.SqliteDB = db.NewManagerDatabase(db.ConnectSqlite(crawlManager.GetDBPath()))
+
+		m.Lock()
+		m.ActiveManagers[workspaceID] = crawlManager
+		m.Unlock()
 	}
-	return crawlManager, nil
+	return crawlManager
 }
 
 func (m *CrawlMaster) ServeHome() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "internal/html/home.html")
+		// Every HTMX request from the page sends the CSRF token for this browser's session
+		csrfSession, err := getRequestCookie(r, "csrf_session")
+		if err != nil || csrfSession == "" {
+			csrfSession, err = setCSRFSession(w)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "could not create csrf session", "error", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		tmpl, err := parseTemplate(r.Context(), "internal/html/home.html")
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not parse template", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = executeTemplate(r.Context(), tmpl, w, struct {
+			CSRFHeader string
+			CSRFToken  string
+		}{
+			CSRFHeader: auth.CSRF_HEADER,
+			CSRFToken:  auth.CSRFToken(csrfSession),
+		})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not render template", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 	}
 }
 
@@ -138,49 +251,55 @@ func (m *CrawlMaster) Login() http.HandlerFunc {
 			return
 		} else if r.URL.Query().Get("register") == "true" {
 			// Render the register template
-			tmpl, err := template.ParseFiles("internal/html/templates/register_modal.gohtml")
+			tmpl, err := parseTemplate(r.Context(), "internal/html/templates/register_modal.gohtml")
 			if err != nil {
-				log.Default().Println(err)
+				slog.ErrorContext(r.Context(), "could not parse template", "error", err)
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			err = tmpl.Execute(w, nil)
+			err = executeTemplate(r.Context(), tmpl, w, nil)
 			if err != nil {
-				log.Default().Println(err)
+				slog.ErrorContext(r.Context(), "could not render template", "error", err)
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 			}
 			return
-		}
-
-		// Render the login template
-		tmpl, err := template.ParseFiles("internal/html/templates/login_modal.gohtml")
-		if err != nil {
-			log.Default().Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		} else if r.FormValue("two_factor") != "" {
+			// Single sign-on sent back a user with 2FA enabled, ask for their code.
+			// The challenge moves from its cookie into the modal, like it does after a password.
+			challenge, err := getRequestCookie(r, "two_factor_challenge")
+			if err == nil && challenge != "" {
+				http.SetCookie(w, &http.Cookie{
+					Name:    "two_factor_challenge",
+					Value:   "",
+					Path:    "/",
+					Expires: time.Unix(0, 0),
+				})
+				serveTwoFactorModal(w, r, twoFactorModal{Challenge: challenge})
+				return
+			}
+			m.serveLoginModal(w, r, "Your login expired, please log in again")
 			return
 		}
-		err = tmpl.Execute(w, nil)
-		if err != nil {
-			log.Default().Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+
+		// Render the login template, with the reason single sign-on failed if we were sent back from it
+		m.serveLoginModal(w, r, ssoErrors[r.FormValue("sso_error")])
 	}
 }
 func (m *CrawlMaster) ConfirmLoginAttempt(alert bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Render the logout button if the user is logged in
 		if alert {
-			serveSuccessToast(w, "Login Successful")
+			serveSuccessToast(w, r, "Login Successful")
 		}
-		tmpl, err := template.ParseFiles("internal/html/templates/logout_button.gohtml")
+		tmpl, err := parseTemplate(r.Context(), "internal/html/templates/logout_button.gohtml")
 		if err != nil {
-			log.Default().Println(err)
+			slog.ErrorContext(r.Context(), "could not parse template", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		err = tmpl.Execute(w, nil)
+		err = executeTemplate(r.Context(), tmpl, w, nil)
 		if err != nil {
-			log.Default().Println(err)
+			slog.ErrorContext(r.Context(), "could not render template", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 	}
@@ -188,13 +307,7 @@ func (m *CrawlMaster) ConfirmLoginAttempt(alert bool) http.HandlerFunc {
 
 func (m *CrawlMaster) ValidateLogin() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Check if the user is logged in
-		err := checkIfUserLoggedIn(r, w, m)
-		if err != nil {
-			// User is not logged in
-			http.Error(w, "User is not logged in", http.StatusUnauthorized)
-			return
-		}
+		// Authenticate has already checked the user is logged in
 		m.ConfirmLoginAttempt(false)(w, r)
 	}
 }
@@ -208,55 +321,84 @@ func (m *CrawlMaster) SubmitLogin() http.HandlerFunc {
 		// Validate the email and password
 		valid := validateEmail(email)
 		if !valid {
-			log.Default().Println("Invalid email")
-			loginModalError(w, "Invalid email")
+			slog.DebugContext(r.Context(), "invalid email")
+			m.serveLoginModal(w, r, "Invalid email")
 			return
 		}
 		validPass, reason := validatePassword(pass, pass)
 		if !validPass {
-			log.Default().Println("Invalid password: ", reason)
-			loginModalError(w, "Invalid password: "+reason)
+			slog.DebugContext(r.Context(), "invalid password", "reason", reason)
+			m.serveLoginModal(w, r, "Invalid password: "+reason)
+		}
+		// Locked out emails get the same response whether or not they belong to a user
+		if m.loginLockout(r.Context(), email) > 0 {
+			m.recordLoginAttempt(r, email, "", false, db.LOGIN_LOCKED_OUT)
+			m.serveLoginModal(w, r, "Too many failed attempts, try again later")
+			return
 		}
-		userId, token, err := m.DB.LoginUser(email, pass)
+		userId, err := m.DB.LoginUser(r.Context(), email, pass)
 		if err != nil {
-			log.Default().Println(err)
-			loginModalError(w, "Login Failed")
+			slog.WarnContext(r.Context(), "login failed", "error", err)
+			m.loginFailed(r, email, db.LOGIN_BAD_CREDENTIALS)
+			m.serveLoginModal(w, r, "Login Failed")
 			return
 		}
 
-		// Set the correct cookies for a logged-in user
-		http.SetCookie(w, &http.Cookie{
-			Name:     "uuid",
-			Value:    userId,
-			HttpOnly: true,
-			Secure:   true, // Set to true if your site uses HTTPS
-			SameSite: http.SameSiteStrictMode,
-		})
-		http.SetCookie(w, &http.Cookie{
-			Name:     "session_token",
-			Value:    token,
-			HttpOnly: true,
-			Secure:   true, // Set to true if your site uses HTTPS
-			SameSite: http.SameSiteStrictMode,
-		})
+		// Users with 2FA enabled need to enter a code before they get a session
+		totp, err := m.DB.GetTOTP(r.Context(), userId)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not get totp", "error", err)
+			m.serveLoginModal(w, r, "Login Failed")
+			return
+		} else if totp.Enabled {
+			m.loginSucceeded(r, email, userId, db.LOGIN_TWO_FACTOR_PENDING)
+			err = m.startLoginChallenge(w, r, userId)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "could not start login challenge", "error", err)
+				m.serveLoginModal(w, r, "Login Failed")
+			}
+			return
+		}
+		m.loginSucceeded(r, email, userId, db.LOGIN_SUCCESS)
+		m.completeLogin(w, r, userId)
+	}
+}
 
-		// return hx-post targeting the login button to change it to a logout button
-		w.Write([]byte(`<div id="confirmLogin" hx-post="/confirm-login" hx-trigger="load" hx-target="#logDiv"> </div>`))
+func (m *CrawlMaster) completeLogin(w http.ResponseWriter, r *http.Request, userID string) {
+	// Start a session and set the correct cookies for a logged-in user
+	err := m.startSession(w, r, userID)
+	if err == errUserDisabled {
+		m.serveLoginModal(w, r, "This account has been disabled")
+		return
+	} else if err != nil {
+		slog.ErrorContext(r.Context(), "could not start session", "error", err)
+		m.serveLoginModal(w, r, "Login Failed")
 		return
-
 	}
+
+	m.notifyAccountEvent(r.Context(), userID, "New sign-in", fmt.Sprintf(
+		"Your account signed in at %s from %s (%s).", time.Now().UTC().Format("2006-01-02 15:04:05 MST"), r.RemoteAddr, r.UserAgent()))
+
+	// return hx-post targeting the login button to change it to a logout button
+	w.Write([]byte(`<div id="confirmLogin" hx-post="/confirm-login" hx-trigger="load" hx-target="#logDiv"> </div>`))
 }
 
-func loginModalError(w http.ResponseWriter, message string) {
-	tmpl, err := template.ParseFiles("internal/html/templates/login_modal.gohtml")
+func (m *CrawlMaster) serveLoginModal(w http.ResponseWriter, r *http.Request, message string) {
+	tmpl, err := parseTemplate(r.Context(), "internal/html/templates/login_modal.gohtml")
 	if err != nil {
-		log.Default().Println(err)
+		slog.ErrorContext(r.Context(), "could not parse template", "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	err = tmpl.Execute(w, message)
+	err = executeTemplate(r.Context(), tmpl, w, struct {
+		Error     string
+		Providers []*sso.Provider
+	}{
+		Error:     message,
+		Providers: m.SSO,
+	})
 	if err != nil {
-		log.Default().Println(err)
+		slog.ErrorContext(r.Context(), "could not render template", "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 	return
@@ -271,29 +413,39 @@ func (m *CrawlMaster) SubmitRegister() http.HandlerFunc {
 		// Validate the email and password
 		valid := validateEmail(email)
 		if !valid {
-			log.Default().Println("Invalid Email")
+			slog.DebugContext(r.Context(), "invalid email")
 			return
 		}
 		validPass, reason := validatePassword(pass, repeatPass)
 		if !validPass {
-			log.Default().Println("Invalid password: ", reason)
+			slog.DebugContext(r.Context(), "invalid password", "reason", reason)
 			return
 		}
 
 		id, err := getRequestCookie(r, "uuid")
 		if err != nil {
-			log.Default().Println(err)
+			slog.WarnContext(r.Context(), "could not get uuid cookie", "error", err)
 			http.Error(w, "Failed to get UUID", http.StatusInternalServerError)
 			return
 		}
 
-		err = m.DB.CreateUser(id, email, pass)
+		// The anonymous visitor's id becomes the account's id, unless it's taken. With a new id, logging in below
+		// claims the results left under the visitor's id, unless the id belongs to another account or workspace.
+		userID, err := m.DB.CreateUser(r.Context(), id, email, pass)
 		if err != nil {
-			log.Default().Println(err)
+			slog.ErrorContext(r.Context(), "could not create user", "error", err)
 			http.Error(w, "Failed to create user", http.StatusInternalServerError)
 			return
 		}
 
+		m.audit(r, db.AuditEvent{ActorID: userID, Action: db.AUDIT_REGISTER, Target: email})
+
+		// Ask the user to confirm their email
+		err = m.sendVerificationEmail(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not send verification email", "error", err)
+		}
+
 		// Log the user in
 		m.SubmitLogin()(w, r)
 	}
@@ -301,19 +453,19 @@ func (m *CrawlMaster) SubmitRegister() http.HandlerFunc {
 
 func (m *CrawlMaster) Logout() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Remove the cookies
-		clearCookies(w)
+		// Revoke the session and remove the cookies
+		m.endSession(w, r)
 		// Render the active_crawlers template, which displays the active crawlers
-		serveSuccessToast(w, "Logout Successful")
-		tmpl, err := template.ParseFiles("internal/html/templates/login_button.gohtml")
+		serveSuccessToast(w, r, "Logout Successful")
+		tmpl, err := parseTemplate(r.Context(), "internal/html/templates/login_button.gohtml")
 		if err != nil {
-			log.Default().Println(err)
+			slog.ErrorContext(r.Context(), "could not parse template", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		err = tmpl.Execute(w, nil)
+		err = executeTemplate(r.Context(), tmpl, w, nil)
 		if err != nil {
-			log.Default().Println(err)
+			slog.ErrorContext(r.Context(), "could not render template", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 	}
@@ -321,17 +473,7 @@ func (m *CrawlMaster) Logout() http.HandlerFunc {
 
 func (m *CrawlMaster) ServeNetwork() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := checkIfUserLoggedIn(r, w, m)
-		if err != nil {
-			serveFailToast(w, "User is not logged in")
-			return
-		}
-		crawlManager, err := m.GetCrawlManagerForRequest(r)
-		if err != nil {
-			log.Default().Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		crawlManager := requestCrawlManager(r)
 		// check if the network file exists
 		if _, err := os.Stat(crawlManager.GetNetworkPath()); os.IsNotExist(err) {
 			// call GenNetwork if the file does not exist
@@ -345,36 +487,35 @@ func (m *CrawlMaster) ServeNetwork() http.HandlerFunc {
 
 func (m *CrawlMaster) GenNetwork() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := checkIfUserLoggedIn(r, w, m)
-		if err != nil {
-			serveFailToast(w, "User is not logged in")
-			return
-		}
-		crawlManager, err := m.GetCrawlManagerForRequest(r)
-		if err != nil {
-			log.Default().Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		crawlManager := requestCrawlManager(r)
 
 		cmd := exec.Command("python3", "pkg/data-processor/data_processor.py", "--database", crawlManager.GetDBPath(), "--output", crawlManager.GetNetworkPath())
+		stdout := logging.NewLineWriter(r.Context(), slog.LevelInfo, "source", "processor")
+		stderr := logging.NewLineWriter(r.Context(), slog.LevelWarn, "source", "processor")
+		cmd.Stdout, cmd.Stderr = stdout, stderr
 		// Generate a network file with the processor
-		err = cmd.Run()
+		_, span := tracing.Start(r.Context(), "processor")
+		start := time.Now()
+		err := cmd.Run()
+		stdout.Close()
+		stderr.Close()
+		tracing.End(span, err)
+		metrics.GraphGenerationDuration.Observe(time.Since(start).Seconds())
 		if err != nil {
-			log.Default().Println(err)
+			slog.ErrorContext(r.Context(), "could not generate network graph", "error", err)
 			http.Error(w, "Error generating network file", http.StatusInternalServerError)
 			return
 		}
 		// Render the active_crawlers template, which displays the active crawlers
-		tmpl, err := template.ParseFiles("internal/html/templates/network_iframe.gohtml")
+		tmpl, err := parseTemplate(r.Context(), "internal/html/templates/network_iframe.gohtml")
 		if err != nil {
-			log.Default().Println(err)
+			slog.ErrorContext(r.Context(), "could not parse template", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		err = tmpl.Execute(w, nil)
+		err = executeTemplate(r.Context(), tmpl, w, nil)
 		if err != nil {
-			log.Default().Println(err)
+			slog.ErrorContext(r.Context(), "could not render template", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 	}
@@ -387,7 +528,7 @@ func (m *CrawlMaster) Download() http.HandlerFunc {
 		idStr := r.URL.Query().Get("file")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
-			log.Default().Println(err)
+			slog.WarnContext(r.Context(), "invalid file id", "error", err)
 			http.Error(w, "Failed to parse file id", http.StatusBadRequest)
 			return
 		}
@@ -396,21 +537,11 @@ func (m *CrawlMaster) Download() http.HandlerFunc {
 			return
 		}
 
-		err = checkIfUserLoggedIn(r, w, m)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-			return
-		}
-		crawlManager, err := m.GetCrawlManagerForRequest(r)
-		if err != nil {
-			log.Default().Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		crawlManager := requestCrawlManager(r)
 
-		dataPath, err := crawlManager.SqliteDB.DownloadFile(fileType, id)
+		dataPath, err := crawlManager.SqliteDB.DownloadFile(r.Context(), fileType, id)
 		if err != nil {
-			log.Default().Println(err)
+			slog.ErrorContext(r.Context(), "could not download file", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 		// Set the extention and download file name
@@ -419,6 +550,7 @@ func (m *CrawlMaster) Download() http.HandlerFunc {
 			ext := filepath.Ext(name)
 			fileName = fmt.Sprintf("%s_%d%s", fileType, id, ext)
 		}
+		m.audit(r, db.AuditEvent{Action: db.AUDIT_DOWNLOAD, Target: fmt.Sprintf("%s %d", fileType, id)})
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
 		w.Header().Set("Content-Type", "application/octet-stream")
 		http.ServeFile(w, r, dataPath)
@@ -427,46 +559,70 @@ func (m *CrawlMaster) Download() http.HandlerFunc {
 
 func (m *CrawlMaster) ExportDB() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := checkIfUserLoggedIn(r, w, m)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-			return
-		}
-		crawlManager, err := m.GetCrawlManagerForRequest(r)
-		if err != nil {
-			log.Default().Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		crawlManager := requestCrawlManager(r)
 
 		if _, err := os.Stat(crawlManager.GetDBPath()); os.IsNotExist(err) {
-			log.Default().Println(err)
+			slog.WarnContext(r.Context(), "results db not found", "error", err)
 			http.Error(w, "Results DB not found", http.StatusNotFound)
 			return
 		}
 
+		var err error
 		dataPath := crawlManager.GetDBPath()
 		filePath := "results.db"
-		if r.URL.Query().Get("csv") == "true" {
-			// Export the database to a CSV file
-			dataPath, err = crawlManager.SqliteDB.ExportToCSV(crawlManager.GetDBPath(), "visited")
+		format := "sqlite"
+		exportFile := r.URL.Query().Get("csv") == "true" || r.URL.Query().Get("json") == "true"
+		if r.URL.Query().Get("json") == "true" {
+			// Export a table from the database to a JSON file
+			table := r.URL.Query().Get("table")
+			if !db.ExportableTables[table] {
+				http.Error(w, "Invalid export table", http.StatusBadRequest)
+				return
+			}
+			dataPath, err = crawlManager.SqliteDB.ExportToJSON(r.Context(), table)
 			if err != nil {
-				log.Default().Println(err)
+				slog.ErrorContext(r.Context(), "could not export to json", "error", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			filePath = table + ".json"
+			format = "json"
+		} else if r.URL.Query().Get("csv") == "true" {
+			// Export a table from the database to a CSV file
+			table := r.URL.Query().Get("table")
+			if table == "" {
+				table = "visited"
+			}
+			if !db.ExportableTables[table] {
+				http.Error(w, "Invalid export table", http.StatusBadRequest)
+				return
+			}
+			dataPath, err = crawlManager.SqliteDB.ExportToCSV(r.Context(), crawlManager.GetDBPath(), table)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "could not export to csv", "error", err)
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
 			filePath = "results.csv"
+			format = "csv"
+			if table != "visited" {
+				filePath = table + ".csv"
+			}
 		}
 
+		m.audit(r, db.AuditEvent{Action: db.AUDIT_EXPORT, Target: filePath})
+		if info, err := os.Stat(dataPath); err == nil {
+			metrics.ExportBytes.WithLabelValues(format).Observe(float64(info.Size()))
+		}
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filePath))
 		w.Header().Set("Content-Type", "application/octet-stream")
 		http.ServeFile(w, r, dataPath)
 
 		// Delete the temporary files, if any
-		if r.URL.Query().Get("csv") == "true" {
+		if exportFile {
 			err := os.Remove(dataPath)
 			if err != nil {
-				log.Default().Println(err)
+				slog.ErrorContext(r.Context(), "could not remove file", "error", err)
 			}
 		}
 	}
@@ -474,33 +630,23 @@ func (m *CrawlMaster) ExportDB() http.HandlerFunc {
 
 func (m *CrawlMaster) ExportModal() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := checkIfUserLoggedIn(r, w, m)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-			return
-		}
-		crawlManager, err := m.GetCrawlManagerForRequest(r)
-		if err != nil {
-			log.Default().Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		crawlManager := requestCrawlManager(r)
 
 		if _, err := os.Stat(crawlManager.GetDBPath()); os.IsNotExist(err) {
-			log.Default().Println(err)
+			slog.WarnContext(r.Context(), "results db not found", "error", err)
 			http.Error(w, "Results DB not found", http.StatusNotFound)
 			return
 		}
 
-		tmpl, err := template.ParseFiles("internal/html/templates/export_modal.gohtml")
+		tmpl, err := parseTemplate(r.Context(), "internal/html/templates/export_modal.gohtml")
 		if err != nil {
-			log.Default().Println(err)
+			slog.ErrorContext(r.Context(), "could not parse template", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		err = tmpl.Execute(w, nil)
+		err = executeTemplate(r.Context(), tmpl, w, nil)
 		if err != nil {
-			log.Default().Println(err)
+			slog.ErrorContext(r.Context(), "could not render template", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 	}
@@ -508,150 +654,116 @@ func (m *CrawlMaster) ExportModal() http.HandlerFunc {
 
 func (m *CrawlMaster) CrawlHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := checkIfUserLoggedIn(r, w, m)
-		if err != nil {
-			serveFailToast(w, "User is not logged in")
-			return
-		}
-
-		crawlManager, err := m.GetCrawlManagerForRequest(r)
-		if err != nil {
-			log.Default().Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		crawlManager := requestCrawlManager(r)
 
 		r.ParseForm()
 		curr_config, err := config.ParseFormToConfig(r.Form, crawlManager.GetDBPath())
 		if err != nil {
-			log.Default().Println(err)
-			curr_config = config.NewDefaultConfig()
-			http.Error(w, "Error parsing config settings, using default", http.StatusBadRequest)
+			slog.ErrorContext(r.Context(), "could not parse crawl config", "error", err)
+			serveFailToast(w, r, "Error parsing config settings: "+err.Error())
+			return
 		}
 
 		if curr_config.StartingURL == "" {
-			serveFailToast(w, "No URL provided")
+			serveFailToast(w, r, "No URL provided")
 			return
 		}
 
 		// ensure the StartingURL is properly formatted
 		valid, reason := validateStartingURL(curr_config.StartingURL)
 		if !valid {
-			serveFailToast(w, fmt.Sprintf("%s", reason))
+			serveFailToast(w, r, fmt.Sprintf("%s", reason))
 			return
 		}
 
 		// Add the crawler to the map, check the limit
-		ctxCrawler, cancel := context.WithCancel(context.Background())
-		err = crawlManager.AddCrawlerToMap(curr_config, cancel)
+		ctxCrawler, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+		err = crawlManager.AddCrawlerToMap(curr_config, requestUser(r).ID, cancel)
 		if err != nil {
-			log.Default().Println(err)
-			serveFailToast(w, err.Error())
+			slog.ErrorContext(r.Context(), "could not add crawler", "error", err)
+			m.notifyQuota(r.Context(), requestUser(r).ID, "crawler_limit", fmt.Sprintf(
+				"You reached the limit of %d concurrent crawlers. New crawls are rejected until a running crawl finishes.", MAX_CRALWERS))
+			serveFailToast(w, r, err.Error())
 			return
 		}
 
-		err = crawlManager.StartCrawlerWithConfig(ctxCrawler, curr_config)
+		err = crawlManager.StartCrawlerWithConfig(ctxCrawler, requestUser(r).ID, curr_config)
 		if err != nil {
-			log.Default().Println(err)
-			serveFailToast(w, "Error starting crawler: "+curr_config.StartingURL)
+			slog.ErrorContext(r.Context(), "could not start crawler", "error", err)
+			serveFailToast(w, r, "Error starting crawler: "+curr_config.StartingURL)
 			return
 		}
+		m.audit(r, db.AuditEvent{Action: db.AUDIT_CRAWL_START, Target: curr_config.StartingURL})
 	}
 }
 
 func (m *CrawlMaster) CrawlRandomHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := checkIfUserLoggedIn(r, w, m)
-		if err != nil {
-			serveFailToast(w, "User is not logged in")
-			return
-		}
-		crawlManager, err := m.GetCrawlManagerForRequest(r)
-		if err != nil {
-			log.Default().Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		crawlManager := requestCrawlManager(r)
 
 		r.ParseForm()
 		randomURL, err := selectRandomUrl()
 		if err != nil {
-			serveFailToast(w, "Error selecting starting url")
+			serveFailToast(w, r, "Error selecting starting url")
 			return
 		} else if randomURL == "" {
-			serveFailToast(w, "Failed to randomly select url")
+			serveFailToast(w, r, "Failed to randomly select url")
 			return
 		}
 
 		r.Form.Set("StartingURL", randomURL)
 		curr_config, err := config.ParseFormToConfig(r.Form, crawlManager.GetDBPath())
 		if err != nil {
-			log.Default().Println(err)
-			curr_config = config.NewDefaultConfig()
-			http.Error(w, "Error parsing config settings, using default", http.StatusBadRequest)
+			slog.ErrorContext(r.Context(), "could not parse crawl config", "error", err)
+			serveFailToast(w, r, "Error parsing config settings: "+err.Error())
+			return
 		}
 
 		// Add the crawler to the map, check the limit
-		ctxCrawler, cancel := context.WithCancel(context.Background())
-		err = crawlManager.AddCrawlerToMap(curr_config, cancel)
+		ctxCrawler, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+		err = crawlManager.AddCrawlerToMap(curr_config, requestUser(r).ID, cancel)
 		if err != nil {
-			log.Default().Println(err)
-			serveFailToast(w, err.Error())
+			slog.ErrorContext(r.Context(), "could not add crawler", "error", err)
+			m.notifyQuota(r.Context(), requestUser(r).ID, "crawler_limit", fmt.Sprintf(
+				"You reached the limit of %d concurrent crawlers. New crawls are rejected until a running crawl finishes.", MAX_CRALWERS))
+			serveFailToast(w, r, err.Error())
 			return
 		}
 
-		err = crawlManager.StartCrawlerWithConfig(ctxCrawler, curr_config)
+		err = crawlManager.StartCrawlerWithConfig(ctxCrawler, requestUser(r).ID, curr_config)
 		if err != nil {
-			log.Default().Println(err)
-			serveFailToast(w, "Error starting crawler: "+curr_config.StartingURL)
+			slog.ErrorContext(r.Context(), "could not start crawler", "error", err)
+			serveFailToast(w, r, "Error starting crawler: "+curr_config.StartingURL)
 			return
 		}
+		m.audit(r, db.AuditEvent{Action: db.AUDIT_CRAWL_START, Target: curr_config.StartingURL})
 	}
 }
 
 func (m *CrawlMaster) KillAllCrawlersHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := checkIfUserLoggedIn(r, w, m)
-		if err != nil {
-			serveFailToast(w, "User is not logged in")
-			return
-		}
-		crawlManager, err := m.GetCrawlManagerForRequest(r)
-		if err != nil {
-			log.Default().Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		crawlManager := requestCrawlManager(r)
 
 		numCrawler := len(crawlManager.CrawlMap)
 		if numCrawler == 0 {
-			serveFailToast(w, "No active crawlers to kill")
+			serveFailToast(w, r, "No active crawlers to kill")
 			return
 		}
 		for _, cancel := range crawlManager.CrawlMap {
 			cancel()
 		}
+		m.audit(r, db.AuditEvent{Action: db.AUDIT_CRAWL_KILL, Target: "all", Detail: fmt.Sprintf("%d crawlers", numCrawler)})
 		message := "1 crawler killed"
 		if numCrawler > 1 {
 			message = fmt.Sprintf("%d crawlers killed", numCrawler)
 		}
-		serveSuccessToast(w, message)
+		serveSuccessToast(w, r, message)
 	}
 }
 
 func (m *CrawlMaster) KillCrawlerHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := checkIfUserLoggedIn(r, w, m)
-		if err != nil {
-			serveFailToast(w, "User is not logged in")
-			return
-		}
-		crawlManager, err := m.GetCrawlManagerForRequest(r)
-		if err != nil {
-			log.Default().Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		crawlManager := requestCrawlManager(r)
 
 		url := r.FormValue("url")
 		cancel, ok := crawlManager.CrawlMap[url]
@@ -659,6 +771,7 @@ func (m *CrawlMaster) KillCrawlerHandler() http.HandlerFunc {
 			http.Error(w, "Crawler not found", http.StatusNotFound)
 		} else {
 			cancel()
+			m.audit(r, db.AuditEvent{Action: db.AUDIT_CRAWL_KILL, Target: url})
 		}
 		m.ActiveCrawlersHandler()(w, r)
 	}
@@ -669,12 +782,7 @@ func (m *CrawlMaster) ActiveCrawlersHandler() http.HandlerFunc {
 		URL string
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		crawlManager, err := m.GetCrawlManagerForRequest(r)
-		if err != nil {
-			log.Default().Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		crawlManager := requestCrawlManager(r)
 		crawlers := make([]Crawler, 0, len(crawlManager.CrawlMap))
 		for url := range crawlManager.CrawlMap {
 			crawlers = append(crawlers, Crawler{URL: url})
@@ -684,15 +792,15 @@ func (m *CrawlMaster) ActiveCrawlersHandler() http.HandlerFunc {
 		})
 
 		// Render the active_crawlers template, which displays the active crawlers
-		tmpl, err := template.ParseFiles("internal/html/templates/active_crawlers.gohtml")
+		tmpl, err := parseTemplate(r.Context(), "internal/html/templates/active_crawlers.gohtml")
 		if err != nil {
-			log.Default().Println(err)
+			slog.ErrorContext(r.Context(), "could not parse template", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		err = tmpl.Execute(w, crawlers)
+		err = executeTemplate(r.Context(), tmpl, w, crawlers)
 		if err != nil {
-			log.Default().Println(err)
+			slog.ErrorContext(r.Context(), "could not render template", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 	}
@@ -700,66 +808,137 @@ func (m *CrawlMaster) ActiveCrawlersHandler() http.HandlerFunc {
 
 func (m *CrawlMaster) RecentURLsHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		crawlManager, err := m.GetCrawlManagerForRequest(r)
+		crawlManager := requestCrawlManager(r)
+		tmpl, err := parseTemplate(r.Context(), "internal/html/templates/recent_visited.gohtml")
 		if err != nil {
-			log.Default().Println(err)
+			slog.ErrorContext(r.Context(), "could not parse template", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		tmpl, err := template.ParseFiles("internal/html/templates/recent_visited.gohtml")
+		visited, err := crawlManager.SqliteDB.GetRecentVisited(r.Context(), parseResultsFilter(r))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not get recent visited", "error", err)
+		}
+		err = executeTemplate(r.Context(), tmpl, w, visited)
 		if err != nil {
-			log.Default().Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+func (m *CrawlMaster) FileCollectionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		crawlManager := requestCrawlManager(r)
+
+		// Get the recent file collection for the user
+		fileType := r.FormValue("fileType")
+		fc, err := crawlManager.SqliteDB.GetFilesForType(r.Context(), fileType, parseResultsFilter(r))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not get files for type", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		visited, err := crawlManager.SqliteDB.GetRecentVisited()
+
+		// Render the file_collection template, which displays the file collection
+		tmpl, err := parseTemplate(r.Context(), "internal/html/templates/file_collection.gohtml")
 		if err != nil {
-			log.Default().Println(err)
+			slog.ErrorContext(r.Context(), "could not parse template", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		err = tmpl.Execute(w, visited)
+		err = executeTemplate(r.Context(), tmpl, w, fc)
 		if err != nil {
+			slog.ErrorContext(r.Context(), "could not render template", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 	}
 }
-func (m *CrawlMaster) FileCollectionHandler() http.HandlerFunc {
+func (m *CrawlMaster) SearchHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := checkIfUserLoggedIn(r, w, m)
+		crawlManager := requestCrawlManager(r)
+
+		page, err := strconv.Atoi(r.URL.Query().Get("page"))
 		if err != nil {
-			log.Default().Println(err)
-			http.Error(w, err.Error(), http.StatusUnauthorized)
+			page = 1
+		}
+		results, err := crawlManager.SqliteDB.SearchContent(r.Context(), r.URL.Query().Get("q"), page)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not search content", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		crawlManager, err := m.GetCrawlManagerForRequest(r)
+
+		// Render the search_results template, which displays the matches and pagination
+		tmpl, err := template.New("search_results.gohtml").Funcs(template.FuncMap{
+			"highlight": highlightSnippet,
+			"add":       func(a, b int) int { return a + b },
+		}).ParseFiles("internal/html/templates/search_results.gohtml")
 		if err != nil {
-			log.Default().Println(err)
+			slog.ErrorContext(r.Context(), "could not parse template", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		err = executeTemplate(r.Context(), tmpl, w, results)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not render template", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
 
-		// Get the recent file collection for the user
-		fileType := r.FormValue("fileType")
-		fc, err := crawlManager.SqliteDB.GetFilesForType(fileType)
+func (m *CrawlMaster) CrawlHistoryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		crawlManager := requestCrawlManager(r)
+
+		runs, err := crawlManager.SqliteDB.GetCrawlRuns(r.Context())
 		if err != nil {
-			log.Default().Println(err)
+			slog.ErrorContext(r.Context(), "could not get crawl runs", "error", err)
+		}
+		tmpl, err := parseTemplate(r.Context(), "internal/html/templates/crawl_history.gohtml")
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not parse template", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		err = executeTemplate(r.Context(), tmpl, w, runs)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not render template", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
 
-		// Render the file_collection template, which displays the file collection
-		tmpl, err := template.ParseFiles("internal/html/templates/file_collection.gohtml")
+func (m *CrawlMaster) CrawlDiffHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		crawlManager := requestCrawlManager(r)
+
+		from := r.URL.Query().Get("from")
+		to := r.URL.Query().Get("to")
+		if from == "" || to == "" {
+			http.Error(w, "Missing crawl runs to compare", http.StatusBadRequest)
+			return
+		}
+		diff, err := crawlManager.SqliteDB.DiffCrawlRuns(r.Context(), from, to)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not diff crawl runs", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Render the crawl_diff template, which displays the changes between the runs
+		tmpl, err := parseTemplate(r.Context(), "internal/html/templates/crawl_diff.gohtml")
 		if err != nil {
-			log.Default().Println(err)
+			slog.ErrorContext(r.Context(), "could not parse template", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		err = tmpl.Execute(w, fc)
+		err = executeTemplate(r.Context(), tmpl, w, diff)
 		if err != nil {
-			log.Default().Println(err)
+			slog.ErrorContext(r.Context(), "could not render template", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 	}
 }
+
 func (m *CrawlMaster) HandleFinishedCrawlers() {
 	for {
 		time.Sleep(1 * time.Second)
@@ -772,6 +951,7 @@ func (m *CrawlMaster) HandleFinishedCrawlers() {
 					crawler.Lock()
 					defer crawler.Unlock()
 					delete(crawler.CrawlMap, url)
+					delete(crawler.CrawlUsers, url)
 				default:
 					continue
 				}
@@ -783,11 +963,11 @@ func (m *CrawlMaster) HandleFinishedCrawlers() {
 func (m *CrawlMaster) ResourceManger() http.HandlerFunc {
 	for {
 		func() {
-			active_users := m.GetRecentlyActiveUsers()
+			active_users := m.GetRecentlyActiveWorkspaces()
 			for _, path := range []string{"user/data-crawler", "user/network", "user/config"} {
 				files, err := os.ReadDir(path)
 				if err != nil {
-					log.Default().Println(err)
+					slog.Error("could not read directory", "error", err)
 					return
 				}
 				for _, file := range files {
@@ -801,9 +981,13 @@ func (m *CrawlMaster) ResourceManger() http.HandlerFunc {
 							id = strings.TrimPrefix(strings.TrimSuffix(file.Name(), ".json"), "config_")
 						}
 						if _, ok := active_users[id]; !ok {
+							// Anonymous visitors' files are kept a while, so they can be claimed when the visitor logs in
+							if info, err := file.Info(); err == nil && time.Since(info.ModTime()) < ANONYMOUS_RESULTS_TTL {
+								continue
+							}
 							err := os.Remove(fmt.Sprintf("%s/%s", path, file.Name()))
 							if err != nil {
-								log.Default().Println(err)
+								slog.Error("could not remove file", "error", err)
 							}
 						}
 					}
@@ -814,22 +998,33 @@ func (m *CrawlMaster) ResourceManger() http.HandlerFunc {
 	}
 }
 
-func (m *CrawlMaster) GetRecentlyActiveUsers() map[string]bool {
+// The workspaces whose files should be kept, their ids match the names of their files
+func (m *CrawlMaster) GetRecentlyActiveWorkspaces() map[string]bool {
+	ctx := context.Background()
 	m.RLock()
 	defer m.RUnlock()
 	active_users := make(map[string]bool)
 	for _, crawler := range m.ActiveManagers {
-		active_users[crawler.UserID] = true
+		active_users[crawler.WorkspaceID] = true
 	}
-	// Support users who have been active in the last 3 days
-	dbActiveUsers, err := m.DB.GetRecentlyActiveUsers()
+	// Support users who have been active in the last 3 days, and the personal workspaces that share their id
+	dbActiveUsers, err := m.DB.GetRecentlyActiveUsers(ctx)
 	if err != nil {
-		log.Default().Println(err)
+		slog.Error("could not get recently active users", "error", err)
 	} else {
 		for _, user := range dbActiveUsers {
 			active_users[user] = true
 		}
 	}
+	// Support team workspaces with a member who has been active in the last 3 days
+	dbActiveWorkspaces, err := m.DB.GetRecentlyActiveWorkspaces(ctx)
+	if err != nil {
+		slog.Error("could not get recently active workspaces", "error", err)
+	} else {
+		for _, workspace := range dbActiveWorkspaces {
+			active_users[workspace] = true
+		}
+	}
 
 	return active_users
 }
@@ -840,12 +1035,12 @@ func (m *CrawlMaster) AboutModalHandler() http.HandlerFunc {
 			return
 		}
 
-		tmpl, err := template.ParseFiles("internal/html/templates/about_modal.gohtml")
+		tmpl, err := parseTemplate(r.Context(), "internal/html/templates/about_modal.gohtml")
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		err = tmpl.Execute(w, nil)
+		err = executeTemplate(r.Context(), tmpl, w, nil)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
@@ -2,17 +2,25 @@ package routes
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"html/template"
-	"log"
+	"io"
+	"log/slog"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
+
+	"github.com/Ztkent/data-manager/internal/db"
+	"github.com/Ztkent/data-manager/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Toast struct {
@@ -100,53 +108,94 @@ func validateStartingURL(startingURL string) (bool, string) {
 	return true, ""
 }
 
-func serveFailToast(w http.ResponseWriter, message string) {
+func serveFailToast(w http.ResponseWriter, r *http.Request, message string) {
 	// Render the crawl_status template, which displays the toast
-	tmpl, err := template.ParseFiles("internal/html/templates/crawl_status_toast.gohtml")
+	tmpl, err := parseTemplate(r.Context(), "internal/html/templates/crawl_status_toast.gohtml")
 	if err != nil {
-		log.Default().Println(err)
+		slog.ErrorContext(r.Context(), "could not parse template", "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	toast := &Toast{ToastContent: message, Border: "border-red-200"}
-	err = tmpl.Execute(w, toast)
+	err = executeTemplate(r.Context(), tmpl, w, toast)
 	if err != nil {
-		log.Default().Println(err)
+		slog.ErrorContext(r.Context(), "could not render template", "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 	return
 }
 
-func serveSuccessToast(w http.ResponseWriter, message string) {
+func serveSuccessToast(w http.ResponseWriter, r *http.Request, message string) {
 	// Render the crawl_status template, which displays the toast
-	tmpl, err := template.ParseFiles("internal/html/templates/crawl_status_toast.gohtml")
+	tmpl, err := parseTemplate(r.Context(), "internal/html/templates/crawl_status_toast.gohtml")
 	if err != nil {
-		log.Default().Println(err)
+		slog.ErrorContext(r.Context(), "could not parse template", "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	toast := &Toast{ToastContent: message, Border: "border-green-200"}
-	err = tmpl.Execute(w, toast)
+	err = executeTemplate(r.Context(), tmpl, w, toast)
 	if err != nil {
-		log.Default().Println(err)
+		slog.ErrorContext(r.Context(), "could not render template", "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 	return
 }
 
-func checkIfUserLoggedIn(r *http.Request, w http.ResponseWriter, m *CrawlMaster) error {
-	uuidToken, err := getRequestCookie(r, "uuid")
-	if err != nil {
-		return fmt.Errorf("User is not logged in")
+// Read the pagination, sorting and filter options for a results view from the request
+func parseResultsFilter(r *http.Request) db.ResultsFilter {
+	r.ParseForm()
+	filter := db.ResultsFilter{
+		Cursor:    r.FormValue("cursor"),
+		SortBy:    r.FormValue("sort"),
+		Ascending: r.FormValue("order") == "asc",
+		Domain:    strings.TrimSpace(r.FormValue("domain")),
+		Referrer:  strings.TrimSpace(r.FormValue("referrer")),
 	}
-	sessionToken, err := getRequestCookie(r, "session_token")
-	if err != nil {
-		return fmt.Errorf("User is not logged in")
+	if limit, err := strconv.Atoi(r.FormValue("limit")); err == nil {
+		filter.Limit = limit
+	}
+	if blocked, err := strconv.ParseBool(r.FormValue("blocked")); err == nil {
+		filter.IsBlocked = &blocked
+	}
+	if complete, err := strconv.ParseBool(r.FormValue("complete")); err == nil {
+		filter.IsComplete = &complete
 	}
-	if uuidToken == "" || sessionToken == "" {
-		return fmt.Errorf("User is not logged in")
+	if from, err := time.Parse("2006-01-02", r.FormValue("from")); err == nil {
+		filter.From = from
+	}
+	if to, err := time.Parse("2006-01-02", r.FormValue("to")); err == nil {
+		// Include the whole day
+		filter.To = to.Add(24*time.Hour - time.Second)
+	}
+	return filter
+}
+
+// Escape a search snippet, then mark up the matched terms
+func highlightSnippet(snippet string) template.HTML {
+	escaped := template.HTMLEscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, db.SnippetMatchStart, `<mark class="bg-yellow-200 text-gray-900">`)
+	escaped = strings.ReplaceAll(escaped, db.SnippetMatchEnd, "</mark>")
+	return template.HTML(escaped)
+}
+
+// The public address of the app, used to build links in emails.
+// We never build these from the request Host header, so they can't be pointed somewhere else.
+func baseURL() string {
+	if base := os.Getenv("BASE_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	} else if os.Getenv("ENV") == "dev" {
+		return "http://localhost:8080"
+	}
+	return "https://data-manager.ztkent.com"
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
 	}
-	return m.DB.ConfirmUUIDandToken(uuidToken, sessionToken)
+	return host
 }
 
 func getRequestCookie(r *http.Request, name string) (string, error) {
@@ -170,13 +219,34 @@ func clearCookies(w http.ResponseWriter) {
 		Expires: time.Unix(0, 0),
 		Path:    "/",
 	})
+	http.SetCookie(w, &http.Cookie{
+		Name:    "csrf_session",
+		Value:   "",
+		Expires: time.Unix(0, 0),
+		Path:    "/",
+	})
 }
 
 func logForm(r *http.Request) {
 	r.ParseForm()
 	for key, values := range r.Form {
 		for _, value := range values {
-			log.Printf("Form key: %s, value: %s\n", key, value)
+			slog.DebugContext(r.Context(), "form value", "key", key, "value", value)
 		}
 	}
 }
+
+// Templates are parsed on each request, so parsing is traced along with rendering
+func parseTemplate(ctx context.Context, filenames ...string) (*template.Template, error) {
+	_, span := tracing.Start(ctx, "template.parse", attribute.StringSlice("template.files", filenames))
+	tmpl, err := template.ParseFiles(filenames...)
+	tracing.End(span, err)
+	return tmpl, err
+}
+
+func executeTemplate(ctx context.Context, tmpl *template.Template, w io.Writer, data interface{}) error {
+	_, span := tracing.Start(ctx, "template.render", attribute.String("template.name", tmpl.Name()))
+	err := tmpl.Execute(w, data)
+	tracing.End(span, err)
+	return err
+}
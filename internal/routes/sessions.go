@@ -0,0 +1,256 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Ztkent/data-manager/internal/auth"
+	"github.com/Ztkent/data-manager/internal/db"
+)
+
+var errUserDisabled = errors.New("user is disabled")
+
+// Issue a new session for the user and set the cookies for a logged-in user.
+// Logging in during the deletion grace period keeps the account, and claims the visitor's anonymous results.
+func (m *CrawlMaster) startSession(w http.ResponseWriter, r *http.Request, userID string) error {
+	cancelled, err := m.DB.CancelAccountDeletion(r.Context(), userID)
+	if err != nil {
+		return err
+	} else if cancelled {
+		m.notifyAccountEvent(r.Context(), userID, "Account deletion cancelled", "You signed in, so your account will not be deleted.")
+	}
+	err = m.issueSession(w, r, userID, "")
+	if err != nil {
+		return err
+	}
+	_, err = rotateCSRFSession(w)
+	if err != nil {
+		return err
+	}
+	m.audit(r, db.AuditEvent{ActorID: userID, Action: db.AUDIT_LOGIN})
+	// Keep anything the visitor collected before they logged in
+	if anonymousID, err := getRequestCookie(r, "uuid"); err == nil && anonymousID != "" && anonymousID != userID {
+		go m.claimAnonymousData(context.WithoutCancel(r.Context()), anonymousID, userID)
+	}
+	return nil
+}
+
+// Issue a fresh token for an active session, so users who keep using the app stay logged in
+func (m *CrawlMaster) refreshSession(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	err := m.issueSession(w, r, claims.Subject, claims.Id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not issue session", "error", err)
+	}
+}
+
+func (m *CrawlMaster) issueSession(w http.ResponseWriter, r *http.Request, userID string, sessionID string) error {
+	_, disabled, err := m.DB.GetUserAccess(r.Context(), userID)
+	if err != nil {
+		return err
+	} else if disabled {
+		return errUserDisabled
+	}
+	token, claims, err := auth.IssueToken(userID, sessionID)
+	if err != nil {
+		return err
+	}
+	err = m.DB.UpdateUserAuth(r.Context(), userID, claims.Id, token, time.Unix(claims.ExpiresAt, 0), r.UserAgent(), clientIP(r))
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "uuid",
+		Value:    userID,
+		HttpOnly: true,
+		Secure:   true, // Set to true if your site uses HTTPS
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Value:    token,
+		HttpOnly: true,
+		Secure:   true, // Set to true if your site uses HTTPS
+		SameSite: http.SameSiteStrictMode,
+	})
+	return nil
+}
+
+// Revoke the request's session, so its token can't be used again even if it was copied,
+// and remove the cookies. The browser gets a new CSRF session, so tokens from the old session stop working.
+func (m *CrawlMaster) endSession(w http.ResponseWriter, r *http.Request) {
+	m.revokeRequestSession(r)
+	clearCookies(w)
+	_, err := rotateCSRFSession(w)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not create csrf session", "error", err)
+	}
+}
+
+func (m *CrawlMaster) revokeRequestSession(r *http.Request) {
+	sessionToken, err := getRequestCookie(r, "session_token")
+	if err != nil || sessionToken == "" {
+		return
+	}
+	claims, err := auth.ParseToken(sessionToken)
+	if err != nil {
+		return
+	}
+	err = auth.Revoke(r.Context(), m.Redis, claims.Id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not revoke session", "error", err)
+	}
+	err = m.DB.DeleteUserAuth(r.Context(), claims.Subject, claims.Id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not delete user auth", "error", err)
+	}
+	m.audit(r, db.AuditEvent{ActorID: claims.Subject, Action: db.AUDIT_LOGOUT})
+}
+
+// Set a new CSRF session cookie, replacing the browser's current one.
+// The page keeps using the token it was rendered with, so the new token is also sent back in the CSRF header.
+func rotateCSRFSession(w http.ResponseWriter) (string, error) {
+	csrfSession, err := setCSRFSession(w)
+	if err != nil {
+		return "", err
+	}
+	w.Header().Set(auth.CSRF_HEADER, auth.CSRFToken(csrfSession))
+	return csrfSession, nil
+}
+
+func setCSRFSession(w http.ResponseWriter) (string, error) {
+	csrfSession, err := auth.NewCSRFSession()
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     "csrf_session",
+		Value:    csrfSession,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true, // Set to true if your site uses HTTPS
+		SameSite: http.SameSiteStrictMode,
+	})
+	return csrfSession, nil
+}
+
+func (m *CrawlMaster) SessionsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.serveSessions(w, r)
+	}
+}
+
+func (m *CrawlMaster) RevokeSessionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := requestUser(r).ID
+
+		// The current session is ended by logging out
+		sessionID := r.FormValue("id")
+		if sessionID == "" || sessionID == requestUser(r).SessionID {
+			http.Error(w, "Invalid session", http.StatusBadRequest)
+			return
+		}
+		err := m.DB.DeleteUserAuth(r.Context(), userID, sessionID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not delete user auth", "error", err)
+			http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+			return
+		}
+		err = auth.Revoke(r.Context(), m.Redis, sessionID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not revoke session", "error", err)
+		}
+		m.serveSessions(w, r)
+	}
+}
+
+func (m *CrawlMaster) RevokeOtherSessionsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := requestUser(r).ID
+
+		removed, err := m.DB.DeleteOtherSessions(r.Context(), userID, requestUser(r).SessionID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not delete other sessions", "error", err)
+			http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+			return
+		}
+		for _, sessionID := range removed {
+			err = auth.Revoke(r.Context(), m.Redis, sessionID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "could not revoke session", "error", err)
+			}
+		}
+		m.serveSessions(w, r)
+	}
+}
+
+type sessionView struct {
+	db.Session
+	Device  string
+	Current bool
+}
+
+func (m *CrawlMaster) serveSessions(w http.ResponseWriter, r *http.Request) {
+	userID := requestUser(r).ID
+	sessions, err := m.DB.GetSessions(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not get sessions", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	current := requestUser(r).SessionID
+	views := make([]sessionView, 0, len(sessions))
+	for _, s := range sessions {
+		views = append(views, sessionView{Session: s, Device: describeUserAgent(s.UserAgent), Current: s.ID == current})
+	}
+
+	tmpl, err := parseTemplate(r.Context(), "internal/html/templates/sessions.gohtml")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not parse template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = executeTemplate(r.Context(), tmpl, w, views)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not render template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// A short "Browser on OS" label for a user agent
+func describeUserAgent(userAgent string) string {
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	os := "unknown device"
+	for _, o := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, o.token) {
+			os = o.name
+			break
+		}
+	}
+	return browser + " on " + os
+}
@@ -0,0 +1,64 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Ztkent/data-manager/internal/auth"
+)
+
+// Returns the last csrf_session cookie set by the response, which is the one the browser keeps
+func responseCSRFSession(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	session := ""
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "csrf_session" {
+			session = cookie.Value
+		}
+	}
+	if session == "" {
+		t.Fatal("response didn't set a csrf_session cookie")
+	}
+	return session
+}
+
+func TestEndSessionRotatesCSRFSession(t *testing.T) {
+	t.Setenv("JWT_SECRET_TOKEN", "test-secret")
+	old, err := auth.NewCSRFSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &CrawlMaster{}
+
+	r := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	r.AddCookie(&http.Cookie{Name: "csrf_session", Value: old})
+	w := httptest.NewRecorder()
+	m.endSession(w, r)
+
+	session := responseCSRFSession(t, w)
+	if session == old {
+		t.Fatal("csrf session wasn't rotated")
+	}
+	if got := w.Header().Get(auth.CSRF_HEADER); got != auth.CSRFToken(session) {
+		t.Errorf("%s = %q, want the token for the new session", auth.CSRF_HEADER, got)
+	}
+
+	// The page's old token is rejected once the browser holds the new session
+	handler := m.VerifyCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for token, want := range map[string]int{
+		auth.CSRFToken(old):     http.StatusForbidden,
+		auth.CSRFToken(session): http.StatusOK,
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/crawl", nil)
+		r.AddCookie(&http.Cookie{Name: "csrf_session", Value: session})
+		r.Header.Set(auth.CSRF_HEADER, token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("status = %d, want %d", w.Code, want)
+		}
+	}
+}
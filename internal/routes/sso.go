@@ -0,0 +1,227 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Ztkent/data-manager/internal/sso"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const OIDC_STATE_TTL = 10 * time.Minute // How long a user has to finish signing in with their provider
+
+// Errors shown in the login modal when single sign-on fails, keyed by the code we redirect home with
+var ssoErrors = map[string]string{
+	"failed":     "Single sign-on failed, please try again",
+	"cancelled":  "Single sign-on was cancelled",
+	"no_email":   "Your identity provider didn't share a verified email",
+	"unverified": "An account already uses this email. Log in with your password and verify your email to use single sign-on.",
+	"disabled":   "This account has been disabled",
+}
+
+func (m *CrawlMaster) ssoProvider(name string) *sso.Provider {
+	for _, provider := range m.SSO {
+		if provider.Name == name {
+			return provider
+		}
+	}
+	return nil
+}
+
+func ssoRedirectURL(provider *sso.Provider) string {
+	return baseURL() + "/oidc/" + provider.Name + "/callback"
+}
+
+// Send the user home, with the login modal explaining what went wrong
+func ssoFailed(w http.ResponseWriter, r *http.Request, code string) {
+	http.Redirect(w, r, "/?sso_error="+url.QueryEscape(code), http.StatusSeeOther)
+}
+
+// SSOLoginHandler sends the user to their identity provider to sign in.
+func (m *CrawlMaster) SSOLoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider := m.ssoProvider(chi.URLParam(r, "provider"))
+		if provider == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if m.Redis == nil {
+			ssoFailed(w, r, "failed")
+			return
+		}
+
+		state, login, err := sso.NewLoginState(provider.Name)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not create login state", "error", err)
+			ssoFailed(w, r, "failed")
+			return
+		}
+		data, err := json.Marshal(login)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not marshal oidc state", "error", err)
+			ssoFailed(w, r, "failed")
+			return
+		}
+		err = m.Redis.Set(r.Context(), "oidc_state:"+state, data, OIDC_STATE_TTL).Err()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not save oidc state", "error", err)
+			ssoFailed(w, r, "failed")
+			return
+		}
+
+		// Tie the state to this browser, so nobody can finish a sign in they started somewhere else.
+		// The provider redirects back from another site, so the cookie can't be SameSite strict.
+		http.SetCookie(w, &http.Cookie{
+			Name:     "oidc_state",
+			Value:    state,
+			Path:     "/oidc/",
+			MaxAge:   int(OIDC_STATE_TTL.Seconds()),
+			HttpOnly: true,
+			Secure:   true, // Set to true if your site uses HTTPS
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, provider.AuthCodeURL(ssoRedirectURL(provider), state, login), http.StatusFound)
+	}
+}
+
+// SSOCallbackHandler finishes signing in once the provider sends the user back.
+// The identity is matched to the user it's linked to, or to a verified account with the same email.
+// Otherwise we create a new user for it.
+func (m *CrawlMaster) SSOCallbackHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider := m.ssoProvider(chi.URLParam(r, "provider"))
+		if provider == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("error") != "" {
+			ssoFailed(w, r, "cancelled")
+			return
+		}
+
+		state := r.URL.Query().Get("state")
+		cookieState, err := getRequestCookie(r, "oidc_state")
+		if err != nil || state == "" || cookieState != state || m.Redis == nil {
+			ssoFailed(w, r, "failed")
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:    "oidc_state",
+			Value:   "",
+			Path:    "/oidc/",
+			Expires: time.Unix(0, 0),
+		})
+		// Each state can only be used once
+		data, err := m.Redis.GetDel(r.Context(), "oidc_state:"+state).Bytes()
+		if err != nil {
+			slog.WarnContext(r.Context(), "could not find oidc state", "error", err)
+			ssoFailed(w, r, "failed")
+			return
+		}
+		var login sso.LoginState
+		if err := json.Unmarshal(data, &login); err != nil || login.Provider != provider.Name {
+			ssoFailed(w, r, "failed")
+			return
+		}
+
+		identity, err := provider.Exchange(r.Context(), ssoRedirectURL(provider), r.URL.Query().Get("code"), login)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not exchange oidc code", "error", err)
+			ssoFailed(w, r, "failed")
+			return
+		}
+
+		userID, err := m.DB.GetUserIDByIdentity(r.Context(), identity.Issuer, identity.Subject)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not get user id by identity", "error", err)
+			ssoFailed(w, r, "failed")
+			return
+		}
+		if userID == "" {
+			var code string
+			userID, code, err = m.linkIdentity(r.Context(), identity)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "could not link identity", "error", err)
+				ssoFailed(w, r, code)
+				return
+			}
+		}
+
+		// Users with 2FA enabled still need to enter a code, the home page shows the prompt for the challenge.
+		// The challenge proves the first factor passed, so it's kept in a cookie rather than the URL.
+		totp, err := m.DB.GetTOTP(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not get totp", "error", err)
+			ssoFailed(w, r, "failed")
+			return
+		} else if totp.Enabled {
+			challenge, err := m.newLoginChallenge(r.Context(), userID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "could not start login challenge", "error", err)
+				ssoFailed(w, r, "failed")
+				return
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     "two_factor_challenge",
+				Value:    challenge,
+				Path:     "/",
+				MaxAge:   int(LOGIN_CHALLENGE_TTL.Seconds()),
+				HttpOnly: true,
+				Secure:   true, // Set to true if your site uses HTTPS
+				SameSite: http.SameSiteLaxMode,
+			})
+			http.Redirect(w, r, "/?two_factor=1", http.StatusSeeOther)
+			return
+		}
+
+		err = m.startSession(w, r, userID)
+		if err == errUserDisabled {
+			ssoFailed(w, r, "disabled")
+			return
+		} else if err != nil {
+			slog.ErrorContext(r.Context(), "could not start session", "error", err)
+			ssoFailed(w, r, "failed")
+			return
+		}
+		m.notifyAccountEvent(r.Context(), userID, "New sign-in", fmt.Sprintf(
+			"Your account signed in with %s at %s from %s (%s).", provider.DisplayName, time.Now().UTC().Format("2006-01-02 15:04:05 MST"), r.RemoteAddr, r.UserAgent()))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
+
+// Link a new identity to the account with the same email, or create an account for it.
+// On failure it also returns the error code to show the user.
+func (m *CrawlMaster) linkIdentity(ctx context.Context, identity sso.Identity) (string, string, error) {
+	if identity.Email == "" || !identity.EmailVerified {
+		return "", "no_email", fmt.Errorf("oidc identity %s has no verified email", identity.Subject)
+	}
+
+	userID, err := m.DB.GetUserIDByEmail(ctx, identity.Email)
+	if err == nil {
+		// Only link accounts that proved they own the email, so an account registered with someone else's address isn't handed their identity
+		verified, err := m.DB.IsEmailVerified(ctx, userID)
+		if err != nil {
+			return "", "failed", err
+		} else if !verified {
+			return "", "unverified", fmt.Errorf("user %s has not verified their email", userID)
+		}
+	} else {
+		userID = uuid.New().String()
+		err = m.DB.CreateSSOUser(ctx, userID, identity.Email)
+		if err != nil {
+			return "", "failed", err
+		}
+	}
+
+	err = m.DB.LinkIdentity(ctx, userID, identity.Issuer, identity.Subject, identity.Email)
+	if err != nil {
+		return "", "failed", err
+	}
+	return userID, "", nil
+}
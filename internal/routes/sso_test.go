@@ -0,0 +1,279 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ztkent/data-manager/internal/db"
+	"github.com/Ztkent/data-manager/internal/sso"
+	"github.com/Ztkent/data-manager/internal/sso/ssotest"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-redis/redis/v8"
+)
+
+// Accounts by email, and the identities linked to them
+type ssoDB struct {
+	db.MasterDatabase
+	users      map[string]string // User id by email
+	verified   map[string]bool   // Verified emails, by user id
+	identities map[string]string // User id by issuer and subject
+	totp       bool              // Whether users have 2FA enabled
+}
+
+func newSSODB() *ssoDB {
+	return &ssoDB{users: make(map[string]string), verified: make(map[string]bool), identities: make(map[string]string)}
+}
+
+func (d *ssoDB) GetUserIDByIdentity(ctx context.Context, issuer, subject string) (string, error) {
+	return d.identities[issuer+" "+subject], nil
+}
+
+func (d *ssoDB) GetUserIDByEmail(ctx context.Context, email string) (string, error) {
+	if userID, ok := d.users[email]; ok {
+		return userID, nil
+	}
+	return "", sql.ErrNoRows
+}
+
+func (d *ssoDB) IsEmailVerified(ctx context.Context, userID string) (bool, error) {
+	return d.verified[userID], nil
+}
+
+func (d *ssoDB) CreateSSOUser(ctx context.Context, userID, email string) error {
+	d.users[email] = userID
+	d.verified[userID] = true
+	return nil
+}
+
+func (d *ssoDB) LinkIdentity(ctx context.Context, userID, issuer, subject, email string) error {
+	d.identities[issuer+" "+subject] = userID
+	return nil
+}
+
+func (d *ssoDB) GetTOTP(ctx context.Context, userID string) (db.TOTP, error) {
+	return db.TOTP{Enabled: d.totp}, nil
+}
+
+func (d *ssoDB) CancelAccountDeletion(ctx context.Context, userID string) (bool, error) {
+	return false, nil
+}
+
+func (d *ssoDB) GetUserAccess(ctx context.Context, userID string) (string, bool, error) {
+	return "member", false, nil
+}
+
+func (d *ssoDB) UpdateUserAuth(ctx context.Context, userID, sessionID, token string, expiresAt time.Time, userAgent, ipAddress string) error {
+	return nil
+}
+
+func (d *ssoDB) RecordAuditEvent(ctx context.Context, event db.AuditEvent) error {
+	return nil
+}
+
+type ssoTest struct {
+	issuer *ssotest.Issuer
+	db     *ssoDB
+	master *CrawlMaster
+	router *chi.Mux
+}
+
+func newSSOTest(t *testing.T) *ssoTest {
+	t.Helper()
+	t.Setenv("JWT_SECRET_TOKEN", "test-secret")
+	t.Setenv("BASE_URL", "https://data-manager.test")
+	issuer := ssotest.NewIssuer(t, "client")
+	issuer.Env(t, "mock")
+	server := miniredis.RunT(t)
+
+	database := newSSODB()
+	m := &CrawlMaster{
+		DB:    database,
+		Redis: redis.NewClient(&redis.Options{Addr: server.Addr()}),
+		SSO:   sso.NewProvidersFromEnv(context.Background()),
+	}
+	router := chi.NewRouter()
+	router.Get("/oidc/{provider}/login", m.SSOLoginHandler())
+	router.Get("/oidc/{provider}/callback", m.SSOCallbackHandler())
+	router.Post("/login", m.Login())
+	return &ssoTest{issuer: issuer, db: database, master: m, router: router}
+}
+
+// Starts signing in, and returns the provider's authorization URL and the cookies set for the browser
+func (s *ssoTest) login(t *testing.T) (string, []*http.Cookie) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/oidc/mock/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login status = %d, want %d", w.Code, http.StatusFound)
+	}
+	return w.Header().Get("Location"), w.Result().Cookies()
+}
+
+// Signs in at the provider, and returns the callback request it sends the browser back with
+func (s *ssoTest) authorize(t *testing.T, authURL string, cookies []*http.Cookie) *http.Request {
+	t.Helper()
+	callback, err := s.issuer.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+// Returns where the callback sends the browser
+func (s *ssoTest) callback(t *testing.T, r *http.Request) string {
+	t.Helper()
+	return s.callbackResponse(t, r).Header().Get("Location")
+}
+
+func (s *ssoTest) callbackResponse(t *testing.T, r *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("callback status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	return w
+}
+
+func TestSSOCallbackRejectsReplayedState(t *testing.T) {
+	s := newSSOTest(t)
+	authURL, cookies := s.login(t)
+	if got := s.callback(t, s.authorize(t, authURL, cookies)); got != "/" {
+		t.Fatalf("first callback redirected to %q, want /", got)
+	}
+
+	// A new code for the same state, so only the used state stops it
+	if got := s.callback(t, s.authorize(t, authURL, cookies)); got != "/?sso_error=failed" {
+		t.Errorf("replayed state redirected to %q, want /?sso_error=failed", got)
+	}
+}
+
+func TestSSOCallbackRequiresStateCookie(t *testing.T) {
+	s := newSSOTest(t)
+	authURL, _ := s.login(t)
+	if got := s.callback(t, s.authorize(t, authURL, nil)); got != "/?sso_error=failed" {
+		t.Errorf("callback without the state cookie redirected to %q, want /?sso_error=failed", got)
+	}
+}
+
+func TestSSOCallbackLinksVerifiedEmails(t *testing.T) {
+	tests := []struct {
+		name          string
+		emailVerified bool   // Whether the provider verified the email
+		account       string // An existing account with the email, if any
+		accountVerify bool   // Whether the account verified the email
+		wantRedirect  string
+		wantLinked    string // The account the identity is linked to, "new" for a new account
+	}{
+		{"new account", true, "", false, "/", "new"},
+		{"verified account", true, "existing", true, "/", "existing"},
+		{"unverified account", true, "existing", false, "/?sso_error=unverified", ""},
+		{"unverified provider email", false, "", false, "/?sso_error=no_email", ""},
+		{"unverified provider email for an account", false, "existing", true, "/?sso_error=no_email", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSSOTest(t)
+			s.issuer.User.EmailVerified = tt.emailVerified
+			if tt.account != "" {
+				s.db.users[s.issuer.User.Email] = tt.account
+				s.db.verified[tt.account] = tt.accountVerify
+			}
+
+			authURL, cookies := s.login(t)
+			if got := s.callback(t, s.authorize(t, authURL, cookies)); got != tt.wantRedirect {
+				t.Errorf("callback redirected to %q, want %q", got, tt.wantRedirect)
+			}
+			linked := s.db.identities[s.issuer.URL+" "+s.issuer.User.Subject]
+			switch tt.wantLinked {
+			case "":
+				if linked != "" {
+					t.Errorf("identity was linked to %q", linked)
+				}
+			case "new":
+				if linked == "" || linked == tt.account {
+					t.Errorf("identity was linked to %q, want a new account", linked)
+				}
+			default:
+				if linked != tt.wantLinked {
+					t.Errorf("identity was linked to %q, want %q", linked, tt.wantLinked)
+				}
+			}
+		})
+	}
+}
+
+func TestSSOLoginUnknownProvider(t *testing.T) {
+	s := newSSOTest(t)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/oidc/other/login", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestSSOCallbackKeepsTwoFactorChallengeOutOfURL(t *testing.T) {
+	s := newSSOTest(t)
+	s.db.totp = true
+	authURL, cookies := s.login(t)
+	w := s.callbackResponse(t, s.authorize(t, authURL, cookies))
+
+	if got := w.Header().Get("Location"); got != "/?two_factor=1" {
+		t.Errorf("callback redirected to %q, want /?two_factor=1", got)
+	}
+	var challenge *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "session_token" {
+			t.Error("session was started before the second factor")
+		} else if cookie.Name == "two_factor_challenge" {
+			challenge = cookie
+		}
+	}
+	if challenge == nil || challenge.Value == "" {
+		t.Fatal("callback didn't set the two_factor_challenge cookie")
+	}
+	if !challenge.HttpOnly || challenge.SameSite != http.SameSiteLaxMode || challenge.MaxAge <= 0 {
+		t.Errorf("two_factor_challenge cookie = %+v, want a short-lived HttpOnly SameSite=Lax cookie", challenge)
+	}
+	if userID, err := s.master.Redis.Get(context.Background(), "login_challenge:"+challenge.Value).Result(); err != nil || userID == "" {
+		t.Errorf("challenge isn't a pending login: %v", err)
+	}
+
+	// The login modal takes the challenge from the cookie and clears it
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader("two_factor=1"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(challenge)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), challenge.Value) {
+		t.Error("two-factor modal doesn't carry the challenge")
+	}
+	cleared := false
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "two_factor_challenge" && cookie.Value == "" && cookie.Expires.Before(time.Now()) {
+			cleared = true
+		}
+	}
+	if !cleared {
+		t.Error("two_factor_challenge cookie wasn't cleared")
+	}
+}
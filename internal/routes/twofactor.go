@@ -0,0 +1,316 @@
+package routes
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Ztkent/data-manager/internal/auth"
+	"github.com/Ztkent/data-manager/internal/db"
+)
+
+const LOGIN_CHALLENGE_TTL = 5 * time.Minute // How long a user has to enter their 2FA code after their password
+const TWO_FACTOR_ATTEMPTS = 5               // 2FA codes a user can try per window
+const TWO_FACTOR_WINDOW = 15 * time.Minute
+
+type twoFactorView struct {
+	Enabled           bool
+	RecoveryCodesLeft int
+	Secret            string   // Set while the user is adding a new secret to their authenticator
+	ProvisioningURI   string   // Set while the user is adding a new secret to their authenticator
+	RecoveryCodes     []string // Only set right after new codes are generated
+	Message           string
+	Error             string
+}
+
+// The login modal step between a correct password and the session cookies, for users with 2FA enabled
+type twoFactorModal struct {
+	Challenge string
+	Error     string
+}
+
+// Remember that the user got their password right, the challenge is exchanged for a session with a valid code
+func (m *CrawlMaster) startLoginChallenge(w http.ResponseWriter, r *http.Request, userID string) error {
+	challenge, err := m.newLoginChallenge(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+	serveTwoFactorModal(w, r, twoFactorModal{Challenge: challenge})
+	return nil
+}
+
+func (m *CrawlMaster) newLoginChallenge(ctx context.Context, userID string) (string, error) {
+	if m.Redis == nil {
+		return "", fmt.Errorf("two-factor login requires redis")
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("could not generate login challenge: %v", err)
+	}
+	challenge := hex.EncodeToString(raw)
+	err := m.Redis.Set(ctx, "login_challenge:"+challenge, userID, LOGIN_CHALLENGE_TTL).Err()
+	if err != nil {
+		return "", fmt.Errorf("could not store login challenge: %v", err)
+	}
+	return challenge, nil
+}
+
+func (m *CrawlMaster) SubmitTwoFactor() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		challenge := r.FormValue("challenge")
+		if challenge == "" || m.Redis == nil {
+			m.serveLoginModal(w, r, "Your login expired, please log in again")
+			return
+		}
+		userID, err := m.Redis.Get(r.Context(), "login_challenge:"+challenge).Result()
+		if err != nil {
+			m.serveLoginModal(w, r, "Your login expired, please log in again")
+			return
+		}
+
+		if !m.allowAttempt(r.Context(), "two_factor:"+userID, TWO_FACTOR_ATTEMPTS, TWO_FACTOR_WINDOW) {
+			serveTwoFactorModal(w, r, twoFactorModal{Challenge: challenge, Error: "Too many attempts, try again later"})
+			return
+		}
+		ok, err := m.checkSecondFactor(r.Context(), userID, r.FormValue("code"))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not check second factor", "error", err)
+			serveTwoFactorModal(w, r, twoFactorModal{Challenge: challenge, Error: "Login Failed"})
+			return
+		} else if !ok {
+			m.recordTwoFactorAttempt(r, userID, false, db.LOGIN_BAD_TWO_FACTOR)
+			serveTwoFactorModal(w, r, twoFactorModal{Challenge: challenge, Error: "Invalid code"})
+			return
+		}
+
+		// Each challenge can only be used for one session
+		deleted, err := m.Redis.Del(r.Context(), "login_challenge:"+challenge).Result()
+		if err != nil || deleted == 0 {
+			m.serveLoginModal(w, r, "Your login expired, please log in again")
+			return
+		}
+		m.recordTwoFactorAttempt(r, userID, true, db.LOGIN_SUCCESS)
+		m.completeLogin(w, r, userID)
+	}
+}
+
+func (m *CrawlMaster) recordTwoFactorAttempt(r *http.Request, userID string, success bool, reason string) {
+	address, err := m.DB.GetUserEmail(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not get user email", "error", err)
+		return
+	}
+	m.recordLoginAttempt(r, address, userID, success, reason)
+}
+
+// Check a code from the user's authenticator, or one of their recovery codes. Neither can be used twice.
+func (m *CrawlMaster) checkSecondFactor(ctx context.Context, userID string, code string) (bool, error) {
+	totp, err := m.DB.GetTOTP(ctx, userID)
+	if err != nil {
+		return false, err
+	} else if !totp.Enabled {
+		return false, nil
+	}
+	if step, ok := auth.ValidateTOTP(totp.Secret, code, time.Now()); ok {
+		return m.DB.UseTOTPStep(ctx, userID, step)
+	}
+	used, err := m.DB.UseRecoveryCode(ctx, userID, auth.HashRecoveryCode(code))
+	if err != nil || !used {
+		return false, err
+	}
+	m.notifyAccountEvent(ctx, userID, "Recovery code used", "One of your two-factor recovery codes was used to sign in or change your two-factor settings.")
+	return true, nil
+}
+
+func (m *CrawlMaster) TwoFactorHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.serveTwoFactor(w, r, twoFactorView{})
+	}
+}
+
+// SetupTwoFactorHandler shows a new secret to add to an authenticator app. 2FA is enabled once the user confirms a code.
+func (m *CrawlMaster) SetupTwoFactorHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := requestUser(r).ID
+
+		secret, err := auth.GenerateTOTPSecret()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not generate totp secret", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = m.DB.SetPendingTOTPSecret(r.Context(), userID, secret)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not set pending totp secret", "error", err)
+			m.serveTwoFactor(w, r, twoFactorView{Error: "Failed to start two-factor setup"})
+			return
+		}
+		m.serveTwoFactorSetup(w, r, secret, "")
+	}
+}
+
+func (m *CrawlMaster) EnableTwoFactorHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := requestUser(r).ID
+
+		totp, err := m.DB.GetTOTP(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not get totp", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if totp.Enabled || totp.Secret == "" {
+			m.serveTwoFactor(w, r, twoFactorView{})
+			return
+		}
+		if !m.allowAttempt(r.Context(), "two_factor:"+userID, TWO_FACTOR_ATTEMPTS, TWO_FACTOR_WINDOW) {
+			m.serveTwoFactorSetup(w, r, totp.Secret, "Too many attempts, try again later")
+			return
+		}
+		step, ok := auth.ValidateTOTP(totp.Secret, r.FormValue("code"), time.Now())
+		if !ok {
+			m.serveTwoFactorSetup(w, r, totp.Secret, "Invalid code, check your authenticator app's clock")
+			return
+		}
+
+		codes, hashes, err := auth.GenerateRecoveryCodes()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not generate recovery codes", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = m.DB.EnableTOTP(r.Context(), userID, step, hashes)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not enable totp", "error", err)
+			m.serveTwoFactorSetup(w, r, totp.Secret, "Failed to enable two-factor authentication")
+			return
+		}
+		m.notifyAccountEvent(r.Context(), userID, "Two-factor authentication enabled", "Signing in to your account now requires a code from your authenticator app.")
+		m.serveTwoFactor(w, r, twoFactorView{RecoveryCodes: codes, Message: "Two-factor authentication is enabled"})
+	}
+}
+
+func (m *CrawlMaster) DisableTwoFactorHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := requestUser(r).ID
+
+		if !m.allowAttempt(r.Context(), "two_factor:"+userID, TWO_FACTOR_ATTEMPTS, TWO_FACTOR_WINDOW) {
+			m.serveTwoFactor(w, r, twoFactorView{Error: "Too many attempts, try again later"})
+			return
+		}
+		ok, err := m.checkSecondFactor(r.Context(), userID, r.FormValue("code"))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not check second factor", "error", err)
+			m.serveTwoFactor(w, r, twoFactorView{Error: "Failed to disable two-factor authentication"})
+			return
+		} else if !ok {
+			m.serveTwoFactor(w, r, twoFactorView{Error: "Invalid code"})
+			return
+		}
+		err = m.DB.DisableTOTP(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not disable totp", "error", err)
+			m.serveTwoFactor(w, r, twoFactorView{Error: "Failed to disable two-factor authentication"})
+			return
+		}
+		m.notifyAccountEvent(r.Context(), userID, "Two-factor authentication disabled", "Signing in to your account no longer requires a code from your authenticator app.")
+		m.serveTwoFactor(w, r, twoFactorView{Message: "Two-factor authentication is disabled"})
+	}
+}
+
+// RecoveryCodesHandler replaces the user's recovery codes, the old ones stop working.
+func (m *CrawlMaster) RecoveryCodesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := requestUser(r).ID
+
+		if !m.allowAttempt(r.Context(), "two_factor:"+userID, TWO_FACTOR_ATTEMPTS, TWO_FACTOR_WINDOW) {
+			m.serveTwoFactor(w, r, twoFactorView{Error: "Too many attempts, try again later"})
+			return
+		}
+		ok, err := m.checkSecondFactor(r.Context(), userID, r.FormValue("code"))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not check second factor", "error", err)
+			m.serveTwoFactor(w, r, twoFactorView{Error: "Failed to generate recovery codes"})
+			return
+		} else if !ok {
+			m.serveTwoFactor(w, r, twoFactorView{Error: "Invalid code"})
+			return
+		}
+		codes, hashes, err := auth.GenerateRecoveryCodes()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not generate recovery codes", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = m.DB.SetRecoveryCodes(r.Context(), userID, hashes)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not set recovery codes", "error", err)
+			m.serveTwoFactor(w, r, twoFactorView{Error: "Failed to generate recovery codes"})
+			return
+		}
+		m.serveTwoFactor(w, r, twoFactorView{RecoveryCodes: codes})
+	}
+}
+
+func (m *CrawlMaster) serveTwoFactorSetup(w http.ResponseWriter, r *http.Request, secret string, message string) {
+	address, err := m.DB.GetUserEmail(r.Context(), requestUser(r).ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not get user email", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	m.serveTwoFactor(w, r, twoFactorView{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(secret, address),
+		Error:           message,
+	})
+}
+
+func (m *CrawlMaster) serveTwoFactor(w http.ResponseWriter, r *http.Request, view twoFactorView) {
+	userID := requestUser(r).ID
+	totp, err := m.DB.GetTOTP(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not get totp", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	view.Enabled = totp.Enabled
+	if view.Enabled {
+		view.RecoveryCodesLeft, err = m.DB.CountRecoveryCodes(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not count recovery codes", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	tmpl, err := parseTemplate(r.Context(), "internal/html/templates/two_factor.gohtml")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not parse template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = executeTemplate(r.Context(), tmpl, w, view)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not render template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func serveTwoFactorModal(w http.ResponseWriter, r *http.Request, modal twoFactorModal) {
+	tmpl, err := parseTemplate(r.Context(), "internal/html/templates/two_factor_modal.gohtml")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not parse template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = executeTemplate(r.Context(), tmpl, w, modal)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not render template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
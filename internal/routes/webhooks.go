@@ -0,0 +1,179 @@
+package routes
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Ztkent/data-manager/internal/db"
+	"github.com/Ztkent/data-manager/internal/webhook"
+)
+
+type webhooksView struct {
+	Hooks      []webhook.Hook
+	Deliveries []db.WebhookDelivery
+	Secret     string // Only set right after a webhook is created
+	Error      string
+}
+
+// Notify each of the user's webhooks about a crawl lifecycle event
+func (m *CrawlMaster) deliverWebhooks(userID string, event string, job *CrawlJob) {
+	if m.Webhooks == nil {
+		return
+	}
+	ctx := job.logContext()
+	hooks, err := m.DB.GetWebhooks(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not get webhooks", "error", err)
+		return
+	}
+	payload := webhook.Payload{
+		Event:       event,
+		JobID:       job.ID,
+		StartingURL: job.Config.StartingURL,
+		OccurredAt:  time.Now().UTC(),
+	}
+	if job.Err != nil {
+		payload.Error = job.Err.Error()
+	}
+	for _, hook := range hooks {
+		go func(hook webhook.Hook) {
+			if err := m.Webhooks.Deliver(hook, payload); err != nil {
+				slog.ErrorContext(ctx, "could not deliver webhook", "webhook_id", hook.ID, "error", err)
+			}
+		}(hook)
+	}
+}
+
+func (m *CrawlMaster) WebhooksHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.serveWebhooks(w, r, webhooksView{})
+	}
+}
+
+func (m *CrawlMaster) CreateWebhookHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := requestUser(r).ID
+
+		hookURL := r.FormValue("url")
+		if ok, message := m.validateWebhookURL(r.Context(), hookURL); !ok {
+			m.serveWebhooks(w, r, webhooksView{Error: message})
+			return
+		}
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not generate webhook secret", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = m.DB.CreateWebhook(r.Context(), userID, hookURL, secret)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not create webhook", "error", err)
+			m.serveWebhooks(w, r, webhooksView{Error: "Failed to create webhook"})
+			return
+		}
+		m.serveWebhooks(w, r, webhooksView{Secret: secret})
+	}
+}
+
+func (m *CrawlMaster) DeleteWebhookHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := requestUser(r).ID
+
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			m.serveWebhooks(w, r, webhooksView{Error: "Invalid webhook"})
+			return
+		}
+		err = m.DB.DeleteWebhook(r.Context(), userID, id)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not delete webhook", "error", err)
+			m.serveWebhooks(w, r, webhooksView{Error: "Failed to delete webhook"})
+			return
+		}
+		m.serveWebhooks(w, r, webhooksView{})
+	}
+}
+
+// TestWebhookHandler sends a single test event, so the result shows up in the delivery log right away.
+func (m *CrawlMaster) TestWebhookHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := requestUser(r).ID
+
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			m.serveWebhooks(w, r, webhooksView{Error: "Invalid webhook"})
+			return
+		}
+		hook, err := m.DB.GetWebhook(r.Context(), userID, id)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not get webhook", "error", err)
+			m.serveWebhooks(w, r, webhooksView{Error: "Webhook not found"})
+			return
+		}
+		err = m.Webhooks.DeliverOnce(hook, webhook.Payload{Event: webhook.Test, OccurredAt: time.Now().UTC()})
+		if err != nil {
+			m.serveWebhooks(w, r, webhooksView{Error: err.Error()})
+			return
+		}
+		m.serveWebhooks(w, r, webhooksView{})
+	}
+}
+
+func (m *CrawlMaster) serveWebhooks(w http.ResponseWriter, r *http.Request, view webhooksView) {
+	userID := requestUser(r).ID
+	var err error
+	view.Hooks, err = m.DB.GetWebhooks(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not get webhooks", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	view.Deliveries, err = m.DB.GetWebhookDeliveries(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not get webhook deliveries", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := parseTemplate(r.Context(), "internal/html/templates/webhooks.gohtml")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not parse template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = executeTemplate(r.Context(), tmpl, w, view)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not render template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (m *CrawlMaster) validateWebhookURL(ctx context.Context, hookURL string) (bool, string) {
+	parsed, err := url.ParseRequestURI(hookURL)
+	if err != nil || parsed.Host == "" {
+		return false, "Invalid webhook URL"
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return false, "Webhook URL must use http or https"
+	}
+	if err := m.Webhooks.CheckHost(ctx, parsed.Hostname()); err != nil {
+		slog.WarnContext(ctx, "rejected webhook url", "error", err)
+		return false, "Webhook URL must resolve to a public address"
+	}
+	return true, ""
+}
+
+func generateWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("could not generate webhook secret: %v", err)
+	}
+	return hex.EncodeToString(secret), nil
+}
@@ -0,0 +1,297 @@
+package routes
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Ztkent/data-manager/internal/auth"
+	"github.com/Ztkent/data-manager/internal/db"
+	"github.com/Ztkent/data-manager/internal/email"
+	"github.com/google/uuid"
+)
+
+const PERSONAL_WORKSPACE_NAME = "Personal"
+const WORKSPACE_INVITES_PER_HOUR = 20 // Invites an owner can send per hour, each one emails the address
+
+type workspacesView struct {
+	Workspaces []db.Workspace // Every workspace the user can switch to, starting with their personal workspace
+	Active     db.Workspace
+	Members    []db.WorkspaceMember // Only set for owners of a team workspace
+	Pending    []db.WorkspaceInvite // Invites to the active workspace, only set for owners of a team workspace
+	Invites    []db.WorkspaceInvite // Invites the user can accept
+	Roles      []string
+	UserID     string
+	Message    string
+	Error      string
+}
+
+func (m *CrawlMaster) WorkspacesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.serveWorkspaces(w, r, workspacesView{})
+	}
+}
+
+// CreateWorkspaceHandler creates a team workspace owned by the user, and switches to it.
+func (m *CrawlMaster) CreateWorkspaceHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := requestUser(r).ID
+
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" || len(name) > 255 {
+			m.serveWorkspaces(w, r, workspacesView{Error: "Workspace names must be 1-255 characters"})
+			return
+		}
+		workspaceID := uuid.New().String()
+		err := m.DB.CreateWorkspace(r.Context(), workspaceID, name, userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not create workspace", "error", err)
+			m.serveWorkspaces(w, r, workspacesView{Error: "Failed to create workspace"})
+			return
+		}
+		switchWorkspace(w, workspaceID)
+	}
+}
+
+// SwitchWorkspaceHandler changes the workspace this browser acts on, and reloads the page to show its results.
+func (m *CrawlMaster) SwitchWorkspaceHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := requestUser(r).ID
+
+		workspaceID := r.FormValue("id")
+		if workspaceID != userID {
+			role, err := m.DB.GetWorkspaceRole(r.Context(), workspaceID, userID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "could not get workspace role", "error", err)
+				m.serveWorkspaces(w, r, workspacesView{Error: "Failed to switch workspace"})
+				return
+			} else if role == "" {
+				m.serveWorkspaces(w, r, workspacesView{Error: "Not a member of this workspace"})
+				return
+			}
+		}
+		switchWorkspace(w, workspaceID)
+	}
+}
+
+// SetWorkspaceMemberHandler lets owners invite someone to the active workspace by email, or change a member's role.
+// Invites get the same response whether or not the email has an account, and only take effect once accepted.
+func (m *CrawlMaster) SetWorkspaceMemberHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		if user.WorkspaceID == user.ID || user.WorkspaceRole != auth.RoleOwner {
+			m.serveWorkspaces(w, r, workspacesView{Error: "Only owners of a team workspace can manage its members"})
+			return
+		}
+
+		role := r.FormValue("role")
+		if !auth.ValidWorkspaceRole(role) {
+			m.serveWorkspaces(w, r, workspacesView{Error: "Invalid role"})
+			return
+		}
+		memberID := r.FormValue("user_id")
+		if memberID == "" {
+			m.inviteWorkspaceMember(w, r, strings.TrimSpace(r.FormValue("email")), role)
+			return
+		}
+		err := m.DB.SetWorkspaceMember(r.Context(), user.WorkspaceID, memberID, role)
+		if err == db.ErrLastWorkspaceOwner {
+			m.serveWorkspaces(w, r, workspacesView{Error: "A workspace needs at least one owner"})
+			return
+		} else if err != nil {
+			slog.ErrorContext(r.Context(), "could not set workspace member", "error", err)
+			m.serveWorkspaces(w, r, workspacesView{Error: "Failed to update member"})
+			return
+		}
+		m.serveWorkspaces(w, r, workspacesView{Message: "Members updated"})
+	}
+}
+
+func (m *CrawlMaster) inviteWorkspaceMember(w http.ResponseWriter, r *http.Request, address string, role string) {
+	user := requestUser(r)
+	if !validateEmail(address) {
+		m.serveWorkspaces(w, r, workspacesView{Error: "Invalid email"})
+		return
+	}
+	if !m.allowAttempt(r.Context(), "workspace_invite:"+user.ID, WORKSPACE_INVITES_PER_HOUR, time.Hour) {
+		m.serveWorkspaces(w, r, workspacesView{Error: "Too many invites, try again later"})
+		return
+	}
+	err := m.DB.InviteWorkspaceMember(r.Context(), user.WorkspaceID, address, role, user.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not invite workspace member", "error", err)
+		m.serveWorkspaces(w, r, workspacesView{Error: "Failed to invite member"})
+		return
+	}
+	name := ""
+	workspaces, err := m.DB.GetWorkspaces(r.Context(), user.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not get workspaces", "error", err)
+	}
+	for _, workspace := range workspaces {
+		if workspace.ID == user.WorkspaceID {
+			name = workspace.Name
+		}
+	}
+	m.sendEmail(email.WorkspaceInviteMessage(address, name, baseURL()))
+	m.serveWorkspaces(w, r, workspacesView{Message: "Invite sent to " + address})
+}
+
+// AcceptWorkspaceInviteHandler adds the user to a workspace they were invited to, and switches to it.
+// Only verified emails can accept, so an account registered with someone else's address can't take their invites.
+func (m *CrawlMaster) AcceptWorkspaceInviteHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := requestUser(r).ID
+
+		verified, err := m.DB.IsEmailVerified(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not check email verification", "error", err)
+			m.serveWorkspaces(w, r, workspacesView{Error: "Failed to accept invite"})
+			return
+		} else if !verified {
+			m.serveWorkspaces(w, r, workspacesView{Error: "Verify your email to accept invites"})
+			return
+		}
+		workspaceID := r.FormValue("id")
+		err = m.DB.AcceptWorkspaceInvite(r.Context(), workspaceID, userID)
+		if err == db.ErrInviteNotFound {
+			m.serveWorkspaces(w, r, workspacesView{Error: "Invite not found"})
+			return
+		} else if err != nil {
+			slog.ErrorContext(r.Context(), "could not accept workspace invite", "error", err)
+			m.serveWorkspaces(w, r, workspacesView{Error: "Failed to accept invite"})
+			return
+		}
+		switchWorkspace(w, workspaceID)
+	}
+}
+
+// DeclineWorkspaceInviteHandler removes an invite to the user.
+func (m *CrawlMaster) DeclineWorkspaceInviteHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := requestUser(r).ID
+
+		address, err := m.DB.GetUserEmail(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not get user email", "error", err)
+			m.serveWorkspaces(w, r, workspacesView{Error: "Failed to decline invite"})
+			return
+		}
+		err = m.DB.DeleteWorkspaceInvite(r.Context(), r.FormValue("id"), address)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not delete workspace invite", "error", err)
+			m.serveWorkspaces(w, r, workspacesView{Error: "Failed to decline invite"})
+			return
+		}
+		m.serveWorkspaces(w, r, workspacesView{Message: "Invite declined"})
+	}
+}
+
+// CancelWorkspaceInviteHandler lets owners withdraw an invite to the active workspace.
+func (m *CrawlMaster) CancelWorkspaceInviteHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		if user.WorkspaceID == user.ID || user.WorkspaceRole != auth.RoleOwner {
+			m.serveWorkspaces(w, r, workspacesView{Error: "Only owners of a team workspace can manage its members"})
+			return
+		}
+
+		err := m.DB.DeleteWorkspaceInvite(r.Context(), user.WorkspaceID, r.FormValue("email"))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not delete workspace invite", "error", err)
+			m.serveWorkspaces(w, r, workspacesView{Error: "Failed to cancel invite"})
+			return
+		}
+		m.serveWorkspaces(w, r, workspacesView{Message: "Invite cancelled"})
+	}
+}
+
+// RemoveWorkspaceMemberHandler lets owners remove members from the active workspace, and anyone leave it.
+func (m *CrawlMaster) RemoveWorkspaceMemberHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		memberID := r.FormValue("user_id")
+		if user.WorkspaceID == user.ID || (memberID != user.ID && user.WorkspaceRole != auth.RoleOwner) {
+			m.serveWorkspaces(w, r, workspacesView{Error: "Only owners of a team workspace can manage its members"})
+			return
+		}
+
+		err := m.DB.RemoveWorkspaceMember(r.Context(), user.WorkspaceID, memberID)
+		if err == db.ErrLastWorkspaceOwner {
+			m.serveWorkspaces(w, r, workspacesView{Error: "A workspace needs at least one owner"})
+			return
+		} else if err != nil {
+			slog.ErrorContext(r.Context(), "could not remove workspace member", "error", err)
+			m.serveWorkspaces(w, r, workspacesView{Error: "Failed to remove member"})
+			return
+		}
+		if memberID == user.ID {
+			switchWorkspace(w, user.ID)
+			return
+		}
+		m.serveWorkspaces(w, r, workspacesView{Message: "Members updated"})
+	}
+}
+
+// Remember the workspace in a cookie, and reload the page so everything shows the new workspace
+func switchWorkspace(w http.ResponseWriter, workspaceID string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "workspace",
+		Value:    workspaceID,
+		HttpOnly: true,
+		Secure:   true, // Set to true if your site uses HTTPS
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.Header().Set("HX-Refresh", "true")
+}
+
+func (m *CrawlMaster) serveWorkspaces(w http.ResponseWriter, r *http.Request, view workspacesView) {
+	user := requestUser(r)
+	teams, err := m.DB.GetWorkspaces(r.Context(), user.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not get workspaces", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	view.UserID = user.ID
+	view.Roles = auth.WorkspaceRoles
+	view.Workspaces = append([]db.Workspace{{ID: user.ID, Name: PERSONAL_WORKSPACE_NAME, Role: auth.RoleOwner}}, teams...)
+	for _, workspace := range view.Workspaces {
+		if workspace.ID == user.WorkspaceID {
+			view.Active = workspace
+		}
+	}
+	view.Invites, err = m.DB.GetUserWorkspaceInvites(r.Context(), user.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not get workspace invites", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user.WorkspaceID != user.ID && user.WorkspaceRole == auth.RoleOwner {
+		view.Members, err = m.DB.GetWorkspaceMembers(r.Context(), user.WorkspaceID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not get workspace members", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		view.Pending, err = m.DB.GetWorkspaceInvites(r.Context(), user.WorkspaceID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not get workspace invites", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	tmpl, err := parseTemplate(r.Context(), "internal/html/templates/workspaces.gohtml")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not parse template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = executeTemplate(r.Context(), tmpl, w, view)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not render template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,138 @@
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// An OIDC identity provider users can sign in with
+type Provider struct {
+	Name        string // Used in the provider's login and callback URLs
+	DisplayName string
+	Issuer      string
+	config      oauth2.Config
+	verifier    *oidc.IDTokenVerifier
+}
+
+// The external identity a provider vouched for
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// The values we keep between sending the user to their provider and the provider sending them back
+type LoginState struct {
+	Provider string `json:"provider"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// NewProvidersFromEnv discovers each provider listed in OIDC_PROVIDERS, a comma separated list of names.
+// Each provider is configured with OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET,
+// and optionally OIDC_<NAME>_DISPLAY_NAME. Providers that can't be discovered are skipped.
+func NewProvidersFromEnv(ctx context.Context) []*Provider {
+	var providers []*Provider
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		issuer := os.Getenv(prefix + "ISSUER")
+		clientID := os.Getenv(prefix + "CLIENT_ID")
+		if issuer == "" || clientID == "" {
+			slog.Warn("oidc provider needs an issuer and client id", "provider", name, "env_prefix", prefix)
+			continue
+		}
+		discovered, err := oidc.NewProvider(ctx, issuer)
+		if err != nil {
+			slog.Warn("could not discover oidc issuer", "provider", name, "error", err)
+			continue
+		}
+		displayName := os.Getenv(prefix + "DISPLAY_NAME")
+		if displayName == "" {
+			displayName = strings.ToUpper(name[:1]) + name[1:]
+		}
+		providers = append(providers, &Provider{
+			Name:        name,
+			DisplayName: displayName,
+			Issuer:      issuer,
+			config: oauth2.Config{
+				ClientID:     clientID,
+				ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+				Endpoint:     discovered.Endpoint(),
+				Scopes:       []string{oidc.ScopeOpenID, "email"},
+			},
+			verifier: discovered.Verifier(&oidc.Config{ClientID: clientID}),
+		})
+	}
+	return providers
+}
+
+// NewLoginState returns a random state value, and the nonce and PKCE verifier to check the provider's response with.
+func NewLoginState(provider string) (string, LoginState, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", LoginState{}, fmt.Errorf("could not generate oidc state: %v", err)
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", LoginState{}, fmt.Errorf("could not generate oidc nonce: %v", err)
+	}
+	return hex.EncodeToString(raw), LoginState{
+		Provider: provider,
+		Nonce:    hex.EncodeToString(nonce),
+		Verifier: oauth2.GenerateVerifier(),
+	}, nil
+}
+
+// AuthCodeURL is where we send the user to sign in, using the authorization code flow with PKCE.
+func (p *Provider) AuthCodeURL(redirectURL string, state string, login LoginState) string {
+	config := p.config
+	config.RedirectURL = redirectURL
+	return config.AuthCodeURL(state, oidc.Nonce(login.Nonce), oauth2.S256ChallengeOption(login.Verifier))
+}
+
+// Exchange trades the authorization code for an ID token, and returns the identity once the token is verified.
+func (p *Provider) Exchange(ctx context.Context, redirectURL string, code string, login LoginState) (Identity, error) {
+	config := p.config
+	config.RedirectURL = redirectURL
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(login.Verifier))
+	if err != nil {
+		return Identity{}, fmt.Errorf("could not exchange oidc code: %v", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return Identity{}, fmt.Errorf("oidc token response has no id_token")
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return Identity{}, fmt.Errorf("could not verify oidc id_token: %v", err)
+	}
+	if idToken.Nonce != login.Nonce {
+		return Identity{}, fmt.Errorf("oidc id_token nonce does not match")
+	}
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return Identity{}, fmt.Errorf("could not parse oidc claims: %v", err)
+	}
+	return Identity{
+		Issuer:        idToken.Issuer,
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+	}, nil
+}
@@ -0,0 +1,132 @@
+package sso
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Ztkent/data-manager/internal/sso/ssotest"
+)
+
+const redirectURL = "https://data-manager.test/oidc/mock/callback"
+
+func newTestProvider(t *testing.T) (*ssotest.Issuer, *Provider) {
+	t.Helper()
+	issuer := ssotest.NewIssuer(t, "client")
+	issuer.Env(t, "mock")
+	providers := NewProvidersFromEnv(context.Background())
+	if len(providers) != 1 {
+		t.Fatalf("discovered %d providers, want 1", len(providers))
+	}
+	return issuer, providers[0]
+}
+
+// Signs in at the issuer, and returns the code it sent back
+func authorize(t *testing.T, issuer *ssotest.Issuer, provider *Provider, state string, login LoginState) string {
+	t.Helper()
+	callback, err := issuer.Authorize(provider.AuthCodeURL(redirectURL, state, login))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := callback.Query().Get("state"); got != state {
+		t.Fatalf("callback state = %q, want %q", got, state)
+	}
+	return callback.Query().Get("code")
+}
+
+func TestAuthCodeURLUsesPKCE(t *testing.T) {
+	_, provider := newTestProvider(t)
+	state, login, err := NewLoginState("mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := url.Parse(provider.AuthCodeURL(redirectURL, state, login))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authURL.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Errorf("authorization url has no S256 challenge: %s", authURL)
+	}
+	if strings.Contains(authURL.String(), login.Verifier) {
+		t.Error("authorization url contains the PKCE verifier")
+	}
+	if query.Get("nonce") != login.Nonce || query.Get("state") != state {
+		t.Errorf("authorization url nonce or state don't match: %s", authURL)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	issuer, provider := newTestProvider(t)
+	state, login, err := NewLoginState("mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := authorize(t, issuer, provider, state, login)
+
+	identity, err := provider.Exchange(context.Background(), redirectURL, code, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Identity{Issuer: issuer.URL, Subject: "subject", Email: "user@example.com", EmailVerified: true}
+	if identity != want {
+		t.Errorf("Exchange() = %+v, want %+v", identity, want)
+	}
+
+	// The code was used up by the first exchange
+	if _, err := provider.Exchange(context.Background(), redirectURL, code, login); err == nil {
+		t.Error("code was exchanged twice")
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	issuer, provider := newTestProvider(t)
+	state, login, err := NewLoginState("mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := authorize(t, issuer, provider, state, login)
+
+	_, other, err := NewLoginState("mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	login.Verifier = other.Verifier
+	if _, err := provider.Exchange(context.Background(), redirectURL, code, login); err == nil {
+		t.Error("code was exchanged with another login's PKCE verifier")
+	}
+}
+
+func TestExchangeRejectsNonceMismatch(t *testing.T) {
+	issuer, provider := newTestProvider(t)
+	issuer.User.Nonce = "another-nonce"
+	state, login, err := NewLoginState("mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := authorize(t, issuer, provider, state, login)
+
+	_, err = provider.Exchange(context.Background(), redirectURL, code, login)
+	if err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("Exchange() error = %v, want a nonce mismatch", err)
+	}
+}
+
+func TestExchangeReportsUnverifiedEmail(t *testing.T) {
+	issuer, provider := newTestProvider(t)
+	issuer.User.EmailVerified = false
+	state, login, err := NewLoginState("mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := authorize(t, issuer, provider, state, login)
+
+	identity, err := provider.Exchange(context.Background(), redirectURL, code, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.EmailVerified {
+		t.Error("unverified email was reported as verified")
+	}
+}
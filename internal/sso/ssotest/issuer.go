@@ -0,0 +1,178 @@
+// Package ssotest provides a mock OIDC issuer for testing single sign-on.
+package ssotest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+// The user the issuer signs in, and how it answers the next token request
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Nonce         string // Replaces the nonce from the authorization request when set
+}
+
+// Issuer serves OIDC discovery, JWKS, authorization and token endpoints.
+// Its authorization endpoint signs the user in straight away and redirects back with a code.
+type Issuer struct {
+	*httptest.Server
+	ClientID string
+	User     User
+	key      *rsa.PrivateKey
+	codes    map[string]authorization
+	sync.Mutex
+}
+
+type authorization struct {
+	challenge string
+	nonce     string
+}
+
+// NewIssuer starts an issuer for the client id, which is closed when the test finishes.
+func NewIssuer(t testing.TB, clientID string) *Issuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &Issuer{
+		ClientID: clientID,
+		User:     User{Subject: "subject", Email: "user@example.com", EmailVerified: true},
+		key:      key,
+		codes:    make(map[string]authorization),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", issuer.discovery)
+	mux.HandleFunc("/jwks", issuer.jwks)
+	mux.HandleFunc("/authorize", issuer.authorize)
+	mux.HandleFunc("/token", issuer.token)
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+// Env configures the issuer as the only provider read by sso.NewProvidersFromEnv, under the name.
+func (i *Issuer) Env(t testing.TB, name string) {
+	prefix := "OIDC_" + strings.ToUpper(name) + "_"
+	t.Setenv("OIDC_PROVIDERS", name)
+	t.Setenv(prefix+"ISSUER", i.URL)
+	t.Setenv(prefix+"CLIENT_ID", i.ClientID)
+	t.Setenv(prefix+"CLIENT_SECRET", "secret")
+}
+
+// Authorize follows an authorization URL like a browser would, and returns the callback URL with the code and state.
+func (i *Issuer) Authorize(authURL string) (*url.URL, error) {
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp.Location()
+}
+
+func (i *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                i.URL,
+		"authorization_endpoint":                i.URL + "/authorize",
+		"token_endpoint":                        i.URL + "/token",
+		"jwks_uri":                              i.URL + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (i *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &i.key.PublicKey, KeyID: "key", Algorithm: string(jose.RS256), Use: "sig"},
+	}})
+}
+
+func (i *Issuer) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || query.Get("client_id") != i.ClientID || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	code := hex.EncodeToString(raw)
+	i.Lock()
+	i.codes[code] = authorization{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	i.Unlock()
+
+	values := redirect.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirect.RawQuery = values.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// Codes can be used once, and only with the verifier for the challenge they were issued with
+func (i *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	i.Lock()
+	auth, ok := i.codes[r.FormValue("code")]
+	delete(i.codes, r.FormValue("code"))
+	user := i.User
+	i.Unlock()
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != auth.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	nonce := auth.nonce
+	if user.Nonce != "" {
+		nonce = user.Nonce
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: i.key, KeyID: "key"}}, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	idToken, err := jwt.Signed(signer).Claims(map[string]interface{}{
+		"iss":            i.URL,
+		"sub":            user.Subject,
+		"aud":            i.ClientID,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"nonce":          nonce,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+	}).CompactSerialize()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,82 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// Ranges that aren't covered by the net.IP helpers but still aren't the public internet
+var reservedNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),     // "This" network
+	mustParseCIDR("100.64.0.0/10"), // Carrier-grade NAT
+	mustParseCIDR("192.0.0.0/24"),  // IETF protocol assignments
+	mustParseCIDR("198.18.0.0/15"), // Benchmarking
+	mustParseCIDR("240.0.0.0/4"),   // Reserved, including broadcast
+	mustParseCIDR("64:ff9b::/96"),  // NAT64, which can map to any IPv4 address
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// IsPrivateIP reports whether the address is loopback, private, link-local (like the 169.254.169.254 metadata service)
+// or otherwise not on the public internet, and so shouldn't be reachable by a user's webhook.
+func IsPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckHost resolves the host and rejects it if any of its addresses are private, unless local receivers are allowed.
+// The address is checked again when connecting, since the host's DNS may change after this check.
+func (d *Dispatcher) CheckHost(ctx context.Context, host string) error {
+	if d.AllowPrivate {
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("could not resolve webhook host: %v", err)
+	}
+	for _, addr := range addrs {
+		if IsPrivateIP(addr.IP) {
+			return fmt.Errorf("webhook host %s resolves to a private address", host)
+		}
+	}
+	return nil
+}
+
+// Refuses connections to private addresses as the socket is opened, after DNS has been resolved,
+// so a host can't pass CheckHost and then be rebound to an internal address.
+func dialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || IsPrivateIP(ip) {
+		return fmt.Errorf("webhook receiver address %s is private", host)
+	}
+	return nil
+}
+
+func newDialer(allowPrivate bool) *net.Dialer {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = dialControl
+	}
+	return dialer
+}
@@ -0,0 +1,80 @@
+package webhook
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsPrivateIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"10.0.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"fe80::1", true},
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"64:ff9b::a9fe:a9fe", true},
+		{"64:ff9b::808:808", true},
+		{"::ffff:127.0.0.1", true},
+		{"8.8.8.8", false},
+		{"2606:4700::1111", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := IsPrivateIP(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("IsPrivateIP(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDialControl(t *testing.T) {
+	tests := []struct {
+		address string
+		wantErr bool
+	}{
+		{"127.0.0.1:80", true},
+		{"[::1]:443", true},
+		{"169.254.169.254:80", true},
+		{"[64:ff9b::a9fe:a9fe]:80", true},
+		{"not-an-ip:80", true},
+		{"8.8.8.8:443", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			if err := dialControl("tcp", tt.address, nil); (err != nil) != tt.wantErr {
+				t.Errorf("dialControl(%s) error = %v, wantErr %v", tt.address, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPublicDispatcherRefusesLocalReceiver(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer srv.Close()
+
+	d := NewDispatcher(nil, false)
+	if err := d.DeliverOnce(Hook{URL: srv.URL}, Payload{Event: Test}); err == nil {
+		t.Error("delivered to a loopback receiver")
+	}
+	if requests != 0 {
+		t.Errorf("loopback receiver got %d requests", requests)
+	}
+	if err := d.CheckHost(context.Background(), "127.0.0.1"); err == nil {
+		t.Error("CheckHost accepted a loopback address")
+	}
+	if err := NewDispatcher(nil, true).CheckHost(context.Background(), "127.0.0.1"); err != nil {
+		t.Errorf("CheckHost with private receivers allowed = %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Webhook events
+const (
+	CrawlStarted  = "crawl.started"
+	CrawlFinished = "crawl.finished"
+	CrawlFailed   = "crawl.failed"
+	CrawlKilled   = "crawl.killed"
+	Test          = "webhook.test"
+)
+
+const (
+	SignatureHeader = "X-Data-Manager-Signature"
+	TimestampHeader = "X-Data-Manager-Timestamp"
+	EventHeader     = "X-Data-Manager-Event"
+)
+
+type Hook struct {
+	ID        int
+	URL       string
+	Secret    string
+	CreatedAt time.Time
+}
+
+type Payload struct {
+	Event       string    `json:"event"`
+	JobID       string    `json:"job_id,omitempty"`
+	StartingURL string    `json:"starting_url,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	OccurredAt  time.Time `json:"occurred_at"`
+}
+
+// Records the outcome of every delivery attempt
+type DeliveryLog interface {
+	LogWebhookDelivery(webhookID int, event string, payload string, attempt int, statusCode int, deliveryErr string) error
+}
+
+type Dispatcher struct {
+	Client       *http.Client
+	Log          DeliveryLog
+	MaxAttempts  int
+	Backoff      time.Duration // Doubled after each failed attempt
+	AllowPrivate bool          // Allow receivers on private and loopback addresses, for local development
+}
+
+// NewDispatcher delivers to public receivers only, unless allowPrivate is set.
+// Redirects aren't followed, so a receiver can't bounce deliveries to an address we wouldn't connect to.
+func NewDispatcher(log DeliveryLog, allowPrivate bool) *Dispatcher {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = newDialer(allowPrivate).DialContext
+	return &Dispatcher{
+		Client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		Log:          log,
+		MaxAttempts:  5,
+		Backoff:      2 * time.Second,
+		AllowPrivate: allowPrivate,
+	}
+}
+
+// Sign returns the hex HMAC-SHA256 of "<timestamp>.<body>", receivers should recompute it with their secret.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Deliver sends the payload to the hook, retrying with exponential backoff until it succeeds,
+// the receiver rejects it, or we run out of attempts. It blocks, so callers usually run it in a goroutine.
+func (d *Dispatcher) Deliver(hook Hook, payload Payload) error {
+	return d.deliver(hook, payload, d.MaxAttempts)
+}
+
+// DeliverOnce sends the payload to the hook a single time.
+func (d *Dispatcher) DeliverOnce(hook Hook, payload Payload) error {
+	return d.deliver(hook, payload, 1)
+}
+
+func (d *Dispatcher) deliver(hook Hook, payload Payload, maxAttempts int) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("could not marshal webhook payload: %v", err)
+	}
+
+	backoff := d.Backoff
+	for attempt := 1; ; attempt++ {
+		statusCode, err := d.send(hook, payload.Event, body)
+		deliveryErr := ""
+		if err != nil {
+			deliveryErr = err.Error()
+		}
+		if d.Log != nil {
+			if logErr := d.Log.LogWebhookDelivery(hook.ID, payload.Event, string(body), attempt, statusCode, deliveryErr); logErr != nil {
+				slog.Error("could not log webhook delivery", "webhook_id", hook.ID, "error", logErr)
+			}
+		}
+
+		if err == nil {
+			return nil
+		} else if !retryable(statusCode) || attempt >= maxAttempts {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (d *Dispatcher) send(hook Hook, event string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("could not create webhook request: %v", err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Data-Manager-Webhook")
+	req.Header.Set(EventHeader, event)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, "sha256="+Sign(hook.Secret, timestamp, body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("could not deliver webhook: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook receiver responded with %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Network errors, rate limits and server errors are worth retrying, other rejections are not
+func retryable(statusCode int) bool {
+	return statusCode == 0 || statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
@@ -0,0 +1,162 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type delivery struct {
+	webhookID  int
+	event      string
+	payload    string
+	attempt    int
+	statusCode int
+	err        string
+}
+
+type memoryLog struct {
+	sync.Mutex
+	deliveries []delivery
+}
+
+func (l *memoryLog) LogWebhookDelivery(webhookID int, event string, payload string, attempt int, statusCode int, deliveryErr string) error {
+	l.Lock()
+	defer l.Unlock()
+	l.deliveries = append(l.deliveries, delivery{webhookID, event, payload, attempt, statusCode, deliveryErr})
+	return nil
+}
+
+func newTestDispatcher(log DeliveryLog) *Dispatcher {
+	d := NewDispatcher(log, true)
+	d.MaxAttempts = 3
+	d.Backoff = time.Millisecond
+	return d
+}
+
+func TestDeliverSignsPayload(t *testing.T) {
+	var body []byte
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header.Clone()
+	}))
+	defer srv.Close()
+
+	d := newTestDispatcher(nil)
+	err := d.Deliver(Hook{ID: 1, URL: srv.URL, Secret: "secret"}, Payload{Event: CrawlFinished, JobID: "job", OccurredAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := header.Get(EventHeader); got != CrawlFinished {
+		t.Errorf("%s = %q, want %q", EventHeader, got, CrawlFinished)
+	}
+	timestamp := header.Get(TimestampHeader)
+	if timestamp == "" {
+		t.Fatalf("missing %s", TimestampHeader)
+	}
+	want := "sha256=" + Sign("secret", timestamp, body)
+	if got := header.Get(SignatureHeader); got != want {
+		t.Errorf("%s = %q, want %q", SignatureHeader, got, want)
+	}
+	if got := header.Get(SignatureHeader); got == "sha256="+Sign("other", timestamp, body) {
+		t.Error("signature doesn't depend on the secret")
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int // Response to each attempt, the last one repeats
+		wantErr  bool
+		attempts int
+	}{
+		{"success", []int{http.StatusOK}, false, 1},
+		{"server error is retried", []int{http.StatusInternalServerError, http.StatusOK}, false, 2},
+		{"rate limit is retried", []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusNoContent}, false, 3},
+		{"client error is not retried", []int{http.StatusBadRequest}, true, 1},
+		{"not found is not retried", []int{http.StatusNotFound}, true, 1},
+		{"gives up after max attempts", []int{http.StatusBadGateway}, true, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			requests := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				status := tt.statuses[min(requests, len(tt.statuses)-1)]
+				requests++
+				mu.Unlock()
+				w.WriteHeader(status)
+			}))
+			defer srv.Close()
+
+			log := &memoryLog{}
+			d := newTestDispatcher(log)
+			err := d.Deliver(Hook{ID: 7, URL: srv.URL, Secret: "secret"}, Payload{Event: CrawlStarted})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Deliver() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if requests != tt.attempts {
+				t.Errorf("receiver got %d requests, want %d", requests, tt.attempts)
+			}
+
+			// Every attempt is logged, with its outcome
+			if len(log.deliveries) != tt.attempts {
+				t.Fatalf("logged %d deliveries, want %d", len(log.deliveries), tt.attempts)
+			}
+			for i, got := range log.deliveries {
+				status := tt.statuses[min(i, len(tt.statuses)-1)]
+				if got.webhookID != 7 || got.event != CrawlStarted || got.attempt != i+1 || got.statusCode != status {
+					t.Errorf("delivery %d = %+v, want attempt %d with status %d", i, got, i+1, status)
+				}
+				if !strings.Contains(got.payload, CrawlStarted) {
+					t.Errorf("delivery %d payload = %q, want the event body", i, got.payload)
+				}
+				if failed := status < 200 || status > 299; failed != (got.err != "") {
+					t.Errorf("delivery %d error = %q for status %d", i, got.err, status)
+				}
+			}
+		})
+	}
+}
+
+func TestDeliverOnceDoesNotRetry(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	log := &memoryLog{}
+	if err := newTestDispatcher(log).DeliverOnce(Hook{URL: srv.URL}, Payload{Event: Test}); err == nil {
+		t.Error("DeliverOnce() succeeded against a failing receiver")
+	}
+	if requests != 1 || len(log.deliveries) != 1 {
+		t.Errorf("got %d requests and %d logged deliveries, want 1", requests, len(log.deliveries))
+	}
+}
+
+func TestDeliverDoesNotFollowRedirects(t *testing.T) {
+	redirected := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved" {
+			redirected = true
+			return
+		}
+		http.Redirect(w, r, "/moved", http.StatusTemporaryRedirect)
+	}))
+	defer srv.Close()
+
+	if err := newTestDispatcher(nil).DeliverOnce(Hook{URL: srv.URL}, Payload{Event: Test}); err == nil {
+		t.Error("redirect was treated as a successful delivery")
+	}
+	if redirected {
+		t.Error("redirect was followed")
+	}
+}
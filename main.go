@@ -1,65 +1,121 @@
 package main
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/Ztkent/data-manager/internal/auth"
 	"github.com/Ztkent/data-manager/internal/db"
+	"github.com/Ztkent/data-manager/internal/email"
+	"github.com/Ztkent/data-manager/internal/logging"
+	"github.com/Ztkent/data-manager/internal/metrics"
 	"github.com/Ztkent/data-manager/internal/routes"
+	"github.com/Ztkent/data-manager/internal/sso"
+	"github.com/Ztkent/data-manager/internal/tracing"
+	"github.com/Ztkent/data-manager/internal/webhook"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/httprate"
 )
 
 func main() {
+	// Log JSON at the level set by LOG_LEVEL
+	logging.Setup()
+
+	// Export traces if TRACING_ENABLED is set
+	if err := tracing.Setup(context.Background()); err != nil {
+		slog.Error("could not set up tracing", "error", err)
+	}
+
 	// Handle any required environment variables
 	checkRequiredEnvs()
 
 	// Connect Redis
 	redis, err := db.ConnectRedis()
 	if err != nil {
-		log.Fatal("Failed to Connect to Redis: " + err.Error())
+		logging.Fatal("failed to connect to redis", err)
 	}
-	fmt.Println("Successfully connected to Redis")
+	slog.Info("connected to redis")
 
 	// Connect PG
 	pgDB, err := db.ConnectPostgres()
 	if err != nil {
-		log.Fatal("Failed to Connect to PG: " + err.Error())
+		logging.Fatal("failed to connect to postgres", err)
 	}
-	fmt.Println("Successfully connected to PG")
+	slog.Info("connected to postgres")
 
 	// Initialize crawl master, which will manage all crawl users
+	masterDB := db.NewMasterDatabase(pgDB)
 	crawlMaster := routes.CrawlMaster{
 		ActiveManagers: make(map[string]*routes.CrawlManager),
-		DB:             db.NewMasterDatabase(pgDB),
+		DB:             masterDB,
 		Redis:          redis,
+		Webhooks:       webhook.NewDispatcher(masterDB, os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true"),
+		Email:          email.NewSenderFromEnv(),
+		SSO:            sso.NewProvidersFromEnv(context.Background()),
+	}
+
+	// Make sure the operators listed in ADMIN_EMAILS can administer the system
+	if admins := os.Getenv("ADMIN_EMAILS"); admins != "" {
+		err = masterDB.PromoteAdmins(context.Background(), strings.Split(strings.ReplaceAll(admins, " ", ""), ","))
+		if err != nil {
+			logging.Fatal("failed to promote admins", err)
+		}
+	}
+
+	// Jobs that were running when the server stopped won't finish
+	err = masterDB.AbandonRunningCrawlJobs(context.Background())
+	if err != nil {
+		slog.Error("could not abandon running crawl jobs", "error", err)
 	}
 
+	// Export metrics about the server and its dependencies
+	metrics.RegisterPostgres(pgDB)
+	metrics.RegisterRedis(redis)
+	metrics.RegisterCrawlers(crawlMaster.CrawlerCounts)
+	metrics.RegisterSQLite(crawlMaster.SQLiteStats)
+
 	// Initialize router and middleware
 	r := chi.NewRouter()
-	// Log request and recover from panics
-	r.Use(middleware.Logger)
+	// Tag each request with an id, trace it, log it and recover from panics
+	r.Use(logging.RequestIDMiddleware)
+	r.Use(tracing.Middleware)
+	r.Use(logging.AccessLog)
 	r.Use(middleware.Recoverer)
+	// Time each request by route
+	r.Use(metrics.Middleware)
 
 	// Define routes
 	defineRoutes(r, &crawlMaster)
 
+	// Serve metrics on their own listener when METRICS_ADDR is set, so they can be kept off the public port
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", crawlMaster.MetricsHandler(false))
+			slog.Info("metrics server is running", "address", addr)
+			logging.Fatal("metrics server stopped", http.ListenAndServe(addr, mux))
+		}()
+	} else if os.Getenv("METRICS_TOKEN") == "" {
+		slog.Warn("metrics are not served, set METRICS_TOKEN or METRICS_ADDR to enable them")
+	}
+
 	// Handle any finished crawlers
 	go crawlMaster.HandleFinishedCrawlers()
 	go crawlMaster.ResourceManger()
+	go crawlMaster.AccountDeleter()
 
 	// Start server
-	fmt.Println("Server is running on port 8080")
+	slog.Info("server is running", "port", 8080)
 	if os.Getenv("ENV") == "dev" {
-		log.Fatal(http.ListenAndServe(":8080", r))
+		logging.Fatal("server stopped", http.ListenAndServe(":8080", r))
 	}
-	log.Fatal(http.ListenAndServeTLS(":8080", os.Getenv("CERT_PATH"), os.Getenv("CERT_KEY_PATH"), r))
+	logging.Fatal("server stopped", http.ListenAndServeTLS(":8080", os.Getenv("CERT_PATH"), os.Getenv("CERT_KEY_PATH"), r))
 }
 
 func defineRoutes(r *chi.Mux, crawlMaster *routes.CrawlMaster) {
@@ -69,37 +125,142 @@ func defineRoutes(r *chi.Mux, crawlMaster *routes.CrawlMaster) {
 		60*time.Second, // per duration
 		httprate.WithKeyFuncs(httprate.KeyByIP, httprate.KeyByEndpoint),
 	))
+	// Reject state-changing requests without a CSRF token
+	r.Use(crawlMaster.VerifyCSRF)
+	// Public routes
+	r.Group(func(r chi.Router) {
+		// Static
+		r.Get("/", crawlMaster.ServeHome())                        // Homepage
+		r.Get("/tc", crawlMaster.ServeTC())                        // Terms and Conditions
+		r.Get("/dismiss-toast", crawlMaster.DismissToastHandler()) // Dismiss any toast messages
+		r.Post("/about-modal", crawlMaster.AboutModalHandler())    // About Modal
+
+		// Auth
+		r.Post("/ensure-uuid", crawlMaster.EnsureUUIDHandler())  // Make sure every active user is assigned a UUID
+		r.Post("/login", crawlMaster.Login())                    // Login Modal
+		r.Post("/logout", crawlMaster.Logout())                  // Logout Modal
+		r.Post("/submit-register", crawlMaster.SubmitRegister()) // Submit Registration attempt
+		r.Post("/submit-login", crawlMaster.SubmitLogin())       // Submit Login attempt
+
+		// Two-Factor Authentication
+		r.Post("/submit-two-factor", crawlMaster.SubmitTwoFactor()) // Submit a 2FA code to finish logging in
+
+		// Account
+		r.Post("/verify-email", crawlMaster.VerifyEmailHandler())             // Confirm an email address from a verification link
+		r.Post("/forgot-password", crawlMaster.ForgotPasswordModal())         // Forgot Password Modal
+		r.Post("/submit-forgot-password", crawlMaster.SubmitForgotPassword()) // Request a password reset link
+		r.Post("/reset-password", crawlMaster.ResetPasswordModal())           // Reset Password Modal, opened from a reset link
+		r.Post("/submit-reset-password", crawlMaster.SubmitResetPassword())   // Submit a new password with a reset token
+
+		// Metrics
+		if os.Getenv("METRICS_ADDR") == "" {
+			r.Get("/metrics", crawlMaster.MetricsHandler(true)) // Prometheus metrics, only served with METRICS_TOKEN
+		}
 
-	// Auth
-	r.Post("/ensure-uuid", crawlMaster.EnsureUUIDHandler())         // Make sure every active user is assigned a UUID
-	r.Post("/login", crawlMaster.Login())                           // Login Modal
-	r.Post("/logout", crawlMaster.Logout())                         // Logout Modal
-	r.Post("/submit-register", crawlMaster.SubmitRegister())        // Submit Registration attempt
-	r.Post("/submit-login", crawlMaster.SubmitLogin())              // Submit Login attempt
-	r.Post("/confirm-login", crawlMaster.ConfirmLoginAttempt(true)) // Confirm Login attempt
-	r.Post("/validate-login", crawlMaster.ValidateLogin())          // Validate if active user is logged in
-
-	// Static
-	r.Get("/", crawlMaster.ServeHome())                        // Homepage
-	r.Get("/tc", crawlMaster.ServeTC())                        // Terms and Conditions
-	r.Get("/dismiss-toast", crawlMaster.DismissToastHandler()) // Dismiss any toast messages
-	r.Post("/about-modal", crawlMaster.AboutModalHandler())    // About Modal
-	r.Post("/export-modal", crawlMaster.ExportModal())         // Data Export Modal
-
-	// Network
-	r.Post("/gen-network", crawlMaster.GenNetwork()) // Regularly regenerate network graph
-	r.Get("/network", crawlMaster.ServeNetwork())    // Serve network graph
-	// Crawl
-	r.Post("/crawl", crawlMaster.CrawlHandler())                       // Crawl a specific URL
-	r.Post("/crawl-random", crawlMaster.CrawlRandomHandler())          // Crawl a random URL from the test-sites list
-	r.Post("/kill-crawler", crawlMaster.KillCrawlerHandler())          // Kill a specific crawler
-	r.Post("/kill-all-crawlers", crawlMaster.KillAllCrawlersHandler()) // Kill all crawlers for this user
-	// Data
-	r.Get("/active-crawlers", crawlMaster.ActiveCrawlersHandler())  // Get all active crawlers for this user
-	r.Get("/recent-urls", crawlMaster.RecentURLsHandler())          // Get some recent URLs for this user
-	r.Post("/file-collection", crawlMaster.FileCollectionHandler()) // Get some recent files for this user
-	r.Get("/export", crawlMaster.ExportDB())                        // Handle data export requests
-	r.Get("/download", crawlMaster.Download())                      // Download the requested user files
+		// Single Sign-On
+		r.Get("/oidc/{provider}/login", crawlMaster.SSOLoginHandler())       // Send the user to their identity provider
+		r.Get("/oidc/{provider}/callback", crawlMaster.SSOCallbackHandler()) // Finish signing in when the provider sends them back
+	})
+
+	// Authenticated routes, rejected with a 401 for anonymous users
+	r.Group(func(r chi.Router) {
+		r.Use(crawlMaster.Authenticate)
+
+		// Auth
+		r.Post("/confirm-login", crawlMaster.ConfirmLoginAttempt(true)) // Confirm Login attempt
+		r.Post("/validate-login", crawlMaster.ValidateLogin())          // Validate if active user is logged in
+
+		// Modals
+		r.With(routes.RequirePermission(auth.PermExport)).Post("/export-modal", crawlMaster.ExportModal()) // Data Export Modal
+		r.Post("/account-modal", crawlMaster.AccountModal())                                               // Account Settings Modal
+
+		// Workspaces
+		r.Get("/workspaces", crawlMaster.WorkspacesHandler())                              // List the user's workspaces, and the active workspace's members
+		r.Post("/workspaces", crawlMaster.CreateWorkspaceHandler())                        // Create a team workspace
+		r.Post("/workspaces/switch", crawlMaster.SwitchWorkspaceHandler())                 // Change the active workspace
+		r.Post("/workspaces/members", crawlMaster.SetWorkspaceMemberHandler())             // Invite a member, or change their role
+		r.Post("/workspaces/members/remove", crawlMaster.RemoveWorkspaceMemberHandler())   // Remove a member, or leave the workspace
+		r.Post("/workspaces/invites/accept", crawlMaster.AcceptWorkspaceInviteHandler())   // Join a workspace the user was invited to
+		r.Post("/workspaces/invites/decline", crawlMaster.DeclineWorkspaceInviteHandler()) // Turn down an invite
+		r.Post("/workspaces/invites/cancel", crawlMaster.CancelWorkspaceInviteHandler())   // Withdraw an invite to the active workspace
+
+		// Sessions
+		r.Get("/sessions", crawlMaster.SessionsHandler())                           // List the user's active sessions
+		r.Post("/sessions/revoke", crawlMaster.RevokeSessionHandler())              // Revoke one of the user's other sessions
+		r.Post("/sessions/revoke-others", crawlMaster.RevokeOtherSessionsHandler()) // Revoke every session except this one
+
+		// API Keys
+		r.Get("/api-keys", crawlMaster.APIKeysHandler())              // List the user's API keys
+		r.Post("/api-keys", crawlMaster.CreateAPIKeyHandler())        // Create a new API key
+		r.Post("/api-keys/revoke", crawlMaster.RevokeAPIKeyHandler()) // Revoke an API key
+
+		// Two-Factor Authentication
+		r.Get("/two-factor", crawlMaster.TwoFactorHandler())                     // Show the user's 2FA status
+		r.Post("/two-factor/setup", crawlMaster.SetupTwoFactorHandler())         // Start adding an authenticator app
+		r.Post("/two-factor/enable", crawlMaster.EnableTwoFactorHandler())       // Confirm a code and enable 2FA
+		r.Post("/two-factor/disable", crawlMaster.DisableTwoFactorHandler())     // Disable 2FA
+		r.Post("/two-factor/recovery-codes", crawlMaster.RecoveryCodesHandler()) // Replace the user's recovery codes
+
+		// Webhooks
+		r.Get("/webhooks", crawlMaster.WebhooksHandler())              // List webhooks and recent deliveries
+		r.Post("/webhooks", crawlMaster.CreateWebhookHandler())        // Register a new webhook
+		r.Post("/webhooks/delete", crawlMaster.DeleteWebhookHandler()) // Remove a webhook
+		r.Post("/webhooks/test", crawlMaster.TestWebhookHandler())     // Send a test event to a webhook
+
+		// Account Data
+		r.Get("/account/data", crawlMaster.AccountDataHandler())      // Show the data export and account deletion options
+		r.Get("/account/export", crawlMaster.ExportAccountHandler())  // Download an archive of everything stored about the user
+		r.Post("/account/delete", crawlMaster.DeleteAccountHandler()) // Schedule the user's account for deletion
+
+		// Notifications
+		r.Get("/notification-preferences", crawlMaster.NotificationPreferencesHandler())  // Show email notification preferences
+		r.Post("/notification-preferences", crawlMaster.NotificationPreferencesHandler()) // Update email notification preferences
+
+		// Network
+		r.Post("/gen-network", crawlMaster.GenNetwork()) // Regularly regenerate network graph
+		r.Get("/network", crawlMaster.ServeNetwork())    // Serve network graph
+		// Data
+		r.Get("/active-crawlers", crawlMaster.ActiveCrawlersHandler())                               // Get all active crawlers for this user
+		r.Get("/recent-urls", crawlMaster.RecentURLsHandler())                                       // Get some recent URLs for this user
+		r.Post("/file-collection", crawlMaster.FileCollectionHandler())                              // Get some recent files for this user
+		r.With(routes.RequirePermission(auth.PermExport)).Get("/export", crawlMaster.ExportDB())     // Handle data export requests
+		r.With(routes.RequirePermission(auth.PermDownload)).Get("/download", crawlMaster.Download()) // Download the requested user files
+		r.Get("/search", crawlMaster.SearchHandler())                                                // Search collected URLs and HTML
+		r.Get("/crawl-history", crawlMaster.CrawlHistoryHandler())                                   // List previous crawl runs
+		r.Get("/crawl-diff", crawlMaster.CrawlDiffHandler())                                         // Compare two runs of the same starting URL
+
+		// Admin
+		r.Group(func(r chi.Router) {
+			r.Use(routes.RequirePermission(auth.PermAdmin))
+			r.Post("/admin-modal", crawlMaster.AdminModal())                      // Admin Console Modal
+			r.Get("/admin/health", crawlMaster.AdminHealthHandler())              // Show server health
+			r.Get("/admin/users", crawlMaster.AdminUsersHandler())                // List every user, with their storage and crawlers
+			r.Post("/admin/users/role", crawlMaster.SetUserRoleHandler())         // Change a user's system role
+			r.Post("/admin/users/disable", crawlMaster.DisableUserHandler())      // Disable a user, ending their sessions and crawls
+			r.Post("/admin/users/enable", crawlMaster.EnableUserHandler())        // Let a disabled user log in again
+			r.Post("/admin/users/purge", crawlMaster.PurgeUserDataHandler())      // Delete a user's collected results
+			r.Get("/admin/crawlers", crawlMaster.AdminCrawlersHandler())          // List running crawlers in every workspace
+			r.Post("/admin/crawlers/kill", crawlMaster.AdminKillCrawlerHandler()) // Kill any crawler
+			r.Get("/admin/jobs", crawlMaster.AdminJobsHandler())                  // List recent crawl jobs
+			r.Get("/admin/audit", crawlMaster.AuditLogHandler())                  // Query the audit log by user and time
+		})
+	})
+
+	// Authenticated routes triggered by buttons, anonymous users are told why nothing happened
+	r.Group(func(r chi.Router) {
+		r.Use(crawlMaster.AuthenticateWithToast)
+
+		// Crawl, viewers of a team workspace can't start or stop its crawls
+		r.Group(func(r chi.Router) {
+			r.Use(routes.RequireWorkspaceRole(auth.RoleEditor))
+			r.With(routes.RequirePermission(auth.PermCrawl)).Post("/crawl", crawlMaster.CrawlHandler())                      // Crawl a specific URL
+			r.With(routes.RequirePermission(auth.PermCrawl)).Post("/crawl-random", crawlMaster.CrawlRandomHandler())         // Crawl a random URL from the test-sites list
+			r.With(routes.RequirePermission(auth.PermKill)).Post("/kill-crawler", crawlMaster.KillCrawlerHandler())          // Kill a specific crawler
+			r.With(routes.RequirePermission(auth.PermKill)).Post("/kill-all-crawlers", crawlMaster.KillAllCrawlersHandler()) // Kill all crawlers in this workspace
+		})
+		// Account
+		r.Post("/resend-verification", crawlMaster.ResendVerificationHandler()) // Send a new verification link
+	})
 
 	// Serve static files
 	workDir, _ := os.Getwd()
@@ -137,7 +298,8 @@ func checkRequiredEnvs() {
 	}
 	for _, env := range envs {
 		if value := os.Getenv(env); value == "" {
-			log.Fatalf("%s environment variable is not set", env)
+			slog.Error("environment variable is not set", "env", env)
+			os.Exit(1)
 		}
 	}
 }